@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	pb "image-manager/pkg/pb"
+)
+
+// Встроенные проверки здоровья загрузки. Включаются в плане по имени (builtin_checks в YAML дистрибутива).
+var builtinChecks = map[string]func(ctx context.Context, c *pb.Check, plan *pb.TestPlan) *pb.CheckResult{
+	"root_fs_size":     checkRootFSSize,
+	"cloud_init":       checkCloudInit,
+	"failed_units":     checkFailedUnits,
+	"time_sync":        checkTimeSync,
+	"dns":              checkDNS,
+	"metadata":         checkMetadata,
+	"qemu_guest_agent": checkQemuGuestAgent,
+	"ssh_host_keys":    checkSSHHostKeys,
+	"agent_gated":      checkAgentGated,
+}
+
+// Таймауты по умолчанию для проверок, которые ждут окончания загрузки
+var builtinTimeouts = map[string]time.Duration{
+	"cloud_init": 5 * time.Minute,
+	"time_sync":  2 * time.Minute,
+}
+
+// Корень считаем расширенным, если он не меньше этой доли диска (таблица разделов, /boot, EFI, резерв ФС)
+const rootFSMinRatio = 0.85
+
+// Интервал повторов для проверок, которым нужно «дождаться» состояния
+const builtinPollInterval = 3 * time.Second
+
+func runBuiltin(ctx context.Context, c *pb.Check, plan *pb.TestPlan) *pb.CheckResult {
+	fn, ok := builtinChecks[c.Builtin]
+	if !ok {
+		return &pb.CheckResult{Status: statusError, Message: fmt.Sprintf("unknown builtin check %q", c.Builtin)}
+	}
+	return fn(ctx, c, plan)
+}
+
+// checkRootFSSize — growpart/resize отработали: корень занимает диск флейвора.
+func checkRootFSSize(_ context.Context, _ *pb.Check, plan *pb.TestPlan) *pb.CheckResult {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs("/", &st); err != nil {
+		return &pb.CheckResult{Status: statusError, Message: err.Error()}
+	}
+	sizeGB := float64(st.Blocks) * float64(st.Bsize) / (1 << 30)
+	actual := fmt.Sprintf("%.1f GiB", sizeGB)
+
+	if plan.RootDiskGb <= 0 {
+		return &pb.CheckResult{Status: statusSkip, Actual: actual, Message: "flavor disk size unknown"}
+	}
+
+	minGB := float64(plan.RootDiskGb) * rootFSMinRatio
+	res := &pb.CheckResult{
+		Status:   statusPass,
+		Expected: fmt.Sprintf(">= %.1f GiB (flavor disk %d GB)", minGB, plan.RootDiskGb),
+		Actual:   actual,
+	}
+	if sizeGB < minGB {
+		res.Status = statusFail
+		res.Message = fmt.Sprintf("root filesystem is %s, not resized to flavor disk %d GB", actual, plan.RootDiskGb)
+	}
+	return res
+}
+
+// checkCloudInit ждет завершения cloud-init и проверяет, что он закончился без ошибок.
+func checkCloudInit(ctx context.Context, _ *pb.Check, _ *pb.TestPlan) *pb.CheckResult {
+	cmd := exec.CommandContext(ctx, "cloud-init", "status", "--wait", "--long")
+	out, err := cmd.Output()
+	res := &pb.CheckResult{Expected: "status: done", Stdout: excerpt(string(out))}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		res.Status = statusFail
+		res.Message = "cloud-init did not finish in time"
+		return res
+	}
+
+	status := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "status:"); ok {
+			status = strings.TrimSpace(v)
+			break
+		}
+	}
+	res.Actual = "status: " + status
+
+	// Новые версии возвращают 2 при recoverable errors (degraded done)
+	if err != nil || status != "done" {
+		res.Status = statusFail
+		res.Message = "cloud-init finished with errors"
+		if err != nil {
+			res.Message += ": " + err.Error()
+		}
+		return res
+	}
+	res.Status = statusPass
+	return res
+}
+
+// checkFailedUnits — systemctl --failed пуст.
+func checkFailedUnits(ctx context.Context, _ *pb.Check, _ *pb.TestPlan) *pb.CheckResult {
+	out, err := exec.CommandContext(ctx, "systemctl", "--failed", "--no-legend", "--plain").Output()
+	if err != nil {
+		return &pb.CheckResult{Status: statusError, Message: err.Error()}
+	}
+
+	var units []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			units = append(units, fields[0])
+		}
+	}
+
+	res := &pb.CheckResult{Status: statusPass, Expected: "no failed units", Actual: fmt.Sprintf("%d failed", len(units))}
+	if len(units) > 0 {
+		res.Status = statusFail
+		res.Message = "failed units: " + strings.Join(units, ", ")
+		res.Stdout = excerpt(string(out))
+	}
+	return res
+}
+
+// checkTimeSync ждет, пока systemd сообщит о синхронизированном времени.
+func checkTimeSync(ctx context.Context, _ *pb.Check, _ *pb.TestPlan) *pb.CheckResult {
+	res := &pb.CheckResult{Expected: "NTPSynchronized=yes"}
+	for {
+		out, err := exec.CommandContext(ctx, "timedatectl", "show", "-p", "NTPSynchronized", "--value").Output()
+		value := strings.TrimSpace(string(out))
+		if err == nil && value == "yes" {
+			res.Status = statusPass
+			res.Actual = "NTPSynchronized=yes"
+			return res
+		}
+		res.Actual = "NTPSynchronized=" + value
+
+		select {
+		case <-ctx.Done():
+			res.Status = statusFail
+			res.Message = "time is not synchronized"
+			if err != nil {
+				res.Message += ": " + err.Error()
+			}
+			return res
+		case <-time.After(builtinPollInterval):
+		}
+	}
+}
+
+// checkDNS — резолвер настроен (DHCP/cloud-init) и отвечает.
+func checkDNS(ctx context.Context, c *pb.Check, _ *pb.TestPlan) *pb.CheckResult {
+	host := c.Target
+	if host == "" {
+		host = "dns.google"
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	res := &pb.CheckResult{Expected: "resolve " + host}
+	if err != nil {
+		res.Status = statusFail
+		res.Actual = err.Error()
+		res.Message = err.Error()
+		return res
+	}
+	res.Status = statusPass
+	res.Actual = strings.Join(addrs, ", ")
+	return res
+}
+
+// checkMetadata — сервис метаданных OpenStack доступен.
+func checkMetadata(ctx context.Context, c *pb.Check, _ *pb.TestPlan) *pb.CheckResult {
+	url := c.Target
+	if url == "" {
+		url = metadataURL
+	}
+	res := &pb.CheckResult{Expected: "HTTP 200 from " + url}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return &pb.CheckResult{Status: statusError, Message: err.Error()}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		res.Status = statusFail
+		res.Actual = err.Error()
+		res.Message = err.Error()
+		return res
+	}
+	resp.Body.Close()
+
+	res.Actual = resp.Status
+	if resp.StatusCode != http.StatusOK {
+		res.Status = statusFail
+		res.Message = "metadata service returned " + resp.Status
+		return res
+	}
+	res.Status = statusPass
+	return res
+}
+
+func checkQemuGuestAgent(ctx context.Context, _ *pb.Check, _ *pb.TestPlan) *pb.CheckResult {
+	return checkService(ctx, &pb.Check{Service: "qemu-guest-agent"})
+}
+
+// Ключи хоста sshd (переменная — для тестов)
+var sshHostKeysGlob = "/etc/ssh/ssh_host_*_key"
+
+// checkSSHHostKeys — ключи хоста созданы при первой загрузке VM (sysprep их удалил, cloud-init/sshd сгенерировал заново).
+// Ключи старше времени загрузки значит, что они запечены в образ и одинаковы у всех VM.
+func checkSSHHostKeys(_ context.Context, _ *pb.Check, _ *pb.TestPlan) *pb.CheckResult {
+	res := &pb.CheckResult{Expected: "host keys generated after boot"}
+
+	keys, _ := filepath.Glob(sshHostKeysGlob)
+	if len(keys) == 0 {
+		res.Status = statusFail
+		res.Actual = "no host keys"
+		res.Message = "no SSH host keys in /etc/ssh"
+		return res
+	}
+
+	boot, err := firstBootTime()
+	if err != nil {
+		return &pb.CheckResult{Status: statusError, Message: err.Error()}
+	}
+
+	var stale []string
+	for _, key := range keys {
+		info, err := os.Stat(key)
+		if err != nil {
+			return &pb.CheckResult{Status: statusError, Message: err.Error()}
+		}
+		if info.ModTime().Before(boot) {
+			stale = append(stale, fmt.Sprintf("%s (%s)", filepath.Base(key), info.ModTime().UTC().Format(time.RFC3339)))
+		}
+	}
+
+	res.Actual = fmt.Sprintf("%d keys, %d older than boot", len(keys), len(stale))
+	if len(stale) > 0 {
+		res.Status = statusFail
+		res.Message = "host keys baked into image: " + strings.Join(stale, ", ")
+		return res
+	}
+	res.Status = statusPass
+	return res
+}
+
+// Юнит агента, который ставит элемент agent-install
+const agentUnit = "image-agent.service"
+
+// unitFile возвращает содержимое юнита вместе с drop-in (переменная — для тестов).
+var unitFile = func(ctx context.Context, unit string) ([]byte, error) {
+	return exec.CommandContext(ctx, "systemctl", "cat", unit).CombinedOutput()
+}
+
+// checkAgentGated — агент в выпускаемом образе не активен без токена сборки:
+// юнит стартует только при наличии токена, а сам токен пришел в user data первой загрузки VM, а не запечен в образ.
+func checkAgentGated(ctx context.Context, _ *pb.Check, _ *pb.TestPlan) *pb.CheckResult {
+	tokenPath := agentTokenPath()
+	condition := "ConditionPathExists=" + tokenPath
+	res := &pb.CheckResult{Expected: condition + ", token written after boot"}
+
+	out, err := unitFile(ctx, agentUnit)
+	if err != nil {
+		return &pb.CheckResult{Status: statusError, Message: fmt.Sprintf("systemctl cat %s: %v: %s", agentUnit, err, strings.TrimSpace(string(out)))}
+	}
+	gated := false
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == condition {
+			gated = true
+			break
+		}
+	}
+	if !gated {
+		res.Status = statusFail
+		res.Actual = "no " + condition
+		res.Message = agentUnit + " starts without a build token: production VMs will run the agent"
+		return res
+	}
+
+	info, err := os.Stat(tokenPath)
+	if err != nil {
+		return &pb.CheckResult{Status: statusError, Message: err.Error()}
+	}
+	boot, err := firstBootTime()
+	if err != nil {
+		return &pb.CheckResult{Status: statusError, Message: err.Error()}
+	}
+	if info.ModTime().Before(boot) {
+		res.Status = statusFail
+		res.Actual = "token modified " + info.ModTime().UTC().Format(time.RFC3339)
+		res.Message = "build token is older than boot: baked into image instead of user data"
+		return res
+	}
+
+	res.Status = statusPass
+	res.Actual = "gated, token from user data"
+	return res
+}
+
+// firstBootTime — время первой загрузки VM. cloud-init пишет токен (write_files) и генерирует
+// ключи хоста только на ней: на второй загрузке (reboot_test) они старше текущего btime,
+// поэтому сравнивать надо с btime из маркера перезагрузки.
+func firstBootTime() (time.Time, error) {
+	if bootMarker != nil && !bootMarker.BootTime.IsZero() {
+		return bootMarker.BootTime, nil
+	}
+	return bootTime()
+}
+
+// bootTime читает время загрузки (btime) из /proc/stat.
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "btime "); ok {
+			sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("btime not found in /proc/stat")
+}
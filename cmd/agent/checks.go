@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "image-manager/pkg/pb"
+)
+
+// Таймаут проверки, если в плане не задан
+const defaultCheckTimeout = 30 * time.Second
+
+// Сколько вывода оставлять в результате (отчет может уйти через серийную консоль)
+const maxExcerpt = 512
+
+// Статусы результата проверки.
+const (
+	statusPass  = "PASS"
+	statusFail  = "FAIL"
+	statusError = "ERROR"
+	statusSkip  = "SKIP"
+)
+
+// defaultPlan — проверки на случай, если менеджер не отдал план (старый менеджер или нет связи).
+func defaultPlan() *pb.TestPlan {
+	return &pb.TestPlan{Checks: []*pb.Check{
+		{Name: "root-fs", Type: "command", Command: "ls /"},
+		{Name: "internet", Type: "command", Command: "ping -c 1 8.8.8.8"},
+	}}
+}
+
+// runPlan выполняет все проверки плана по порядку (провал одной не останавливает остальные).
+// onResult (может быть nil) вызывается сразу после каждой проверки — для прогресса в сессии.
+func runPlan(plan *pb.TestPlan, onResult func(*pb.CheckResult)) []*pb.CheckResult {
+	results := make([]*pb.CheckResult, 0, len(plan.Checks))
+	for _, c := range plan.Checks {
+		start := time.Now()
+		res := runCheck(c, plan)
+		res.Name = c.Name
+		res.DurationMs = time.Since(start).Milliseconds()
+		results = append(results, res)
+		if onResult != nil {
+			onResult(res)
+		}
+	}
+	return results
+}
+
+// summarize сворачивает результаты в success/details для отчета (details читают старые менеджеры).
+func summarize(results []*pb.CheckResult) (bool, string) {
+	success := true
+	parts := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.Status == statusFail || r.Status == statusError {
+			success = false
+		}
+		parts = append(parts, formatResult(r))
+	}
+	return success, strings.Join(parts, "; ")
+}
+
+func formatResult(r *pb.CheckResult) string {
+	switch r.Status {
+	case statusPass:
+		return r.Name + ": OK"
+	case statusSkip:
+		return r.Name + ": SKIP (" + r.Message + ")"
+	}
+	return r.Name + ": " + r.Status + ": " + r.Message
+}
+
+func runCheck(c *pb.Check, plan *pb.TestPlan) *pb.CheckResult {
+	timeout := defaultCheckTimeout
+	if t, ok := builtinTimeouts[c.Builtin]; ok && c.Type == "builtin" {
+		timeout = t
+	}
+	if c.TimeoutSeconds > 0 {
+		timeout = time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch c.Type {
+	case "command":
+		return checkCommand(ctx, c)
+	case "file":
+		return checkFile(c)
+	case "service":
+		return checkService(ctx, c)
+	case "port":
+		return checkPort(c)
+	case "builtin":
+		return runBuiltin(ctx, c, plan)
+	case checkTypeDropIn:
+		return checkDropIn(ctx, c)
+	case checkTypeReboot:
+		return runRebootCheck(c)
+	default:
+		return &pb.CheckResult{Status: statusError, Message: fmt.Sprintf("unknown check type %q", c.Type)}
+	}
+}
+
+func checkCommand(ctx context.Context, c *pb.Check) *pb.CheckResult {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Как и у drop-in: потомки sh не должны держать проверку после таймаута
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+
+	res := &pb.CheckResult{Stdout: excerpt(stdout.String()), Stderr: excerpt(stderr.String())}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		res.Status = statusFail
+		res.Message = "timed out"
+		return res
+	}
+
+	code := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			res.Status = statusError
+			res.Message = err.Error()
+			return res
+		}
+		code = exitErr.ExitCode()
+	}
+
+	expected := c.ExpectExitCodes
+	if len(expected) == 0 {
+		expected = []int32{0}
+	}
+	if !slices.Contains(expected, int32(code)) {
+		res.Status = statusFail
+		res.Expected = fmt.Sprintf("exit code %v", expected)
+		res.Actual = fmt.Sprintf("exit code %d", code)
+		res.Message = res.Actual
+		return res
+	}
+
+	matchContent(res, c, stdout.String(), "output")
+	return res
+}
+
+func checkFile(c *pb.Check) *pb.CheckResult {
+	res := &pb.CheckResult{}
+
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		res.Status = statusFail
+		res.Expected = c.Path + " readable"
+		res.Actual = err.Error()
+		res.Message = err.Error()
+		return res
+	}
+
+	matchContent(res, c, string(data), c.Path)
+	return res
+}
+
+// matchContent проверяет contains/matches для вывода команды или содержимого файла
+// и выставляет статус результата.
+func matchContent(res *pb.CheckResult, c *pb.Check, content, what string) {
+	res.Status = statusPass
+
+	if c.Contains != "" && !strings.Contains(content, c.Contains) {
+		res.Status = statusFail
+		res.Expected = "contains " + strconv.Quote(c.Contains)
+		res.Actual = excerpt(content)
+		res.Message = fmt.Sprintf("%s does not contain %q", what, c.Contains)
+		return
+	}
+
+	if c.Matches != "" {
+		re, err := regexp.Compile(c.Matches)
+		if err != nil {
+			res.Status = statusError
+			res.Message = "invalid regexp: " + err.Error()
+			return
+		}
+		if !re.MatchString(content) {
+			res.Status = statusFail
+			res.Expected = "matches " + strconv.Quote(c.Matches)
+			res.Actual = excerpt(content)
+			res.Message = fmt.Sprintf("%s does not match %q", what, c.Matches)
+		}
+	}
+}
+
+func checkService(ctx context.Context, c *pb.Check) *pb.CheckResult {
+	out, _ := exec.CommandContext(ctx, "systemctl", "is-active", c.Service).Output()
+	state := strings.TrimSpace(string(out))
+	if state == "" {
+		state = "unknown"
+	}
+
+	res := &pb.CheckResult{Status: statusPass, Expected: "active", Actual: state}
+	if state != "active" {
+		res.Status = statusFail
+		res.Message = fmt.Sprintf("service %s is %s", c.Service, state)
+	}
+	return res
+}
+
+// checkPort ищет сокет в состоянии LISTEN (tcp) или любой привязанный (udp) в /proc/net.
+func checkPort(c *pb.Check) *pb.CheckResult {
+	proto := c.Protocol
+	if proto == "" {
+		proto = "tcp"
+	}
+	res := &pb.CheckResult{Expected: fmt.Sprintf("%s/%d listening", proto, c.Port)}
+
+	for _, file := range []string{"/proc/net/" + proto, "/proc/net/" + proto + "6"} {
+		found, err := portInProcNet(file, int(c.Port), proto == "tcp")
+		if err != nil && !os.IsNotExist(err) {
+			res.Status = statusError
+			res.Message = err.Error()
+			return res
+		}
+		if found {
+			res.Status = statusPass
+			res.Actual = "listening"
+			return res
+		}
+	}
+
+	res.Status = statusFail
+	res.Actual = "not listening"
+	res.Message = fmt.Sprintf("nothing listens on %s/%d", proto, c.Port)
+	return res
+}
+
+func portInProcNet(path string, port int, needListen bool) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Scan() // Заголовок
+	for sc.Scan() {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		idx := strings.LastIndex(fields[1], ":")
+		if idx < 0 {
+			continue
+		}
+		p, err := strconv.ParseUint(fields[1][idx+1:], 16, 32)
+		if err != nil || int(p) != port {
+			continue
+		}
+		// 0A = TCP_LISTEN
+		if needListen && fields[3] != "0A" {
+			continue
+		}
+		return true, nil
+	}
+	return false, sc.Err()
+}
+
+// excerpt обрезает вывод до maxExcerpt байт (хвост обычно информативнее — оставляем его).
+// Невалидный UTF-8 вычищается: иначе proto не сериализует строку.
+func excerpt(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxExcerpt {
+		s = "..." + s[len(s)-maxExcerpt:]
+	}
+	return strings.ToValidUTF8(s, "")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "image-manager/pkg/pb"
+)
+
+func TestCheckCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		check  *pb.Check
+		status string
+	}{
+		{"exit zero", &pb.Check{Command: "true"}, statusPass},
+		{"exit non-zero", &pb.Check{Command: "exit 3"}, statusFail},
+		{"expected exit code", &pb.Check{Command: "exit 3", ExpectExitCodes: []int32{0, 3}}, statusPass},
+		{"contains", &pb.Check{Command: "echo hello world", Contains: "world"}, statusPass},
+		{"does not contain", &pb.Check{Command: "echo hello", Contains: "world"}, statusFail},
+		{"matches", &pb.Check{Command: "echo version 1.2.3", Matches: `\d+\.\d+\.\d+`}, statusPass},
+		{"does not match", &pb.Check{Command: "echo version", Matches: `\d+`}, statusFail},
+		{"invalid regexp", &pb.Check{Command: "echo x", Matches: "("}, statusError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := checkCommand(context.Background(), tt.check)
+			if res.Status != tt.status {
+				t.Errorf("status = %s, want %s (message %q)", res.Status, tt.status, res.Message)
+			}
+		})
+	}
+}
+
+func TestCheckCommandTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	res := checkCommand(ctx, &pb.Check{Command: "sleep 5"})
+	if res.Status != statusFail || res.Message != "timed out" {
+		t.Errorf("got %s %q, want FAIL \"timed out\"", res.Status, res.Message)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshd_config")
+	if err := os.WriteFile(path, []byte("PermitRootLogin no\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		check  *pb.Check
+		status string
+	}{
+		{"exists", &pb.Check{Path: path}, statusPass},
+		{"missing", &pb.Check{Path: path + ".missing"}, statusFail},
+		{"contains", &pb.Check{Path: path, Contains: "PermitRootLogin no"}, statusPass},
+		{"does not match", &pb.Check{Path: path, Matches: `^PermitRootLogin yes`}, statusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := checkFile(tt.check); res.Status != tt.status {
+				t.Errorf("status = %s, want %s (message %q)", res.Status, tt.status, res.Message)
+			}
+		})
+	}
+}
+
+func TestRunCheckUnknownType(t *testing.T) {
+	res := runCheck(&pb.Check{Type: "telepathy"}, &pb.TestPlan{})
+	if res.Status != statusError {
+		t.Errorf("status = %s, want ERROR", res.Status)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []*pb.CheckResult
+		success bool
+		details string
+	}{
+		{"empty", nil, true, ""},
+		{
+			"pass and skip",
+			[]*pb.CheckResult{{Name: "a", Status: statusPass}, {Name: "b", Status: statusSkip, Message: "no systemd"}},
+			true, "a: OK; b: SKIP (no systemd)",
+		},
+		{
+			"fail",
+			[]*pb.CheckResult{{Name: "a", Status: statusPass}, {Name: "b", Status: statusFail, Message: "exit code 1"}},
+			false, "a: OK; b: FAIL: exit code 1",
+		},
+		{"error", []*pb.CheckResult{{Name: "a", Status: statusError, Message: "boom"}}, false, "a: ERROR: boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			success, details := summarize(tt.results)
+			if success != tt.success || details != tt.details {
+				t.Errorf("got (%v, %q), want (%v, %q)", success, details, tt.success, tt.details)
+			}
+		})
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	if got := excerpt("  short\n"); got != "short" {
+		t.Errorf("excerpt trims: got %q", got)
+	}
+
+	long := strings.Repeat("a", maxExcerpt) + "tail"
+	got := excerpt(long)
+	if !strings.HasPrefix(got, "...") || !strings.HasSuffix(got, "tail") || len(got) != maxExcerpt+3 {
+		t.Errorf("excerpt keeps the tail: got %d bytes", len(got))
+	}
+
+	if got := excerpt("ok\xffok"); got != "okok" {
+		t.Errorf("excerpt drops invalid UTF-8: got %q", got)
+	}
+}
+
+func TestSelectChecks(t *testing.T) {
+	checks := []*pb.Check{
+		{Name: "root-fs", Type: "command"},
+		{Name: "dns", Type: "builtin"},
+		{Name: "checks.d/10-resolv-conf", Type: checkTypeDropIn},
+	}
+
+	selected, unknown := selectChecks(checks, []string{"10-resolv-conf", "root-fs", "nope"})
+	if names := checkNames(selected); strings.Join(names, ",") != "root-fs,checks.d/10-resolv-conf" {
+		t.Errorf("selected = %v, want plan order", names)
+	}
+	if strings.Join(unknown, ",") != "nope" {
+		t.Errorf("unknown = %v, want [nope]", unknown)
+	}
+}
+
+func TestParseCheckNames(t *testing.T) {
+	got := parseCheckNames(" root-fs, ,dns,")
+	if strings.Join(got, "|") != "root-fs|dns" {
+		t.Errorf("got %q", got)
+	}
+	if parseCheckNames("") != nil {
+		t.Error("empty value must select all checks")
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	pb "image-manager/pkg/pb"
+)
+
+const commandCollectDiagnostics = "COLLECT_DIAGNOSTICS"
+
+const (
+	diagCommandTimeout = 30 * time.Second
+	maxDiagFileSize    = 8 << 20 // Длиннее — оставляем хвост (конец журнала интереснее начала)
+	diagChunkSize      = 256 << 10
+	uploadTimeout      = 2 * time.Minute
+	uploadAttempts     = 3
+
+	standbyDuration = 2 * time.Hour // Сколько агент ждет запросов диагностики после провала
+)
+
+// diagItem — один файл архива: вывод фиксированной команды или содержимое файла VM.
+type diagItem struct {
+	file    string
+	command []string
+	path    string
+}
+
+// diagnosticsCollectors — все, что агент согласен собрать. Менеджер выбирает сборщики по имени,
+// произвольных команд и путей он передать не может.
+var diagnosticsCollectors = map[string][]diagItem{
+	"journal": {
+		{file: "journal.txt", command: []string{"journalctl", "-b", "--no-pager", "-o", "short-precise"}},
+	},
+	"dmesg": {
+		{file: "dmesg.txt", command: []string{"dmesg", "-T"}},
+	},
+	"cloud_init": {
+		{file: "cloud-init.log", path: "/var/log/cloud-init.log"},
+		{file: "cloud-init-output.log", path: "/var/log/cloud-init-output.log"},
+		{file: "cloud-init-status.txt", command: []string{"cloud-init", "status", "--long"}},
+	},
+	"network": {
+		{file: "ip-addr.txt", command: []string{"ip", "addr"}},
+		{file: "ip-route.txt", command: []string{"ip", "route"}},
+		{file: "ip6-route.txt", command: []string{"ip", "-6", "route"}},
+		{file: "resolv.conf", path: "/etc/resolv.conf"},
+	},
+	"df": {
+		{file: "df.txt", command: []string{"df", "-h"}},
+		{file: "df-inodes.txt", command: []string{"df", "-i"}},
+	},
+	"failed_units": {
+		{file: "failed-units.txt", command: []string{"systemctl", "list-units", "--failed", "--all", "--no-pager"}},
+	},
+	"packages": {
+		{file: "packages.txt", command: []string{"sh", "-c", "dpkg-query -W 2>/dev/null || rpm -qa"}},
+	},
+}
+
+// buildDiagnostics собирает архив tar.gz по запрошенным сборщикам.
+// Ошибки отдельных команд не прерывают сбор: они попадают в manifest.txt.
+func buildDiagnostics(names []string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	var manifest strings.Builder
+	fmt.Fprintf(&manifest, "collected: %s\n", time.Now().UTC().Format(time.RFC3339))
+
+	for _, name := range names {
+		items, ok := diagnosticsCollectors[name]
+		if !ok {
+			fmt.Fprintf(&manifest, "%s: unknown collector, skipped\n", name)
+			continue
+		}
+		for _, item := range items {
+			data, note := collectItem(item)
+			fmt.Fprintf(&manifest, "%s/%s: %s\n", name, item.file, note)
+			if err := addTarFile(tw, name+"/"+item.file, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := addTarFile(tw, "manifest.txt", []byte(manifest.String())); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// collectItem возвращает содержимое для архива и строку для манифеста.
+func collectItem(item diagItem) ([]byte, string) {
+	if item.path != "" {
+		data, err := os.ReadFile(item.path)
+		if err != nil {
+			return nil, "error: " + err.Error()
+		}
+		data, note := tail(data)
+		return data, "ok (" + item.path + ")" + note
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagCommandTimeout)
+	defer cancel()
+
+	cmdline := strings.Join(item.command, " ")
+	out, err := exec.CommandContext(ctx, item.command[0], item.command[1:]...).CombinedOutput()
+	out, note := tail(out)
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return out, fmt.Sprintf("timeout after %s (%s)%s", diagCommandTimeout, cmdline, note)
+	case errors.As(err, &exitErr):
+		return out, fmt.Sprintf("exit code %d (%s)%s", exitErr.ExitCode(), cmdline, note)
+	case err != nil:
+		return out, fmt.Sprintf("error: %v (%s)", err, cmdline)
+	}
+	return out, "ok (" + cmdline + ")" + note
+}
+
+func tail(data []byte) ([]byte, string) {
+	if len(data) <= maxDiagFileSize {
+		return data, ""
+	}
+	return data[len(data)-maxDiagFileSize:], fmt.Sprintf(", truncated to last %d bytes of %d", maxDiagFileSize, len(data))
+}
+
+func addTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// uploadDiagnostics заливает архив кусками (gRPC UploadDiagnostics или POST /api/agent/diagnostics).
+func uploadDiagnostics(vmID, token string, bundle []byte) error {
+	chunks := splitChunks(vmID, token, bundle)
+
+	ack := &pb.DiagnosticsAck{}
+	err := callTimeout(uploadTimeout,
+		func(ctx context.Context, c pb.AgentServiceClient) error {
+			stream, err := c.UploadDiagnostics(ctx)
+			if err != nil {
+				return err
+			}
+			for _, chunk := range chunks {
+				if err := stream.Send(chunk); err != nil {
+					break // Причину вернет CloseAndRecv
+				}
+			}
+			resp, err := stream.CloseAndRecv()
+			if err != nil {
+				return err
+			}
+			ack = resp
+			return nil
+		},
+		func() error {
+			for _, chunk := range chunks {
+				if err := postJSON("/api/agent/diagnostics", chunk, ack); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if !ack.Complete {
+		return fmt.Errorf("manager did not confirm diagnostics (received %d of %d bytes)", ack.Received, len(bundle))
+	}
+	return nil
+}
+
+func splitChunks(vmID, token string, bundle []byte) []*pb.DiagnosticsChunk {
+	var chunks []*pb.DiagnosticsChunk
+	for off := 0; off == 0 || off < len(bundle); off += diagChunkSize {
+		end := min(off+diagChunkSize, len(bundle))
+		chunks = append(chunks, &pb.DiagnosticsChunk{
+			VmId:   vmID,
+			Token:  token,
+			Offset: int64(off),
+			Data:   bundle[off:end],
+			Last:   end == len(bundle),
+		})
+	}
+	return chunks
+}
+
+// collectDiagnostics собирает и заливает диагностику по команде менеджера, с повторами.
+func (s *session) collectDiagnostics(names []string) {
+	log.Printf("Collecting diagnostics: %s", strings.Join(names, ", "))
+	bundle, err := buildDiagnostics(names)
+	if err != nil {
+		log.Printf("Failed to build diagnostics bundle: %v", err)
+		return
+	}
+
+	for i := 0; i < uploadAttempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff(i))
+		}
+		err = uploadDiagnostics(s.vmID, s.token, bundle)
+		if err == nil {
+			log.Printf("Diagnostics uploaded (%d bytes)", len(bundle))
+			return
+		}
+		log.Printf("Failed to upload diagnostics (attempt %d/%d): %v", i+1, uploadAttempts, err)
+		if permanent(err) {
+			return
+		}
+	}
+}
+
+// standby держит агента на связи после провала тестов: VM оставлена для отладки,
+// и менеджер может запросить диагностику еще раз (POST /api/build/{id}/diagnostics).
+func (s *session) standby(d time.Duration) {
+	if s.legacy {
+		return
+	}
+	log.Printf("Tests failed, staying in standby for %s", d)
+
+	deadline := time.Now().Add(d)
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if time.Now().After(deadline) {
+			return
+		}
+		resp, err := s.send(phaseHeartbeat, 1)
+		if err != nil {
+			if permanent(err) {
+				return
+			}
+			continue
+		}
+		switch resp.Command {
+		case commandCollectDiagnostics:
+			s.collectDiagnostics(resp.Collect)
+		case "SHUTDOWN":
+			return
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	pb "image-manager/pkg/pb"
+)
+
+// Каталог drop-in проверок: исполняемые файлы кладет элемент agent-checks (agent_checks в YAML дистрибутива)
+const defaultChecksDir = "/etc/image-agent/checks.d"
+
+const checkTypeDropIn = "dropin"
+
+// Коды выхода drop-in проверки. Остальные коды — ERROR (проверка сама сломалась).
+const (
+	dropInExitPass = 0
+	dropInExitFail = 1
+	dropInExitSkip = 77 // Как в automake: проверка неприменима на этой VM
+)
+
+// Сколько первых строк файла просматриваем в поисках заголовка "# timeout: 2m"
+const dropInHeaderLines = 10
+
+// checksDir — каталог drop-in проверок (--checks-dir, AGENT_CHECKS_DIR или путь по умолчанию).
+func checksDir() string {
+	if *flagChecksDir != "" {
+		return *flagChecksDir
+	}
+	if dir := os.Getenv("AGENT_CHECKS_DIR"); dir != "" {
+		return dir
+	}
+	return defaultChecksDir
+}
+
+// discoverDropIns находит исполняемые файлы каталога и превращает их в проверки плана.
+// Порядок — по имени файла (как run-parts); скрытые, *.disabled и неисполняемые файлы пропускаются.
+func discoverDropIns(dir string) []*pb.Check {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read checks dir %s: %v", dir, err)
+		}
+		return nil
+	}
+
+	var checks []*pb.Check
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".disabled") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil || info.Mode()&0o111 == 0 {
+			log.Printf("Skipping non-executable drop-in check %s", path)
+			continue
+		}
+
+		checks = append(checks, &pb.Check{
+			Name:           "checks.d/" + name,
+			Type:           checkTypeDropIn,
+			Path:           path,
+			TimeoutSeconds: int32(dropInTimeout(path).Seconds()),
+		})
+	}
+	return checks
+}
+
+// dropInTimeout читает таймаут из заголовка скрипта ("# timeout: 2m"); 0 — по умолчанию.
+func dropInTimeout(path string) time.Duration {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for i := 0; i < dropInHeaderLines && sc.Scan(); i++ {
+		line := strings.TrimSpace(strings.TrimLeft(sc.Text(), "#/ "))
+		if v, ok := strings.CutPrefix(line, "timeout:"); ok {
+			d, err := time.ParseDuration(strings.TrimSpace(v))
+			if err != nil {
+				log.Printf("Invalid timeout in %s: %v", path, err)
+				return 0
+			}
+			return d
+		}
+	}
+	return 0
+}
+
+// checkDropIn запускает drop-in проверку. Строки stdout вида "expected: ...", "actual: ...",
+// "message: ..." переходят в одноименные поля результата, остальной вывод — в stdout.
+func checkDropIn(ctx context.Context, c *pb.Check) *pb.CheckResult {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Дочерние процессы скрипта держат stdout открытым и после убийства по таймауту — не ждем их
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+
+	res := &pb.CheckResult{Stderr: excerpt(stderr.String())}
+	var rest []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		key, value, _ := strings.Cut(line, ":")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "expected":
+			res.Expected = strings.TrimSpace(value)
+		case "actual":
+			res.Actual = strings.TrimSpace(value)
+		case "message":
+			res.Message = strings.TrimSpace(value)
+		default:
+			rest = append(rest, line)
+		}
+	}
+	res.Stdout = excerpt(strings.Join(rest, "\n"))
+
+	if ctx.Err() == context.DeadlineExceeded {
+		res.Status = statusFail
+		res.Message = "timed out"
+		return res
+	}
+
+	code := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		res.Status = statusError
+		res.Message = err.Error()
+		return res
+	}
+
+	switch code {
+	case dropInExitPass:
+		res.Status = statusPass
+	case dropInExitFail:
+		res.Status = statusFail
+	case dropInExitSkip:
+		res.Status = statusSkip
+	default:
+		res.Status = statusError
+		if res.Message == "" {
+			res.Message = fmt.Sprintf("unexpected exit code %d", code)
+		}
+	}
+	return res
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "image-manager/pkg/pb"
+)
+
+// writeScript создает исполняемый drop-in в dir.
+func writeScript(t *testing.T, dir, name, body string, mode os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), mode); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckDropIn(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		body    string
+		status  string
+		message string
+	}{
+		{"pass", "exit 0\n", statusPass, ""},
+		{"fail", "echo 'message: no nameservers'\nexit 1\n", statusFail, "no nameservers"},
+		{"skip", "echo 'message: not applicable'\nexit 77\n", statusSkip, "not applicable"},
+		{"unexpected code", "exit 3\n", statusError, "unexpected exit code 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeScript(t, dir, tt.name, tt.body, 0o755)
+			res := checkDropIn(context.Background(), &pb.Check{Path: path})
+			if res.Status != tt.status || res.Message != tt.message {
+				t.Errorf("got %s %q, want %s %q", res.Status, res.Message, tt.status, tt.message)
+			}
+		})
+	}
+}
+
+func TestCheckDropInFields(t *testing.T) {
+	path := writeScript(t, t.TempDir(), "fields", `echo "checking..."
+echo "expected: 3 nameservers"
+echo "Actual: 1 nameservers"
+exit 1
+`, 0o755)
+
+	res := checkDropIn(context.Background(), &pb.Check{Path: path})
+	if res.Expected != "3 nameservers" || res.Actual != "1 nameservers" || res.Stdout != "checking..." {
+		t.Errorf("got expected=%q actual=%q stdout=%q", res.Expected, res.Actual, res.Stdout)
+	}
+}
+
+func TestCheckDropInTimeout(t *testing.T) {
+	path := writeScript(t, t.TempDir(), "slow", "sleep 5\n", 0o755)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	res := checkDropIn(ctx, &pb.Check{Path: path})
+	if res.Status != statusFail || res.Message != "timed out" {
+		t.Errorf("got %s %q, want FAIL \"timed out\"", res.Status, res.Message)
+	}
+}
+
+func TestDropInTimeout(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		body string
+		want time.Duration
+	}{
+		{"header", "# timeout: 2m\nexit 0\n", 2 * time.Minute},
+		{"no header", "exit 0\n", 0},
+		{"invalid", "# timeout: soon\nexit 0\n", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeScript(t, dir, tt.name, tt.body, 0o755)
+			if got := dropInTimeout(path); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverDropIns(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "20-second", "exit 0\n", 0o755)
+	writeScript(t, dir, "10-first", "# timeout: 45s\nexit 0\n", 0o755)
+	writeScript(t, dir, ".hidden", "exit 0\n", 0o755)
+	writeScript(t, dir, "30-off.disabled", "exit 0\n", 0o755)
+	writeScript(t, dir, "40-not-exec", "exit 0\n", 0o644)
+	if err := os.Mkdir(filepath.Join(dir, "50-dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := discoverDropIns(dir)
+	if len(checks) != 2 {
+		t.Fatalf("got %v, want 10-first and 20-second", checkNames(checks))
+	}
+	if checks[0].Name != "checks.d/10-first" || checks[0].TimeoutSeconds != 45 || checks[0].Type != checkTypeDropIn {
+		t.Errorf("first check = %v", checks[0])
+	}
+	if checks[1].Name != "checks.d/20-second" {
+		t.Errorf("second check = %v", checks[1])
+	}
+
+	if got := discoverDropIns(filepath.Join(dir, "missing")); got != nil {
+		t.Errorf("missing dir: got %v", got)
+	}
+}
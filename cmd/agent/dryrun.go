@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pb "image-manager/pkg/pb"
+)
+
+const phaseDryRun = "DRY_RUN"
+
+// runDryRun прогоняет проверки локально и печатает отчет в JSON (тот же StatusRequest, что уходит
+// менеджеру) в stdout; журнал проверок — в stderr. Код выхода: 0 — все прошло, 1 — есть провалы,
+// 2 — неверные аргументы.
+func runDryRun(agentStart float64, only []string) int {
+	plan := localPlan()
+	plan.Checks = append(plan.Checks, discoverDropIns(checksDir())...)
+
+	if len(only) > 0 {
+		selected, unknown := selectChecks(plan.Checks, only)
+		if len(unknown) > 0 {
+			log.Printf("Unknown checks: %s. Available: %s", strings.Join(unknown, ", "), strings.Join(checkNames(plan.Checks), ", "))
+			return 2
+		}
+		plan.Checks = selected
+	}
+
+	results := runPlan(plan, func(r *pb.CheckResult) {
+		log.Printf("Check %s", formatResult(r))
+	})
+	success, details := summarize(results)
+
+	report := &pb.StatusRequest{
+		Phase:   phaseDryRun,
+		Success: success,
+		Details: details,
+		Results: results,
+		Facts:   collectFacts(agentStart),
+	}
+	out, err := protojson.MarshalOptions{Multiline: true, Indent: "  ", UseProtoNames: true}.Marshal(report)
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 2
+	}
+	fmt.Println(string(out))
+
+	if !success {
+		return 1
+	}
+	return 0
+}
+
+// localPlan — план без менеджера: проверки по умолчанию и все встроенные проверки агента.
+func localPlan() *pb.TestPlan {
+	plan := defaultPlan()
+
+	names := make([]string, 0, len(builtinChecks))
+	for name := range builtinChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		plan.Checks = append(plan.Checks, &pb.Check{Name: name, Type: "builtin", Builtin: name})
+	}
+	return plan
+}
+
+// parseCheckNames разбирает значение --checks ("a, b,c").
+func parseCheckNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// selectChecks оставляет из checks только названные (в порядке плана) и возвращает имена,
+// которых в плане нет. Drop-in проверку можно назвать и без префикса "checks.d/".
+func selectChecks(checks []*pb.Check, only []string) ([]*pb.Check, []string) {
+	matches := func(c *pb.Check, name string) bool {
+		return c.Name == name || (c.Type == checkTypeDropIn && c.Name == "checks.d/"+name)
+	}
+
+	var selected []*pb.Check
+	for _, c := range checks {
+		if slices.ContainsFunc(only, func(name string) bool { return matches(c, name) }) {
+			selected = append(selected, c)
+		}
+	}
+
+	var unknown []string
+	for _, name := range only {
+		if !slices.ContainsFunc(checks, func(c *pb.Check) bool { return matches(c, name) }) {
+			unknown = append(unknown, name)
+		}
+	}
+	return selected, unknown
+}
+
+func checkNames(checks []*pb.Check) []string {
+	names := make([]string, 0, len(checks))
+	for _, c := range checks {
+		names = append(names, c.Name)
+	}
+	return names
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "image-manager/pkg/pb"
+)
+
+// systemd-analyze отвечает "Bootup is not yet finished", пока грузятся юниты — ждем до этого предела
+const bootAnalyzeWait = 30 * time.Second
+
+// collectFacts собирает факты о VM для итогового отчета. agentStart — uptime в момент старта агента.
+// Ошибки не фатальны: неизвестное значение остается нулевым, менеджер его пропустит.
+func collectFacts(agentStart float64) *pb.HostFacts {
+	f := &pb.HostFacts{
+		Vcpus:             int32(runtime.NumCPU()),
+		MemoryMb:          memTotalMB(),
+		Disks:             blockDisks(),
+		Nics:              nics(),
+		Kernel:            kernelRelease(),
+		VirtioDrivers:     virtioDrivers(),
+		Firmware:          "bios",
+		BootSeconds:       bootSeconds(),
+		AgentStartSeconds: agentStart,
+	}
+	if _, err := os.Stat("/sys/firmware/efi"); err == nil {
+		f.Firmware = "uefi"
+	}
+	return f
+}
+
+// uptimeSeconds читает /proc/uptime (0 — не удалось).
+func uptimeSeconds() float64 {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+	v, _ := strconv.ParseFloat(fields[0], 64)
+	return v
+}
+
+func memTotalMB() int64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "MemTotal:"); ok {
+			kb, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(v), " kB"), 10, 64)
+			return kb / 1024
+		}
+	}
+	return 0
+}
+
+// blockDisks — физические диски из /sys/block (без loop, ram, cdrom и device-mapper).
+func blockDisks() []*pb.DiskFact {
+	entries, _ := os.ReadDir("/sys/block")
+
+	var disks []*pb.DiskFact
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") ||
+			strings.HasPrefix(name, "sr") || strings.HasPrefix(name, "dm-") || strings.HasPrefix(name, "zram") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("/sys/block", name, "size"))
+		if err != nil {
+			continue
+		}
+		sectors, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		// /sys/block/*/size всегда в 512-байтных секторах
+		disks = append(disks, &pb.DiskFact{Name: name, SizeBytes: sectors * 512})
+	}
+	return disks
+}
+
+func nics() []*pb.NicFact {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var result []*pb.NicFact
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		result = append(result, &pb.NicFact{Name: iface.Name, Mtu: int32(iface.MTU), Mac: iface.HardwareAddr.String()})
+	}
+	return result
+}
+
+func kernelRelease() string {
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// virtioDrivers — драйверы, зарегистрированные на шине virtio (и модулями, и встроенные в ядро).
+func virtioDrivers() []string {
+	entries, _ := os.ReadDir("/sys/bus/virtio/drivers")
+
+	drivers := make([]string, 0, len(entries))
+	for _, e := range entries {
+		drivers = append(drivers, e.Name())
+	}
+	sort.Strings(drivers)
+	return drivers
+}
+
+// bootSeconds — итог systemd-analyze ("Startup finished in ... = 7.412s"), 0 — неизвестно.
+// Ждем только пока загрузка не закончена; в chroot или без systemd сразу сдаемся.
+func bootSeconds() float64 {
+	deadline := time.Now().Add(bootAnalyzeWait)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		out, err := exec.CommandContext(ctx, "systemd-analyze").CombinedOutput()
+		cancel()
+		if err == nil {
+			return parseStartupTotal(string(out))
+		}
+		if !strings.Contains(string(out), "not yet finished") || time.Now().After(deadline) {
+			return 0
+		}
+		time.Sleep(builtinPollInterval)
+	}
+}
+
+// parseStartupTotal разбирает первую строку systemd-analyze. Длительность — в формате systemd
+// ("1min 2.345s", "850ms"), приводим ее к формату Go.
+func parseStartupTotal(out string) float64 {
+	line, _, _ := strings.Cut(out, "\n")
+	_, total, ok := strings.Cut(line, "= ")
+	if !ok {
+		return 0
+	}
+	total = strings.ReplaceAll(strings.TrimSpace(total), "min", "m")
+	d, err := time.ParseDuration(strings.ReplaceAll(total, " ", ""))
+	if err != nil {
+		return 0
+	}
+	return d.Seconds()
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestParseStartupTotal(t *testing.T) {
+	tests := []struct {
+		out  string
+		want float64
+	}{
+		{"Startup finished in 2.112s (kernel) + 5.300s (userspace) = 7.412s\ngraphical.target reached", 7.412},
+		{"Startup finished in 4.5s (firmware) + 30.1s (kernel) + 1min 2.5s (userspace) = 1min 37.100s", 97.1},
+		{"Startup finished in 300ms (kernel) + 550ms (userspace) = 850ms", 0.85},
+		{"Bootup is not yet finished", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := parseStartupTotal(tt.out); got < tt.want-1e-9 || got > tt.want+1e-9 {
+			t.Errorf("parseStartupTotal(%q) = %v, want %v", tt.out, got, tt.want)
+		}
+	}
+}
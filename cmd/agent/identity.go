@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// VM ID, если ни один источник не ответил (менеджер такую VM не найдет, но отчет уйдет в лог)
+const unknownVMID = "unknown-id"
+
+const (
+	configDriveLabel   = "/dev/disk/by-label/config-2"
+	instanceDataPath   = "/run/cloud-init/instance-data.json"
+	dmiProductUUID     = "/sys/class/dmi/id/product_uuid"
+	identityCmdTimeout = 10 * time.Second
+)
+
+var uuidRe = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// identitySource — способ узнать UUID сервера Nova. attempts — сколько раз пробовать с backoff:
+// сеть и cloud-init после загрузки поднимаются не сразу, а DMI либо есть, либо нет.
+type identitySource struct {
+	name     string
+	attempts int
+	read     func() (string, error)
+}
+
+// identitySources — по убыванию надежности. Сервис метаданных бывает выключен (config drive вместо него),
+// cloud-init может быть не установлен, а DMI UUID совпадает с UUID сервера только под KVM/libvirt.
+var identitySources = []identitySource{
+	{name: "metadata", attempts: 5, read: vmIDFromMetadata},
+	{name: "config_drive", attempts: 2, read: vmIDFromConfigDrive},
+	{name: "cloud_init", attempts: 3, read: vmIDFromInstanceData},
+	{name: "dmi", attempts: 1, read: vmIDFromDMI},
+}
+
+// discoverVMID перебирает источники и возвращает UUID VM и имя источника, который его дал.
+func discoverVMID() (string, string) {
+	for _, src := range identitySources {
+		for i := 0; i < src.attempts; i++ {
+			if i > 0 {
+				time.Sleep(backoff(i))
+			}
+			id, err := src.read()
+			if err == nil {
+				id = strings.ToLower(strings.TrimSpace(id))
+				if uuidRe.MatchString(id) {
+					return id, src.name
+				}
+				err = fmt.Errorf("not a UUID: %q", id)
+			}
+			log.Printf("VM ID from %s failed (attempt %d/%d): %v", src.name, i+1, src.attempts, err)
+			if errors.Is(err, os.ErrNotExist) {
+				break // Источника на этой VM нет: повтор не поможет
+			}
+		}
+	}
+	return unknownVMID, ""
+}
+
+// vmIDFromMetadata стучится в OpenStack Metadata Service и узнает свой UUID.
+func vmIDFromMetadata() (string, error) {
+	// Делаем GET запрос с таймаутом (чтобы не висеть вечно)
+	client := http.Client{
+		Timeout: 2 * time.Second,
+	}
+
+	resp, err := client.Get(metadataURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return parseMetaData(body)
+}
+
+// vmIDFromConfigDrive монтирует config drive (iso9660 с меткой config-2) только на чтение
+// и читает тот же meta_data.json, что отдает сервис метаданных.
+func vmIDFromConfigDrive() (string, error) {
+	if _, err := os.Stat(configDriveLabel); err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp("", "config-drive-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), identityCmdTimeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, "mount", "-o", "ro", configDriveLabel, dir).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("mount %s: %v: %s", configDriveLabel, err, strings.TrimSpace(string(out)))
+	}
+	defer exec.Command("umount", dir).Run()
+
+	data, err := os.ReadFile(filepath.Join(dir, "openstack", "latest", "meta_data.json"))
+	if err != nil {
+		// Не ErrNotExist наружу: диск есть, просто не смонтировался вовремя — стоит повторить
+		return "", fmt.Errorf("read config drive: %v", err)
+	}
+	return parseMetaData(data)
+}
+
+// vmIDFromInstanceData читает кэш cloud-init: он уже сходил в metadata или config drive за нас.
+func vmIDFromInstanceData() (string, error) {
+	data, err := os.ReadFile(instanceDataPath)
+	if err != nil {
+		return "", err
+	}
+
+	var inst struct {
+		V1 struct {
+			InstanceID string `json:"instance_id"`
+		} `json:"v1"`
+	}
+	if err := json.Unmarshal(data, &inst); err != nil {
+		return "", fmt.Errorf("parse %s: %w", instanceDataPath, err)
+	}
+	if inst.V1.InstanceID == "" {
+		return "", fmt.Errorf("%s has no v1.instance_id", instanceDataPath)
+	}
+	return inst.V1.InstanceID, nil
+}
+
+// vmIDFromDMI — SMBIOS UUID: Nova под libvirt выставляет его равным UUID сервера.
+func vmIDFromDMI() (string, error) {
+	data, err := os.ReadFile(dmiProductUUID)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// parseMetaData достает uuid из meta_data.json (сервис метаданных или config drive).
+func parseMetaData(body []byte) (string, error) {
+	var meta struct {
+		UUID string `json:"uuid"`
+	}
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return "", fmt.Errorf("parse metadata JSON: %w", err)
+	}
+	if meta.UUID == "" {
+		return "", errors.New("metadata has no uuid")
+	}
+	return meta.UUID, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDiscoverVMID(t *testing.T) {
+	saved := identitySources
+	t.Cleanup(func() { identitySources = saved })
+
+	const id = "0b6c1d2e-3f40-4a5b-8c6d-7e8f90a1b2c3"
+	calls := map[string]int{}
+	source := func(name string, attempts int, read func() (string, error)) identitySource {
+		return identitySource{name: name, attempts: attempts, read: func() (string, error) {
+			calls[name]++
+			return read()
+		}}
+	}
+
+	identitySources = []identitySource{
+		source("metadata", 1, func() (string, error) { return "", errors.New("connection refused") }),
+		source("config_drive", 3, func() (string, error) { return "", fmt.Errorf("stat: %w", os.ErrNotExist) }),
+		source("cloud_init", 1, func() (string, error) { return "not-a-uuid", nil }),
+		source("dmi", 1, func() (string, error) { return " " + "0B6C1D2E-3F40-4A5B-8C6D-7E8F90A1B2C3\n", nil }),
+	}
+
+	gotID, gotSource := discoverVMID()
+	if gotID != id || gotSource != "dmi" {
+		t.Errorf("got (%q, %q), want (%q, dmi)", gotID, gotSource, id)
+	}
+	if calls["config_drive"] != 1 {
+		t.Errorf("missing source retried %d times, want 1", calls["config_drive"])
+	}
+
+	identitySources = identitySources[:3]
+	if gotID, gotSource := discoverVMID(); gotID != unknownVMID || gotSource != "" {
+		t.Errorf("no sources: got (%q, %q)", gotID, gotSource)
+	}
+}
+
+func TestParseMetaData(t *testing.T) {
+	if id, err := parseMetaData([]byte(`{"uuid": "abc", "name": "vm"}`)); err != nil || id != "abc" {
+		t.Errorf("got (%q, %v)", id, err)
+	}
+	if _, err := parseMetaData([]byte(`{"name": "vm"}`)); err == nil {
+		t.Error("expected error for metadata without uuid")
+	}
+	if _, err := parseMetaData([]byte(`<html>`)); err == nil {
+		t.Error("expected error for non-JSON metadata")
+	}
+}
@@ -1,121 +1,165 @@
 package main
 
 import (
-	"context"
-	"encoding/json" // <-- Нужно для разбора JSON от OpenStack
-	"io"            // <-- Нужно для чтения ответа
+	"flag"
 	"log"
-	"net/http" // <-- Нужно для запроса к Metadata
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-
 	pb "image-manager/pkg/pb"
 )
 
-// getVMID стучится в OpenStack Metadata Service и узнает свой UUID.
-func getVMID() string {
-	// Адрес сервиса метаданных в OpenStack стандартный:
-	url := "http://169.254.169.254/openstack/latest/meta_data.json"
+// Адрес сервиса метаданных в OpenStack стандартный
+const defaultMetadataURL = "http://169.254.169.254/openstack/latest/meta_data.json"
 
-	// Делаем GET запрос с таймаутом (чтобы не висеть вечно)
-	client := http.Client{
-		Timeout: 2 * time.Second,
-	}
+// metadataURL — сервис метаданных (переопределяется флагом --metadata-url).
+var metadataURL = defaultMetadataURL
 
-	resp, err := client.Get(url)
-	if err != nil {
-		log.Printf("Failed to get metadata from %s: %v", url, err)
-		return "unknown-id" // Если не ssудалось, вернем заглушку
-	}
-	defer resp.Body.Close()
+// Флаги для локальных прогонов (в chroot или на своей VM). Без флагов агент работает как раньше:
+// получает план у менеджера и отчитывается ему.
+var (
+	flagDryRun      = flag.Bool("dry-run", false, "run checks locally and print a JSON report to stdout, without contacting the manager")
+	flagChecks      = flag.String("checks", "", "comma-separated names of checks to run (default: all)")
+	flagMetadataURL = flag.String("metadata-url", defaultMetadataURL, "OpenStack metadata endpoint")
+	flagChecksDir   = flag.String("checks-dir", "", "drop-in checks directory (default: AGENT_CHECKS_DIR or "+defaultChecksDir+")")
+)
 
-	// Читаем тело ответа
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Failed to read metadata body: %v", err)
-		return "unknown-id"
-	}
+// Путь к токену сборки. Его кладет cloud-init из user data тестовой VM.
+const defaultTokenPath = "/etc/image-manager-agent.token"
 
-	// Парсим JSON. Нам нужно поле "uuid".
-	var meta struct {
-		UUID string `json:"uuid"`
+// agentTokenPath — путь к токену (AGENT_TOKEN_FILE или путь по умолчанию).
+func agentTokenPath() string {
+	if path := os.Getenv("AGENT_TOKEN_FILE"); path != "" {
+		return path
 	}
+	return defaultTokenPath
+}
 
-	if err := json.Unmarshal(body, &meta); err != nil {
-		log.Printf("Failed to parse metadata JSON: %v. Body: %s", err, string(body))
-		return "unknown-id"
-	}
+// readAgentToken читает одноразовый токен, без которого менеджер не примет отчет.
+func readAgentToken() string {
+	path := agentTokenPath()
 
-	return meta.UUID
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read agent token from %s: %v", path, err)
+		return ""
+	}
+	return strings.TrimSpace(string(data))
 }
 
 func main() {
-	log.Println("Agent started...")
+	flag.Parse()
+	metadataURL = *flagMetadataURL
+	only := parseCheckNames(*flagChecks)
 
-	managerAddress := os.Getenv("MANAGER_ADDRESS")
-	if managerAddress == "" {
-		// Fallback для локальной отладки, если забыли прокинуть
-		managerAddress = "127.0.0.1:50051"
-		log.Printf("MANAGER_ADDRESS not set, defaulting to %s", managerAddress)
+	agentStart := uptimeSeconds() // Время от загрузки до старта агента — один из фактов отчета
+
+	if *flagDryRun {
+		os.Exit(runDryRun(agentStart, only))
 	}
 
-	// 1. Узнаем, кто мы (получаем ID)
-	vmID := getVMID()
-	log.Printf("Detected VM ID: %s", vmID)
+	log.Println("Agent started...")
 
-	// 2. Подключаемся к Менеджеру
-	conn, err := grpc.Dial(managerAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		log.Fatalf("did not connect to manager: %v", err)
+	// Токен проверяем до поиска ID: боевой VM незачем ждать metadata с ретраями
+	token := readAgentToken()
+	if token == "" {
+		// Без токена сборки это не тестовая VM, а боевая из выпущенного образа: молча выходим
+		log.Println("No build token, not a test VM. Agent stays inactive.")
+		os.Exit(0)
 	}
-	defer conn.Close()
 
-	client := pb.NewAgentServiceClient(conn)
-
-	// 3. Выполняем проверки (Smoke Tests)
+	// 1. Узнаем, кто мы (получаем ID): metadata, config drive, кэш cloud-init или DMI
+	vmID, idSource := discoverVMID()
+	log.Printf("Detected VM ID: %s (source: %s)", vmID, idSource)
+
+	// Сессия с менеджером: START сразу, дальше heartbeat и прогресс по каждой проверке.
+	// Watchdog менеджера отсчитывает тишину от последнего сообщения, поэтому долгие проверки не убивают VM.
+	// Маркер от первой загрузки — значит, менеджер проверяет вторую (reboot_test)
+	var boot int32
+	boot, bootMarker = currentBoot()
+	if boot > 1 {
+		log.Printf("Second boot after REBOOT (marker from %s)", bootMarker.CreatedAt.Format(time.RFC3339))
+	}
 
-	// Проверка Диска (Root mounted)
-	diskCheck := "OK"
-	if _, err := exec.Command("ls", "/").Output(); err != nil {
-		diskCheck = "FAIL: " + err.Error()
+	sess := newSession(vmID, idSource, token, boot)
+	defer sess.close()
+	if _, err := sess.send(phaseStart, startAttempts); err != nil {
+		log.Printf("Failed to announce start, continuing with checks: %v", err)
 	}
+	stopHeartbeat := sess.keepAlive(heartbeatInterval)
 
-	// TODO: Добавить проверку размера диска (Disk Resize check).
-	// Цель: Убедиться, что cloud-init (growpart) отработал и корневой раздел расширился до размера флейвора.
-	// Логика:
-	// 1. Выполнить `df -BG /`
-	// 2. Проверить, что Size > 10G (или соответствует ожиданиям flavor m1.small).
-	// 3. Если меньше (например 2G), значит ресайз не сработал -> FAIL.
-
-	// Проверка Сети (Ping Google)
-	netCheck := "OK"
-	if err := exec.Command("ping", "-c", "1", "8.8.8.8").Run(); err != nil {
-		netCheck = "FAIL: No Internet"
+	// 2. Получаем план проверок (Smoke Tests) — он описан в YAML дистрибутива на менеджере
+	plan, err := fetchTestPlan(&pb.TestPlanRequest{VmId: vmID, Token: token})
+	if err != nil {
+		log.Printf("Failed to fetch test plan, using built-in checks: %v", err)
+		plan = defaultPlan()
+	}
+	// Проверки образа без Go-кода: исполняемые файлы из checks.d идут после плана менеджера
+	plan.Checks = append(plan.Checks, discoverDropIns(checksDir())...)
+	if bootMarker != nil {
+		plan.Checks = append(plan.Checks, rebootPlan()...)
 	}
+	if len(only) > 0 {
+		var unknown []string
+		plan.Checks, unknown = selectChecks(plan.Checks, only)
+		if len(unknown) > 0 {
+			log.Printf("Unknown checks ignored: %s", strings.Join(unknown, ", "))
+		}
+	}
+	log.Printf("Running %d checks...", len(plan.Checks))
 
-	// 4. Отправляем отчет
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
+	results := runPlan(plan, func(r *pb.CheckResult) {
+		log.Printf("Check %s", formatResult(r))
+		if _, err := sess.send(phaseCheckDone, 1, r); err != nil {
+			log.Printf("Failed to send progress for %s: %v", r.Name, err)
+		}
+	})
+	stopHeartbeat()
+	success, details := summarize(results)
 
+	// 3. Отправляем отчет (транспорт — AGENT_TRANSPORT: grpc, http или auto) с повторами и backoff
 	log.Println("Reporting status to Manager...")
-	resp, err := client.ReportStatus(ctx, &pb.StatusRequest{
-		VmId:    vmID, // <-- ИСПОЛЬЗУЕМ НАСТОЯЩИЙ ID
-		Phase:   "BOOT_CHECK",
-		Success: (netCheck == "OK" && diskCheck == "OK"),
-		Details: "Disk: " + diskCheck + "; Net: " + netCheck,
-	})
+	req := &pb.StatusRequest{
+		VmId:           vmID, // <-- ИСПОЛЬЗУЕМ НАСТОЯЩИЙ ID
+		Phase:          phaseTestsDone,
+		Success:        success,
+		Details:        details,
+		Token:          token,
+		Results:        results,
+		Facts:          collectFacts(agentStart),
+		IdentitySource: idSource,
+		Boot:           boot,
+	}
+	resp, err := sess.exchange(req, reportAttempts)
 
 	if err != nil {
-		log.Fatalf("could not report status: %v", err)
+		log.Printf("could not report status: %v", err)
+
+		// Запасной канал: из сети тенанта может быть не видно менеджера.
+		// Пишем подписанный отчет в серийную консоль, менеджер заберет его через Nova API.
+		if serr := reportViaSerial(req, token); serr != nil {
+			log.Fatalf("could not report status: %v; serial fallback failed: %v", err, serr)
+		}
+
+		// Ответа (команды) по этому каналу нет: VM удалит менеджер
+		log.Println("Report written to serial console. Manager will pick it up.")
+		os.Exit(0)
 	}
 
 	log.Printf("Manager replied: %s", resp.Command)
 
-	// 5. Самоуничтожение (если Менеджер дал добро)
+	// Наблюдение перед promote: VM работает дальше, агент шлет проверки здоровья
+	if resp.Command == commandSoak {
+		resp, err = sess.soak(time.Duration(resp.SoakSeconds) * time.Second)
+		if err != nil {
+			log.Fatalf("Soak aborted: %v", err)
+		}
+		log.Printf("Manager replied: %s", resp.Command)
+	}
+
+	// 4. Самоуничтожение (если Менеджер дал добро)
 	if resp.Command == "OK" || resp.Command == "SHUTDOWN" {
 		log.Println("Mission complete. Self-destructing...")
 
@@ -123,12 +167,32 @@ func main() {
 		exec.Command("systemctl", "disable", "image-agent").Run()
 		os.Remove("/etc/systemd/system/image-agent.service")
 		os.Remove("/usr/local/bin/agent")
+		os.Remove(rebootMarkerPath)
 
 		// Останавливаемся
 		exec.Command("systemctl", "stop", "image-agent").Run()
 		os.Exit(0)
 	}
 
-	// Если что-то пошло не так, висим 10 сек и выходим
-	time.Sleep(10 * time.Second)
+	// Первая загрузка прошла, менеджер проверяет вторую: после перезагрузки агент стартует заново
+	if resp.Command == commandReboot {
+		log.Println("Manager requested reboot to verify the second boot. Rebooting...")
+		err := prepareReboot()
+		if err == nil {
+			os.Exit(0)
+		}
+		// Не смогли перезагрузиться — это провал проверки, а не повод молчать до watchdog
+		log.Printf("Failed to reboot: %v", err)
+		req.Results = append(req.Results, &pb.CheckResult{Name: "reboot:prepare", Status: statusError, Message: err.Error()})
+		req.Success, req.Details = summarize(req.Results)
+		if resp, err = sess.exchange(req, reportAttempts); err != nil {
+			log.Fatalf("could not report reboot failure: %v", err)
+		}
+	}
+
+	// Тесты не прошли: менеджер сразу просит диагностику, дальше ждем на связи повторных запросов
+	if resp.Command == commandCollectDiagnostics {
+		sess.collectDiagnostics(resp.Collect)
+	}
+	sess.standby(standbyDuration)
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	pb "image-manager/pkg/pb"
+)
+
+// REBOOT — менеджер проверяет вторую загрузку (reboot_test): оставить маркер и перезагрузиться
+const commandReboot = "REBOOT"
+
+// Маркер переживает перезагрузку: по нему агент понимает, что это вторая загрузка,
+// и сравнивает состояние VM с первой
+const rebootMarkerPath = "/var/lib/image-agent/reboot-marker.json"
+
+const checkTypeReboot = "reboot"
+
+const (
+	bootIDPath            = "/proc/sys/kernel/random/boot_id"
+	machineIDPath         = "/etc/machine-id"
+	cloudInitInstancePath = "/var/lib/cloud/data/instance-id"
+)
+
+// rebootMarker — состояние VM на первой загрузке.
+type rebootMarker struct {
+	BootID     string            `json:"boot_id"`
+	MachineID  string            `json:"machine_id"`
+	InstanceID string            `json:"cloud_init_instance_id"` // Пусто — cloud-init нет
+	NICs       map[string]string `json:"nics"`                   // MAC -> имя интерфейса
+	BootTime   time.Time         `json:"boot_time"`              // btime первой загрузки
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// bootMarker — маркер первой загрузки (nil — идет первая загрузка). Заполняет main.
+var bootMarker *rebootMarker
+
+// currentBoot возвращает номер загрузки (1 или 2) и маркер первой загрузки.
+// Маркер с текущим boot_id значит, что перезагрузка еще не случилась (агент перезапустили).
+func currentBoot() (int32, *rebootMarker) {
+	data, err := os.ReadFile(rebootMarkerPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read reboot marker: %v", err)
+		}
+		return 1, nil
+	}
+
+	var m rebootMarker
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Printf("Invalid reboot marker %s: %v", rebootMarkerPath, err)
+		return 1, nil
+	}
+	if m.BootID == readTrimmed(bootIDPath) {
+		return 1, nil
+	}
+	return 2, &m
+}
+
+// prepareReboot сохраняет маркер и перезагружает VM.
+func prepareReboot() error {
+	m := rebootMarker{
+		BootID:     readTrimmed(bootIDPath),
+		MachineID:  readTrimmed(machineIDPath),
+		InstanceID: readTrimmed(cloudInitInstancePath),
+		NICs:       make(map[string]string),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if m.BootID == "" {
+		return fmt.Errorf("cannot read %s", bootIDPath)
+	}
+	// Токен и ключи хоста пишутся только на первой загрузке: на второй их сверяют с ней
+	boot, err := bootTime()
+	if err != nil {
+		return err
+	}
+	m.BootTime = boot
+	for _, nic := range nics() {
+		if nic.Mac != "" {
+			m.NICs[nic.Mac] = nic.Name
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(rebootMarkerPath), 0o700); err != nil {
+		return err
+	}
+	if err := writeFileSync(rebootMarkerPath, data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, "systemctl", "reboot").CombinedOutput(); err != nil {
+		os.Remove(rebootMarkerPath)
+		return fmt.Errorf("systemctl reboot: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// writeFileSync пишет файл и сбрасывает его на диск: перезагрузка идет сразу следом.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rebootPlan — проверки второй загрузки: то, что ломается только после перезагрузки.
+func rebootPlan() []*pb.Check {
+	var checks []*pb.Check
+	for _, name := range []string{"boot_id", "machine_id", "nic_names", "cloud_init_instance", "fstab"} {
+		checks = append(checks, &pb.Check{Name: "reboot:" + name, Type: checkTypeReboot, Builtin: name})
+	}
+	return checks
+}
+
+func runRebootCheck(c *pb.Check) *pb.CheckResult {
+	if bootMarker == nil {
+		return &pb.CheckResult{Status: statusSkip, Message: "first boot"}
+	}
+	m := bootMarker
+
+	switch c.Builtin {
+	case "boot_id":
+		// VM действительно перезагрузилась, а не агент перезапустился
+		return compareValue("new boot_id", readTrimmed(bootIDPath), func(v string) bool { return v != "" && v != m.BootID })
+	case "machine_id":
+		// Пустой или пересозданный machine-id меняет DHCP client id, journald и D-Bus
+		return compareValue(m.MachineID, readTrimmed(machineIDPath), func(v string) bool { return v == m.MachineID && v != "" })
+	case "nic_names":
+		return checkNICNames(m.NICs)
+	case "cloud_init_instance":
+		if m.InstanceID == "" {
+			return &pb.CheckResult{Status: statusSkip, Message: "cloud-init instance-id was not present on first boot"}
+		}
+		// Другой instance-id — cloud-init снова выполнит per-instance модули (ключи, пароли, growpart)
+		return compareValue(m.InstanceID, readTrimmed(cloudInitInstancePath), func(v string) bool { return v == m.InstanceID })
+	case "fstab":
+		return checkFstabMounted("/etc/fstab", "/proc/self/mounts")
+	default:
+		return &pb.CheckResult{Status: statusError, Message: fmt.Sprintf("unknown reboot check %q", c.Builtin)}
+	}
+}
+
+func compareValue(expected, actual string, ok func(string) bool) *pb.CheckResult {
+	res := &pb.CheckResult{Status: statusPass, Expected: expected, Actual: actual}
+	if !ok(actual) {
+		res.Status = statusFail
+		res.Message = fmt.Sprintf("expected %s, got %q", expected, actual)
+	}
+	return res
+}
+
+// checkNICNames — интерфейсы с теми же MAC называются так же (нет переименования eth0 -> ens3).
+func checkNICNames(before map[string]string) *pb.CheckResult {
+	now := make(map[string]string)
+	for _, nic := range nics() {
+		now[nic.Mac] = nic.Name
+	}
+
+	var changed []string
+	for mac, name := range before {
+		switch cur, ok := now[mac]; {
+		case !ok:
+			changed = append(changed, fmt.Sprintf("%s (%s) disappeared", name, mac))
+		case cur != name:
+			changed = append(changed, fmt.Sprintf("%s renamed to %s", name, cur))
+		}
+	}
+	slices.Sort(changed)
+
+	res := &pb.CheckResult{Status: statusPass, Expected: "same interface names", Actual: "same interface names"}
+	if len(changed) > 0 {
+		res.Status = statusFail
+		res.Actual = strings.Join(changed, ", ")
+		res.Message = res.Actual
+	}
+	return res
+}
+
+// checkFstabMounted — все записи fstab (кроме swap и noauto) смонтированы.
+func checkFstabMounted(fstabPath, mountsPath string) *pb.CheckResult {
+	wanted, err := fstabMountPoints(fstabPath)
+	if err != nil {
+		return &pb.CheckResult{Status: statusError, Message: err.Error()}
+	}
+	mounted, err := fstabMountPoints(mountsPath) // Тот же формат
+	if err != nil {
+		return &pb.CheckResult{Status: statusError, Message: err.Error()}
+	}
+
+	var missing []string
+	for _, mp := range wanted {
+		if !slices.Contains(mounted, mp) {
+			missing = append(missing, mp)
+		}
+	}
+
+	res := &pb.CheckResult{Status: statusPass, Expected: strings.Join(wanted, ", ") + " mounted", Actual: "all mounted"}
+	if len(missing) > 0 {
+		res.Status = statusFail
+		res.Actual = "not mounted: " + strings.Join(missing, ", ")
+		res.Message = res.Actual
+	}
+	return res
+}
+
+// fstabMountPoints разбирает fstab или /proc/self/mounts и возвращает точки монтирования.
+func fstabMountPoints(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var points []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		mp, fstype := fields[1], fields[2]
+		if fstype == "swap" || mp == "none" {
+			continue
+		}
+		if len(fields) >= 4 && slices.Contains(strings.Split(fields[3], ","), "noauto") {
+			continue
+		}
+		points = append(points, unescapeMount(mp))
+	}
+	return points, sc.Err()
+}
+
+// unescapeMount раскрывает \040 (пробел) и \011 (таб) в путях fstab и /proc/mounts.
+func unescapeMount(s string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t").Replace(s)
+}
+
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	pb "image-manager/pkg/pb"
+)
+
+func TestFstabMountPoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fstab")
+	fstab := `# /etc/fstab
+UUID=1111 /          ext4 defaults        0 1
+UUID=2222 /boot/efi  vfat umask=0077      0 1
+UUID=3333 none       swap sw              0 0
+/dev/vdb  /mnt/data  ext4 defaults,noauto 0 2
+/dev/vdc  /mnt/my\040disk xfs defaults    0 2
+`
+	if err := os.WriteFile(path, []byte(fstab), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fstabMountPoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/", "/boot/efi", "/mnt/my disk"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCheckFstabMounted(t *testing.T) {
+	dir := t.TempDir()
+	fstab := filepath.Join(dir, "fstab")
+	mounts := filepath.Join(dir, "mounts")
+	write := func(path, data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(fstab, "UUID=1 / ext4 defaults 0 1\nUUID=2 /srv ext4 defaults 0 2\n")
+	write(mounts, "/dev/vda1 / ext4 rw,relatime 0 0\nproc /proc proc rw 0 0\n")
+	res := checkFstabMounted(fstab, mounts)
+	if res.Status != statusFail || res.Actual != "not mounted: /srv" {
+		t.Errorf("got %s %q, want FAIL for /srv", res.Status, res.Actual)
+	}
+
+	write(mounts, "/dev/vda1 / ext4 rw 0 0\n/dev/vda2 /srv ext4 rw 0 0\n")
+	if res := checkFstabMounted(fstab, mounts); res.Status != statusPass {
+		t.Errorf("got %s %q, want PASS", res.Status, res.Message)
+	}
+}
+
+func TestRebootCheckOnFirstBoot(t *testing.T) {
+	saved := bootMarker
+	t.Cleanup(func() { bootMarker = saved })
+	bootMarker = nil
+
+	for _, c := range rebootPlan() {
+		if res := runRebootCheck(c); res.Status != statusSkip {
+			t.Errorf("%s: got %s, want SKIP without marker", c.Name, res.Status)
+		}
+	}
+}
+
+func TestBootTimeChecksOnSecondBoot(t *testing.T) {
+	boot, err := bootTime()
+	if err != nil {
+		t.Skipf("no btime: %v", err)
+	}
+
+	// Токен и ключи хоста записаны на первой загрузке — раньше текущей
+	firstBoot := boot.Add(-10 * time.Minute)
+	written := firstBoot.Add(time.Minute)
+	dir := t.TempDir()
+	key := filepath.Join(dir, "ssh_host_ed25519_key")
+	token := filepath.Join(dir, "agent.token")
+	for _, path := range []string{key, token} {
+		if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, written, written); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	savedGlob, savedUnit, savedMarker := sshHostKeysGlob, unitFile, bootMarker
+	t.Cleanup(func() { sshHostKeysGlob, unitFile, bootMarker = savedGlob, savedUnit, savedMarker })
+	sshHostKeysGlob = filepath.Join(dir, "ssh_host_*_key")
+	unitFile = func(context.Context, string) ([]byte, error) {
+		return []byte("[Unit]\nConditionPathExists=" + token + "\n"), nil
+	}
+	t.Setenv("AGENT_TOKEN_FILE", token)
+
+	checks := map[string]func(context.Context, *pb.Check, *pb.TestPlan) *pb.CheckResult{
+		"ssh_host_keys": checkSSHHostKeys,
+		"agent_gated":   checkAgentGated,
+	}
+
+	// Без маркера это первая загрузка: файлы старше нее запечены в образ
+	bootMarker = nil
+	for name, check := range checks {
+		if res := check(context.Background(), &pb.Check{}, &pb.TestPlan{}); res.Status != statusFail {
+			t.Errorf("%s without marker: got %s %q, want FAIL", name, res.Status, res.Message)
+		}
+	}
+
+	bootMarker = &rebootMarker{BootID: "first", BootTime: firstBoot}
+	for name, check := range checks {
+		if res := check(context.Background(), &pb.Check{}, &pb.TestPlan{}); res.Status != statusPass {
+			t.Errorf("%s on second boot: got %s %q, want PASS", name, res.Status, res.Message)
+		}
+	}
+}
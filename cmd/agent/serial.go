@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "image-manager/pkg/pb"
+	"image-manager/pkg/serialreport"
+)
+
+// Устройства, куда пишем отчет. ttyS0 — то, что Nova отдает как console log.
+var serialDevices = []string{"/dev/ttyS0", "/dev/console"}
+
+// reportViaSerial пишет в серийную консоль отчет, подписанный ключом от токена сборки.
+// Менеджер найдет его в Nova console output и обработает как обычный gRPC-отчет.
+func reportViaSerial(req *pb.StatusRequest, token string) error {
+	if token == "" {
+		return fmt.Errorf("no agent token to sign the report")
+	}
+
+	// Консоль читают все, у кого есть доступ к проекту: токен в нее не пишем
+	report := proto.Clone(req).(*pb.StatusRequest)
+	report.Token = ""
+	line, err := serialreport.Encode(report, serialreport.TokenKey(token))
+	if err != nil {
+		return err
+	}
+
+	written := false
+	for _, dev := range serialDevices {
+		f, err := os.OpenFile(dev, os.O_WRONLY, 0)
+		if err != nil {
+			log.Printf("Serial fallback: cannot open %s: %v", dev, err)
+			continue
+		}
+		// \r\n: на серийной консоли одинокий \n может склеить строку с чужим выводом
+		_, err = fmt.Fprintf(f, "\r\n%s\r\n", line)
+		f.Close()
+		if err != nil {
+			log.Printf("Serial fallback: write to %s failed: %v", dev, err)
+			continue
+		}
+		written = true
+	}
+
+	if !written {
+		return fmt.Errorf("no serial device available")
+	}
+	return nil
+}
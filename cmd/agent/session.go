@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "image-manager/pkg/pb"
+)
+
+// Этапы сессии (поле phase). Менеджер фиксирует каждый и отсчитывает watchdog от последнего.
+const (
+	phaseStart     = "START"
+	phaseHeartbeat = "HEARTBEAT"
+	phaseCheckDone = "CHECK_DONE"
+	phaseTestsDone = "TESTS_DONE"
+)
+
+const (
+	heartbeatInterval = 15 * time.Second
+	exchangeTimeout   = 30 * time.Second // Ожидание ответа на одно сообщение стрима
+
+	// Экспоненциальный backoff между попытками: 1s, 2s, 4s ... но не больше maxBackoff
+	baseBackoff = 1 * time.Second
+	maxBackoff  = 30 * time.Second
+
+	startAttempts  = 5 // START: сеть после загрузки может подниматься не сразу (~15s)
+	reportAttempts = 8 // Итоговый отчет: ~2 минуты, потом серийная консоль
+)
+
+// session — канал связи с менеджером на время тестов. В режимах grpc/auto это стрим
+// AgentService.Session с переподключением; в режиме http (и как запасной вариант в auto) —
+// отдельные POST /api/agent/heartbeat и /api/agent/report.
+type session struct {
+	mu        sync.Mutex // Heartbeat идет из отдельной горутины, стрим не потокобезопасен
+	vmID      string
+	idSource  string // Откуда взят vmID (metadata, config_drive, ...) — менеджер пишет это в лог
+	boot      int32  // Номер загрузки VM (2 — после REBOOT)
+	token     string
+	transport string
+
+	conn   *grpc.ClientConn
+	stream grpc.BidiStreamingClient[pb.StatusRequest, pb.StatusResponse]
+	cancel context.CancelFunc
+
+	legacy bool // Менеджер не знает Session: heartbeat не шлем, отчет — одиночным RPC
+}
+
+func newSession(vmID, idSource, token string, boot int32) *session {
+	return &session{vmID: vmID, idSource: idSource, token: token, boot: boot, transport: agentTransport()}
+}
+
+// send отправляет промежуточное сообщение (START, HEARTBEAT, CHECK_DONE).
+func (s *session) send(phase string, attempts int, results ...*pb.CheckResult) (*pb.StatusResponse, error) {
+	return s.exchange(&pb.StatusRequest{Phase: phase, Results: results}, attempts)
+}
+
+// exchange отправляет сообщение и ждет ответ, повторяя попытки с экспоненциальным backoff.
+func (s *session) exchange(req *pb.StatusRequest, attempts int) (resp *pb.StatusResponse, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req.VmId, req.Token, req.IdentitySource, req.Boot = s.vmID, s.token, s.idSource, s.boot
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			delay := backoff(i)
+			log.Printf("Retrying %s in %s", req.Phase, delay.Round(time.Millisecond))
+			time.Sleep(delay)
+		}
+
+		resp, err = s.once(req)
+		if err == nil {
+			return resp, nil
+		}
+		log.Printf("Failed to send %s (attempt %d/%d): %v", req.Phase, i+1, attempts, err)
+		if permanent(err) {
+			break
+		}
+	}
+	return nil, err
+}
+
+// once — одна попытка: стрим, при неудаче в режиме auto — HTTP.
+func (s *session) once(req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	if s.legacy {
+		if req.Phase != phaseTestsDone {
+			return &pb.StatusResponse{Command: "WAIT"}, nil
+		}
+		return sendReport(req)
+	}
+
+	if s.transport != transportHTTP {
+		resp, err := s.viaStream(req)
+		if status.Code(err) == codes.Unimplemented {
+			log.Printf("Manager does not support sessions, falling back to single report")
+			s.legacy = true
+			return s.once(req)
+		}
+		if err == nil || s.transport == transportGRPC || os.Getenv("MANAGER_HTTP_URL") == "" || permanent(err) {
+			return resp, err
+		}
+		log.Printf("gRPC session failed (%v), falling back to HTTP", err)
+	}
+
+	path := "/api/agent/heartbeat"
+	if req.Phase == phaseTestsDone {
+		path = "/api/agent/report"
+	}
+	resp := &pb.StatusResponse{}
+	return resp, postJSON(path, req, resp)
+}
+
+// viaStream отправляет сообщение в стрим, открывая его заново после обрыва.
+func (s *session) viaStream(req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	if s.stream == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Зависший менеджер не должен держать агента: по таймауту рвем стрим, следующая попытка переподключится
+	timer := time.AfterFunc(exchangeTimeout, s.cancel)
+	defer timer.Stop()
+
+	err := s.stream.Send(req)
+	if err == io.EOF {
+		// Сервер закрыл стрим: настоящая причина придет из Recv
+		_, err = s.stream.Recv()
+	}
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+
+	resp, err := s.stream.Recv()
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *session) connect() error {
+	conn, err := dialManager()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := pb.NewAgentServiceClient(conn).Session(ctx)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return err
+	}
+
+	s.conn, s.stream, s.cancel = conn, stream, cancel
+	return nil
+}
+
+// close закрывает стрим (если открыт). Следующий exchange откроет новый.
+func (s *session) close() {
+	if s.stream == nil {
+		return
+	}
+	_ = s.stream.CloseSend()
+	s.cancel()
+	s.conn.Close()
+	s.conn, s.stream, s.cancel = nil, nil, nil
+}
+
+// keepAlive шлет HEARTBEAT раз в interval, пока не вызвана возвращенная функция остановки.
+// Ошибки не фатальны: следующий тик попробует переподключиться.
+func (s *session) keepAlive(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, _ = s.send(phaseHeartbeat, 1)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// backoff — задержка перед попыткой attempt (1, 2, ...): экспонента с потолком и джиттером до 20%.
+func backoff(attempt int) time.Duration {
+	d := maxBackoff
+	if attempt < 16 {
+		d = min(baseBackoff<<(attempt-1), maxBackoff)
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// permanent — ошибки, которые повтор не исправит: токен отвергнут, VM неизвестна менеджеру
+// или менеджер не ждет от нее диагностику.
+func permanent(err error) bool {
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.NotFound, codes.PermissionDenied:
+		return true
+	}
+	var httpErr *httpStatusError
+	if errors.As(err, &httpErr) {
+		return httpErr.code == http.StatusUnauthorized || httpErr.code == http.StatusNotFound ||
+			httpErr.code == http.StatusForbidden
+	}
+	return false
+}
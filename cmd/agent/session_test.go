@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 16 * time.Second},
+		{6, maxBackoff},
+		{100, maxBackoff},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			d := backoff(tt.attempt)
+			if d < tt.base || d > tt.base+tt.base/5 {
+				t.Fatalf("backoff(%d) = %s, want [%s, %s]", tt.attempt, d, tt.base, tt.base+tt.base/5)
+			}
+		}
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unauthenticated", status.Error(codes.Unauthenticated, "bad token"), true},
+		{"not found", status.Error(codes.NotFound, "unknown vm"), true},
+		{"unavailable", status.Error(codes.Unavailable, "connection refused"), false},
+		{"http 401", &httpStatusError{code: http.StatusUnauthorized}, true},
+		{"wrapped http 404", fmt.Errorf("report: %w", &httpStatusError{code: http.StatusNotFound}), true},
+		{"http 502", &httpStatusError{code: http.StatusBadGateway}, false},
+		{"network", errors.New("dial tcp: i/o timeout"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := permanent(tt.err); got != tt.want {
+				t.Errorf("permanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
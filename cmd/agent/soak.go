@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	pb "image-manager/pkg/pb"
+)
+
+// SOAK — проверки прошли, менеджер держит VM под наблюдением (soak_minutes) перед promote
+const (
+	commandSoak = "SOAK"
+	phaseSoak   = "SOAK"
+)
+
+const (
+	soakInterval     = 30 * time.Second // Как часто слать проверки здоровья
+	soakCheckTimeout = 20 * time.Second
+	soakGrace        = 10 * time.Minute // Сверх окна: решение принимает менеджер, агент лишь не ждет вечно
+
+	soakRestartLimit = 2               // Столько рестартов одного сервиса за окно — это crash loop
+	aptLockLimit     = 5 * time.Minute // unattended-upgrades дольше держит блокировку dpkg — образ «занят»
+)
+
+// Блокировки dpkg: apt берет lock-frontend, dpkg — lock
+var dpkgLocks = []string{"/var/lib/dpkg/lock-frontend", "/var/lib/dpkg/lock"}
+
+// soakMonitor сравнивает состояние VM с началом окна наблюдения.
+type soakMonitor struct {
+	oomKills       int64          // oom_kill из /proc/vmstat на старте (-1 — ядро не считает)
+	restarts       map[string]int // NRestarts сервисов на старте
+	aptLockedSince time.Time      // С какого heartbeat блокировка dpkg держится непрерывно
+}
+
+func newSoakMonitor() *soakMonitor {
+	return &soakMonitor{oomKills: oomKills(), restarts: serviceRestarts()}
+}
+
+// check — проверки одного heartbeat наблюдения.
+func (m *soakMonitor) check() []*pb.CheckResult {
+	ctx, cancel := context.WithTimeout(context.Background(), soakCheckTimeout)
+	defer cancel()
+
+	checks := []struct {
+		name string
+		fn   func() *pb.CheckResult
+	}{
+		{"soak:oom_kills", m.checkOOM},
+		{"soak:service_restarts", m.checkRestarts},
+		{"soak:failed_units", func() *pb.CheckResult { return checkFailedUnits(ctx, nil, nil) }},
+		{"soak:apt_lock", m.checkAptLock},
+	}
+
+	results := make([]*pb.CheckResult, 0, len(checks))
+	for _, c := range checks {
+		start := time.Now()
+		res := c.fn()
+		res.Name = c.name
+		res.DurationMs = time.Since(start).Milliseconds()
+		results = append(results, res)
+	}
+	return results
+}
+
+func (m *soakMonitor) checkOOM() *pb.CheckResult {
+	now := oomKills()
+	if m.oomKills < 0 || now < 0 {
+		return &pb.CheckResult{Status: statusSkip, Message: "oom_kill counter is not available"}
+	}
+	res := &pb.CheckResult{Status: statusPass, Expected: "no OOM kills", Actual: fmt.Sprintf("%d OOM kills", now-m.oomKills)}
+	if now > m.oomKills {
+		res.Status = statusFail
+		res.Message = fmt.Sprintf("OOM killer fired %d times during soak", now-m.oomKills)
+	}
+	return res
+}
+
+func (m *soakMonitor) checkRestarts() *pb.CheckResult {
+	if m.restarts == nil {
+		return &pb.CheckResult{Status: statusSkip, Message: "systemd is not available"}
+	}
+	crashing := crashLoops(m.restarts, serviceRestarts(), soakRestartLimit)
+
+	res := &pb.CheckResult{Status: statusPass, Expected: fmt.Sprintf("fewer than %d restarts per service", soakRestartLimit), Actual: "no crash loops"}
+	if len(crashing) > 0 {
+		res.Status = statusFail
+		res.Actual = strings.Join(crashing, ", ")
+		res.Message = "services in a restart loop: " + res.Actual
+	}
+	return res
+}
+
+func (m *soakMonitor) checkAptLock() *pb.CheckResult {
+	pid, locked := dpkgLockHolder()
+	if !locked {
+		m.aptLockedSince = time.Time{}
+		return &pb.CheckResult{Status: statusPass, Expected: "dpkg lock released", Actual: "not held"}
+	}
+	if m.aptLockedSince.IsZero() {
+		m.aptLockedSince = time.Now()
+	}
+	held := time.Since(m.aptLockedSince).Round(time.Second)
+
+	res := &pb.CheckResult{
+		Status:   statusPass,
+		Expected: fmt.Sprintf("dpkg lock held less than %s", aptLockLimit),
+		Actual:   fmt.Sprintf("held by pid %d for %s", pid, held),
+	}
+	if held >= aptLockLimit {
+		res.Status = statusFail
+		res.Message = "dpkg lock " + res.Actual + " (unattended-upgrades?)"
+	}
+	return res
+}
+
+// soak шлет проверки здоровья раз в soakInterval, пока менеджер отвечает SOAK.
+// Возвращает первый ответ с другой командой (SHUTDOWN или COLLECT_DIAGNOSTICS).
+func (s *session) soak(d time.Duration) (*pb.StatusResponse, error) {
+	log.Printf("Tests passed, soaking for %s before promotion", d)
+	monitor := newSoakMonitor()
+	deadline := time.Now().Add(d + soakGrace)
+
+	ticker := time.NewTicker(soakInterval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("manager did not finish soak within %s", d+soakGrace)
+		}
+
+		results := monitor.check()
+		success, details := summarize(results)
+		if !success {
+			log.Printf("Soak check failed: %s", details)
+		}
+
+		resp, err := s.exchange(&pb.StatusRequest{Phase: phaseSoak, Success: success, Details: details, Results: results}, 3)
+		if err != nil {
+			if permanent(err) {
+				return nil, err
+			}
+			continue // Пропущенный heartbeat заметит watchdog менеджера, если связь не вернется
+		}
+		if resp.Command != commandSoak {
+			return resp, nil
+		}
+	}
+}
+
+// oomKills — счетчик oom_kill из /proc/vmstat (ядро 4.13+), -1 — недоступен.
+func oomKills() int64 {
+	f, err := os.Open("/proc/vmstat")
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "oom_kill "); ok {
+			n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return -1
+			}
+			return n
+		}
+	}
+	return -1
+}
+
+// serviceRestarts — NRestarts всех загруженных сервисов (nil — systemd недоступен).
+func serviceRestarts() map[string]int {
+	ctx, cancel := context.WithTimeout(context.Background(), soakCheckTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "systemctl", "list-units", "--type=service", "--all", "--no-legend", "--plain").Output()
+	if err != nil {
+		return nil
+	}
+	var units []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && strings.HasSuffix(fields[0], ".service") {
+			units = append(units, fields[0])
+		}
+	}
+	if len(units) == 0 {
+		return map[string]int{}
+	}
+
+	out, err = exec.CommandContext(ctx, "systemctl", append([]string{"show", "--property=Id,NRestarts"}, units...)...).Output()
+	if err != nil {
+		return nil
+	}
+	return parseRestarts(string(out))
+}
+
+// parseRestarts разбирает вывод systemctl show: блоки "Id=...\nNRestarts=..." через пустую строку.
+func parseRestarts(out string) map[string]int {
+	restarts := make(map[string]int)
+	var id string
+	for _, line := range strings.Split(out, "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "":
+			id = ""
+		case "Id":
+			id = value
+		case "NRestarts":
+			if n, err := strconv.Atoi(value); err == nil && id != "" {
+				restarts[id] = n
+			}
+		}
+	}
+	return restarts
+}
+
+// crashLoops — сервисы, перезапущенные не меньше limit раз между before и now.
+func crashLoops(before, now map[string]int, limit int) []string {
+	var crashing []string
+	for unit, n := range now {
+		if delta := n - before[unit]; delta >= limit {
+			crashing = append(crashing, fmt.Sprintf("%s (%d restarts)", unit, delta))
+		}
+	}
+	slices.Sort(crashing)
+	return crashing
+}
+
+// dpkgLockHolder проверяет блокировки dpkg (fcntl F_GETLK) и возвращает PID держателя.
+func dpkgLockHolder() (int, bool) {
+	for _, path := range dpkgLocks {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		lk := syscall.Flock_t{Type: syscall.F_WRLCK}
+		err = syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &lk)
+		f.Close()
+		if err == nil && lk.Type != syscall.F_UNLCK {
+			return int(lk.Pid), true
+		}
+	}
+	return 0, false
+}
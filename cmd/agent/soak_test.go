@@ -0,0 +1,33 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseRestarts(t *testing.T) {
+	out := `Id=ssh.service
+NRestarts=0
+
+Id=crashy.service
+NRestarts=7
+
+Id=broken.service
+NRestarts=[not set]
+`
+	got := parseRestarts(out)
+	if len(got) != 2 || got["ssh.service"] != 0 || got["crashy.service"] != 7 {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestCrashLoops(t *testing.T) {
+	before := map[string]int{"ssh.service": 0, "crashy.service": 3, "flaky.service": 1}
+	now := map[string]int{"ssh.service": 0, "crashy.service": 9, "flaky.service": 2, "new.service": 4}
+
+	got := crashLoops(before, now, 2)
+	want := []string{"crashy.service (6 restarts)", "new.service (4 restarts)"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
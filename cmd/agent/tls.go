@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Клиентский сертификат сборки (mTLS). Кладет cloud-init из user data, если mTLS включен.
+const (
+	defaultCertPath = "/etc/image-manager-agent.crt"
+	defaultKeyPath  = "/etc/image-manager-agent.key"
+)
+
+// hasClientCert сообщает, что менеджер выдал VM клиентский сертификат (mTLS включен).
+func hasClientCert() bool {
+	_, err := os.Stat(defaultCertPath)
+	return err == nil
+}
+
+// agentTLSConfig собирает TLS-конфиг агента. nil — CA в образе нет, работаем plaintext.
+func agentTLSConfig() (*tls.Config, error) {
+	caFile := os.Getenv("MANAGER_CA_FILE")
+	if caFile == "" {
+		return nil, nil
+	}
+
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+
+	cfg := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	// Сертификат есть только у VM, созданных менеджером с включенным mTLS
+	if hasClientCert() {
+		cert, err := tls.LoadX509KeyPair(defaultCertPath, defaultKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+		log.Println("Client certificate loaded (mTLS)")
+	}
+
+	return cfg, nil
+}
+
+// grpcCredentials — TLS, если в образ запечен CA менеджера, иначе insecure.
+func grpcCredentials() (credentials.TransportCredentials, error) {
+	cfg, err := agentTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return insecure.NewCredentials(), nil
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+// httpClient — клиент для REST-канала; доверяет тому же CA, что и gRPC.
+func httpClient() (*http.Client, error) {
+	cfg, err := agentTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return http.DefaultClient, nil
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	pb "image-manager/pkg/pb"
+)
+
+// Транспорты (AGENT_TRANSPORT в /etc/image-manager-agent.env).
+const (
+	transportGRPC = "grpc"
+	transportHTTP = "http"
+	transportAuto = "auto" // gRPC, при ошибке — HTTP
+)
+
+// Таймаут одной попытки: в режиме auto у HTTP должно остаться время после неудачного gRPC
+const reportTimeout = 5 * time.Second
+
+// sendReport отправляет отчет менеджеру выбранным транспортом.
+func sendReport(req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	resp := &pb.StatusResponse{}
+	err := call(
+		func(ctx context.Context, c pb.AgentServiceClient) (err error) {
+			resp, err = c.ReportStatus(ctx, req)
+			return err
+		},
+		func() error { return postJSON("/api/agent/report", req, resp) },
+	)
+	return resp, err
+}
+
+// fetchTestPlan запрашивает у менеджера план проверок сборки.
+func fetchTestPlan(req *pb.TestPlanRequest) (*pb.TestPlan, error) {
+	plan := &pb.TestPlan{}
+	err := call(
+		func(ctx context.Context, c pb.AgentServiceClient) (err error) {
+			plan, err = c.GetTestPlan(ctx, req)
+			return err
+		},
+		func() error { return postJSON("/api/agent/plan", req, plan) },
+	)
+	return plan, err
+}
+
+// agentTransport возвращает транспорт из AGENT_TRANSPORT (по умолчанию auto).
+func agentTransport() string {
+	transport := strings.ToLower(os.Getenv("AGENT_TRANSPORT"))
+	if transport == "" {
+		transport = transportAuto
+	}
+	return transport
+}
+
+// call выполняет запрос через gRPC и/или HTTP в зависимости от AGENT_TRANSPORT.
+func call(viaGRPC func(context.Context, pb.AgentServiceClient) error, viaHTTP func() error) error {
+	return callTimeout(reportTimeout, viaGRPC, viaHTTP)
+}
+
+// callTimeout — call с другим таймаутом gRPC-вызова (для долгих загрузок).
+func callTimeout(timeout time.Duration, viaGRPC func(context.Context, pb.AgentServiceClient) error, viaHTTP func() error) error {
+	switch transport := agentTransport(); transport {
+	case transportGRPC:
+		return callGRPC(timeout, viaGRPC)
+	case transportHTTP:
+		return viaHTTP()
+	case transportAuto:
+		err := callGRPC(timeout, viaGRPC)
+		if err == nil {
+			return nil
+		}
+		// С mTLS менеджер принимает только gRPC с сертификатом сборки: REST ответит 401
+		if os.Getenv("MANAGER_HTTP_URL") == "" || hasClientCert() {
+			return err
+		}
+		log.Printf("gRPC call failed (%v), falling back to HTTP", err)
+		return viaHTTP()
+	default:
+		return fmt.Errorf("unknown AGENT_TRANSPORT %q", transport)
+	}
+}
+
+// callGRPC — основной канал: AgentService.
+func callGRPC(timeout time.Duration, fn func(context.Context, pb.AgentServiceClient) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := dialManager()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return fn(ctx, pb.NewAgentServiceClient(conn))
+}
+
+// dialManager открывает gRPC-соединение с менеджером (MANAGER_ADDRESS, TLS по настройкам агента).
+func dialManager() (*grpc.ClientConn, error) {
+	managerAddress := os.Getenv("MANAGER_ADDRESS")
+	if managerAddress == "" {
+		// Fallback для локальной отладки, если забыли прокинуть
+		managerAddress = "127.0.0.1:50051"
+		log.Printf("MANAGER_ADDRESS not set, defaulting to %s", managerAddress)
+	}
+
+	creds, err := grpcCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.Dial(managerAddress, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("did not connect to manager: %w", err)
+	}
+	return conn, nil
+}
+
+// httpStatusError — менеджер ответил по HTTP, но не 200.
+type httpStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("manager returned %d %s: %s", e.code, http.StatusText(e.code), e.msg)
+}
+
+// postJSON — REST-аналог вызова AgentService (protojson), для сред, где ingress не пропускает HTTP/2.
+func postJSON(path string, in, out proto.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+
+	baseURL := strings.TrimRight(os.Getenv("MANAGER_HTTP_URL"), "/")
+	if baseURL == "" {
+		return fmt.Errorf("MANAGER_HTTP_URL not set")
+	}
+
+	body, err := protojson.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client, err := httpClient()
+	if err != nil {
+		return err
+	}
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return &httpStatusError{code: httpResp.StatusCode, msg: strings.TrimSpace(string(data))}
+	}
+
+	if err := protojson.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
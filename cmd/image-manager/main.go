@@ -1,6 +1,8 @@
 package main
 
 import (
+    "context"
+    "crypto/tls"
     "log/slog"
     "net/http"
     "os"
@@ -74,9 +76,74 @@ func main() {
     // Создаем "Сборщика" (Builder).
     builder := service.NewBuilder(log, cfg)
 
+    // Цели публикации (другие регионы/проекты). Файла нет — публикация выключена.
+    publishCfg, err := config.LoadPublishConfig(cfg.Publish.TargetsPath)
+    if err != nil {
+        log.Error("failed to load publish targets", slog.String("error", err.Error()))
+        os.Exit(1)
+    }
+    publisher := service.NewPublisher(log, store, osClient, publishCfg)
+    log.Info("publish targets loaded", slog.Int("count", len(publishCfg.Targets)))
+
+    promoter := service.NewPromoter(log, store, osClient, publisher)
+
+    // Сборщик мусора: удаляет только свои ресурсы завершенных сборок после grace period
+    gc := service.NewGarbageCollector(log, store, osClient, cfg.GC.Owner, cfg.GC.GracePeriod)
+    go gc.Run(context.Background(), cfg.GC.Interval)
+
+    // Эфемерные ключевые пары тестовых VM: приватные ключи в БД шифруются
+    sshKeys, err := service.NewSSHKeyVault(log, store, osClient, gc, cfg.SSHKeys.EncryptionKey)
+    if err != nil {
+        log.Error("failed to init ssh key vault", slog.String("error", err.Error()))
+        os.Exit(1)
+    }
+    if sshKeys.Volatile() {
+        log.Warn("SSH_KEY_ENCRYPTION_KEY is empty: ssh keys of test VMs will not survive a restart")
+    }
+
+    console := service.NewConsoleCollector(log, store, osClient, cfg.Console.CaptureOnSuccess)
+
+    // mTLS агентов (опционально): CA для выпуска клиентских сертификатов сборок
+    var certIssuer *service.CertIssuer
+    if cfg.GRPCServer.ClientCACert != "" && cfg.GRPCServer.ClientCAKey != "" {
+        certIssuer, err = service.NewCertIssuer(cfg.GRPCServer.ClientCACert, cfg.GRPCServer.ClientCAKey, cfg.GRPCServer.ClientCertTTL)
+        if err != nil {
+            log.Error("failed to load agent client CA", slog.String("error", err.Error()))
+            os.Exit(1)
+        }
+    }
+
+    // С mTLS агент отчитывается только по gRPC: в REST-канале клиентского сертификата нет
+    if certIssuer != nil && cfg.Agent.Transport == "http" {
+        log.Error("mTLS requires AGENT_TRANSPORT=grpc or auto: REST agent requests carry no client certificate")
+        os.Exit(1)
+    }
+
+    // Обработка отчетов агента: gRPC и запасной канал через серийную консоль
+    reporter := service.NewReporter(log, store, osClient, promoter, console, gc, certIssuer)
+
+    var grpcTLS *tls.Config
+    if cfg.GRPCServer.TLSCert != "" && cfg.GRPCServer.TLSKey != "" {
+        clientCA := ""
+        if certIssuer != nil {
+            clientCA = cfg.GRPCServer.ClientCACert
+        }
+        grpcTLS, err = grpcServer.LoadTLSConfig(cfg.GRPCServer.TLSCert, cfg.GRPCServer.TLSKey, clientCA)
+        if err != nil {
+            log.Error("failed to load gRPC TLS config", slog.String("error", err.Error()))
+            os.Exit(1)
+        }
+    } else {
+        if certIssuer != nil {
+            log.Error("mTLS requires GRPC_TLS_CERT and GRPC_TLS_KEY")
+            os.Exit(1)
+        }
+        log.Warn("gRPC TLS disabled (GRPC_TLS_CERT/GRPC_TLS_KEY empty): agent channel is plaintext")
+    }
+
     go func() {
-        agentSrv := grpcServer.NewAgentServer(log, store,osClient)
-        if err := agentSrv.Run(cfg.GRPCServer.Port); err != nil {
+        agentSrv := grpcServer.NewAgentServer(log, reporter)
+        if err := agentSrv.Run(cfg.GRPCServer.Port, grpcTLS); err != nil {
             log.Error("gRPC server failed", slog.String("err", err.Error()))
         }
     }()
@@ -88,36 +155,51 @@ func main() {
     // r.Use(middleware.Logger)    // Логирует каждый запрос (метод, путь, время выполнения) - DISABLED TO REDUCE NOISE
     r.Use(middleware.Recoverer) // Спасает сервер от падения (panic), если в хендлере произойдет ошибка в коде.
 
-    // Basic Auth Middleware
-    if cfg.HTTPServer.Username != "" && cfg.HTTPServer.Password != "" {
-        r.Use(func(next http.Handler) http.Handler {
-            return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-                user, pass, ok := r.BasicAuth()
-                if !ok || user != cfg.HTTPServer.Username || pass != cfg.HTTPServer.Password {
-                    w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-                    http.Error(w, "Unauthorized", http.StatusUnauthorized)
-                    return
-                }
-                next.ServeHTTP(w, r)
-            })
-        })
-        log.Info("basic auth enabled")
-    } else {
-        log.Warn("basic auth disabled (credentials empty)")
-    }
-
     // 6. Сборка всего вместе (Dependency Injection)
     // Создаем Handler и передаем ему все инструменты: логгер, билдер, базу, ос-клиент.
-    h := handler.New(log, builder, store, osClient, cfg.OpenStack.FlavorID, cfg.OpenStack.NetworkID)
+    h := handler.New(log, builder, store, osClient, publisher, gc, console, reporter, sshKeys, cfg.OpenStack.FlavorID, cfg.OpenStack.NetworkID)
+
+    // Отчеты агентов (REST-аналог gRPC) — вне basic auth, у агента нет учетки UI
+    h.RegisterAgentRoutes(r)
+
+    // Все остальное (UI и API) — в группе под basic auth
+    r.Group(func(r chi.Router) {
+        // Basic Auth Middleware. Учетка администратора (если задана) тоже пускает в UI,
+        // а запрос помечается как админский (нужно для выдачи SSH-ключей)
+        admin := cfg.HTTPServer.AdminUsername != "" && cfg.HTTPServer.AdminPassword != ""
+        if cfg.HTTPServer.Username != "" && cfg.HTTPServer.Password != "" {
+            r.Use(func(next http.Handler) http.Handler {
+                return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                    user, pass, ok := r.BasicAuth()
+                    if ok && admin && user == cfg.HTTPServer.AdminUsername && pass == cfg.HTTPServer.AdminPassword {
+                        next.ServeHTTP(w, handler.WithAdmin(r))
+                        return
+                    }
+                    if !ok || user != cfg.HTTPServer.Username || pass != cfg.HTTPServer.Password {
+                        w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+                        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+                        return
+                    }
+                    next.ServeHTTP(w, r)
+                })
+            })
+            log.Info("basic auth enabled")
+        } else {
+            log.Warn("basic auth disabled (credentials empty)")
+        }
+        if !admin || cfg.HTTPServer.Username == "" || cfg.HTTPServer.Password == "" {
+            log.Warn("admin auth disabled: ssh keys of test VMs cannot be downloaded")
+        }
 
-    // Регистрируем пути (/build -> h.StartBuild)
-    h.RegisterRoutes(r)
+        // Регистрируем пути (/build -> h.StartBuild)
+        h.RegisterRoutes(r)
 
-     workDir, _ := os.Getwd()
-     filesDir := http.Dir(filepath.Join(workDir, "web"))
-     
-    // Хендлер для статики
-    FileServer(r, "/", filesDir)
+        workDir, _ := os.Getwd()
+        filesDir := http.Dir(filepath.Join(workDir, "web"))
+
+        // Хендлер для статики
+        FileServer(r, "/", filesDir)
+    })
    
     // 7. Запуск Сервера
     log.Info("starting http server", slog.String("address", cfg.HTTPServer.Address))
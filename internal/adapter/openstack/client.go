@@ -1,16 +1,19 @@
 package openstack
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/imagedata"
 	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
@@ -54,6 +57,7 @@ func NewClient(log *slog.Logger, authUrl, username, password, projectID, project
 		log:          log,
 		imagesClient: imgClient,
 		sshKeyName:   sshKeyName, // <-- Значение присваивается здесь!
+		region:       region,
 	}, nil
 }
 
@@ -92,21 +96,45 @@ func (c *Client) ListImages() ([]ImageInfo, error) {
 }
 
 // UploadImage загружает локальный файл в Glance.
-func (c *Client) UploadImage(filePath string, imageName string) (string, error) {
+// properties дописываются к стандартным свойствам образа (может быть nil).
+func (c *Client) UploadImage(filePath string, imageName string, properties map[string]string) (string, error) {
 	const op = "openstack.UploadImage"
 	c.log.Info("starting image upload", slog.String("file", filePath), slog.String("name", imageName))
 
-	visibility := images.ImageVisibilityPrivate
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("%s: open file failed: %w", op, err)
+	}
+	defer f.Close()
+
+	id, err := c.UploadImageData(f, imageName, string(images.ImageVisibilityPrivate), properties)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+// UploadImageData создает образ в Glance и заливает в него данные из потока.
+// Используется и для локальных файлов, и для копирования образа между облаками.
+func (c *Client) UploadImageData(data io.Reader, imageName, visibility string, properties map[string]string) (string, error) {
+	const op = "openstack.UploadImageData"
+
+	vis := images.ImageVisibility(visibility)
+
+	props := map[string]string{
+		"hw_qemu_guest_agent": "yes",
+		"os_distro":           "linux",
+	}
+	for k, v := range properties {
+		props[k] = v
+	}
 
 	createOpts := images.CreateOpts{
 		Name:            imageName,
 		ContainerFormat: "bare",
 		DiskFormat:      "qcow2",
-		Visibility:      &visibility,
-		Properties: map[string]string{
-			"hw_qemu_guest_agent": "yes",
-			"os_distro":           "linux",
-		},
+		Visibility:      &vis,
+		Properties:      props,
 	}
 
 	img, err := images.Create(c.imagesClient, createOpts).Extract()
@@ -123,14 +151,7 @@ func (c *Client) UploadImage(filePath string, imageName string) (string, error)
 		}
 	}
 
-	f, err := os.Open(filePath)
-	if err != nil {
-		cleanup()
-		return "", fmt.Errorf("%s: open file failed: %w", op, err)
-	}
-	defer f.Close()
-
-	res := imagedata.Upload(c.imagesClient, img.ID, f)
+	res := imagedata.Upload(c.imagesClient, img.ID, data)
 	if res.Err != nil {
 		cleanup()
 		return "", fmt.Errorf("%s: upload data failed: %w", op, res.Err)
@@ -146,6 +167,69 @@ func (c *Client) UploadImage(filePath string, imageName string) (string, error)
 	return img.ID, nil
 }
 
+// ErrAmbiguousImage — активных образов с одним именем несколько: какой из них боевой, не понять.
+var ErrAmbiguousImage = errors.New("several active images with the same name")
+
+// GetImageProperties возвращает строковые свойства активного образа с указанным именем.
+// Если такого образа нет, возвращает nil без ошибки; если их несколько — ErrAmbiguousImage.
+func (c *Client) GetImageProperties(name string) (map[string]string, error) {
+	const op = "openstack.GetImageProperties"
+
+	pages, err := images.List(c.imagesClient, images.ListOpts{
+		Name:   name,
+		Status: images.ImageStatusActive,
+	}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("%s: list images: %w", op, err)
+	}
+	allImages, err := images.ExtractImages(pages)
+	if err != nil {
+		return nil, fmt.Errorf("%s: extract images: %w", op, err)
+	}
+	switch len(allImages) {
+	case 0:
+		return nil, nil
+	case 1:
+		return stringProperties(allImages[0].Properties), nil
+	}
+
+	// Сравнение с произвольным из них может дать ложный NO_CHANGE
+	ids := make([]string, 0, len(allImages))
+	for _, img := range allImages {
+		ids = append(ids, img.ID)
+	}
+	return nil, fmt.Errorf("%s: %w: %q (%v)", op, ErrAmbiguousImage, name, ids)
+}
+
+// GetImagePropertiesByID возвращает строковые свойства образа по его ID.
+func (c *Client) GetImagePropertiesByID(imageID string) (map[string]string, error) {
+	img, err := images.Get(c.imagesClient, imageID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("openstack.GetImagePropertiesByID: %w", err)
+	}
+	return stringProperties(img.Properties), nil
+}
+
+// stringProperties оставляет только строковые свойства образа.
+func stringProperties(props map[string]interface{}) map[string]string {
+	result := make(map[string]string)
+	for k, v := range props {
+		if str, ok := v.(string); ok {
+			result[k] = str
+		}
+	}
+	return result
+}
+
+// DownloadImage открывает поток с данными образа. Вызывающий обязан закрыть его.
+func (c *Client) DownloadImage(imageID string) (io.ReadCloser, error) {
+	r, err := imagedata.Download(c.imagesClient, imageID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("openstack.DownloadImage: %w", err)
+	}
+	return r, nil
+}
+
 // waitForImageActive опрашивает Glance, пока образ не станет active.
 func (c *Client) waitForImageActive(imageID string, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
@@ -166,8 +250,28 @@ func (c *Client) waitForImageActive(imageID string, timeout time.Duration) error
 	return fmt.Errorf("timed out after %s", timeout)
 }
 
-// CreateVM создает сервер в OpenStack.
-func (c *Client) CreateVM(name, imageID, flavorID, netID, userData string) (string, error) {
+// VMOptions — параметры тестовой VM.
+type VMOptions struct {
+	FlavorID         string            `json:"flavor_id"`
+	NetworkIDs       []string          `json:"network_ids"`                 // По NIC на каждую сеть, в этом порядке
+	SecurityGroups   []string          `json:"security_groups,omitempty"`   // Пусто — группа default проекта
+	ConfigDrive      bool              `json:"config_drive,omitempty"`      // Метаданные через config drive
+	BootVolumeGB     int               `json:"boot_volume_gb,omitempty"`    // > 0 — загрузка с тома Cinder из образа (удаляется вместе с VM)
+	BootVolumeType   string            `json:"boot_volume_type,omitempty"`  // Тип тома (пусто — тип по умолчанию)
+	AvailabilityZone string            `json:"availability_zone,omitempty"` // Пусто — выбирает планировщик Nova
+	Metadata         map[string]string `json:"metadata,omitempty"`          // Метаданные сервера Nova (метки сборки для GC)
+	Tags             []string          `json:"tags,omitempty"`              // Теги сервера Nova (нужен compute API 2.52+)
+	KeyName          string            `json:"key_name,omitempty"`          // Ключевая пара Nova (пусто — общая OS_SSH_KEY_NAME)
+}
+
+// Микроверсии compute API, с которых при создании сервера можно передать теги и тип тома
+const (
+	serverTagsMicroversion = "2.52"
+	volumeTypeMicroversion = "2.67"
+)
+
+// CreateVM создает сервер в OpenStack из образа imageID с параметрами opts.
+func (c *Client) CreateVM(name, imageID, userData string, opts VMOptions) (string, error) {
 	const op = "openstack.CreateVM"
 
 	computeClient, err := openstack.NewComputeV2(c.imagesClient.ProviderClient, gophercloud.EndpointOpts{
@@ -177,19 +281,57 @@ func (c *Client) CreateVM(name, imageID, flavorID, netID, userData string) (stri
 		return "", fmt.Errorf("%s: compute client error: %w", op, err)
 	}
 
+	networks := make([]servers.Network, 0, len(opts.NetworkIDs))
+	for _, netID := range opts.NetworkIDs {
+		networks = append(networks, servers.Network{UUID: netID})
+	}
+
 	createOpts := servers.CreateOpts{
-		Name:      name,
-		ImageRef:  imageID,
-		FlavorRef: flavorID,
-		UserData:  []byte(userData),
-		Networks: []servers.Network{
-			{UUID: netID},
-		},
+		Name:             name,
+		ImageRef:         imageID,
+		FlavorRef:        opts.FlavorID,
+		UserData:         []byte(userData),
+		Metadata:         opts.Metadata,
+		Networks:         networks,
+		SecurityGroups:   opts.SecurityGroups,
+		AvailabilityZone: opts.AvailabilityZone,
+		Tags:             opts.Tags,
+	}
+	switch {
+	case opts.BootVolumeGB > 0 && opts.BootVolumeType != "":
+		computeClient.Microversion = volumeTypeMicroversion
+	case len(opts.Tags) > 0:
+		computeClient.Microversion = serverTagsMicroversion
+	}
+	if opts.ConfigDrive {
+		createOpts.ConfigDrive = &opts.ConfigDrive
+	}
+
+	var builder servers.CreateOptsBuilder = createOpts
+	if opts.BootVolumeGB > 0 {
+		// Корневой диск — том из образа: на флейворах с диском 0 ГБ иначе не загрузиться
+		createOpts.ImageRef = ""
+		builder = bootfromvolume.CreateOptsExt{
+			CreateOptsBuilder: createOpts,
+			BlockDevice: []bootfromvolume.BlockDevice{{
+				UUID:                imageID,
+				SourceType:          bootfromvolume.SourceImage,
+				DestinationType:     bootfromvolume.DestinationVolume,
+				VolumeSize:          opts.BootVolumeGB,
+				VolumeType:          opts.BootVolumeType,
+				BootIndex:           0,
+				DeleteOnTermination: true,
+			}},
+		}
 	}
 
+	keyName := opts.KeyName
+	if keyName == "" {
+		keyName = c.sshKeyName
+	}
 	createOptsWithKey := keypairs.CreateOptsExt{
-		CreateOptsBuilder: createOpts,
-		KeyName:           c.sshKeyName, // Берем из поля структуры
+		CreateOptsBuilder: builder,
+		KeyName:           keyName,
 	}
 
 	server, err := servers.Create(computeClient, createOptsWithKey).Extract()
@@ -197,7 +339,7 @@ func (c *Client) CreateVM(name, imageID, flavorID, netID, userData string) (stri
 		return "", fmt.Errorf("%s: create failed: %w", op, err)
 	}
 
-	c.log.Info("vm created", slog.String("id", server.ID), slog.String("key", c.sshKeyName))
+	c.log.Info("vm created", slog.String("id", server.ID), slog.String("key", keyName), slog.String("flavor", opts.FlavorID))
 	return server.ID, nil
 }
 
@@ -217,6 +359,84 @@ func (c *Client) WaitForVMActive(serverID string, timeout time.Duration) error {
 	return servers.WaitForStatus(computeClient, serverID, "ACTIVE", int(timeout.Seconds()))
 }
 
+// GetConsoleOutput возвращает вывод серийной консоли сервера.
+// lines — сколько последних строк взять (0 = весь лог).
+func (c *Client) GetConsoleOutput(serverID string, lines int) (string, error) {
+	const op = "openstack.GetConsoleOutput"
+
+	computeClient, err := c.computeClient()
+	if err != nil {
+		return "", fmt.Errorf("%s: compute client error: %w", op, err)
+	}
+
+	out, err := servers.ShowConsoleOutput(computeClient, serverID, servers.ShowConsoleOutputOpts{Length: lines}).Extract()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	return out, nil
+}
+
+// Flavor — ресурсы флейвора сервера.
+type Flavor struct {
+	ID     string
+	Name   string
+	VCPUs  int
+	RAMMB  int
+	DiskGB int // 0 — флейвор без диска (загрузка с тома)
+}
+
+// GetServerFlavor возвращает флейвор, с которым запущен сервер.
+func (c *Client) GetServerFlavor(serverID string) (*Flavor, error) {
+	const op = "openstack.GetServerFlavor"
+
+	computeClient, err := c.computeClient()
+	if err != nil {
+		return nil, fmt.Errorf("%s: compute client error: %w", op, err)
+	}
+
+	server, err := servers.Get(computeClient, serverID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	flavorID, _ := server.Flavor["id"].(string)
+	if flavorID == "" {
+		return nil, fmt.Errorf("%s: server %s has no flavor id", op, serverID)
+	}
+
+	flavor, err := flavors.Get(computeClient, flavorID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &Flavor{ID: flavor.ID, Name: flavor.Name, VCPUs: flavor.VCPUs, RAMMB: flavor.RAM, DiskGB: flavor.Disk}, nil
+}
+
+// GetFlavor возвращает ресурсы флейвора по ID.
+func (c *Client) GetFlavor(flavorID string) (*Flavor, error) {
+	const op = "openstack.GetFlavor"
+
+	computeClient, err := c.computeClient()
+	if err != nil {
+		return nil, fmt.Errorf("%s: compute client error: %w", op, err)
+	}
+
+	flavor, err := flavors.Get(computeClient, flavorID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &Flavor{ID: flavor.ID, Name: flavor.Name, VCPUs: flavor.VCPUs, RAMMB: flavor.RAM, DiskGB: flavor.Disk}, nil
+}
+
+// GetServerDiskGB возвращает размер корневого диска сервера по его флейвору (ГБ).
+// 0 — флейвор без диска (загрузка с тома).
+func (c *Client) GetServerDiskGB(serverID string) (int, error) {
+	flavor, err := c.GetServerFlavor(serverID)
+	if err != nil {
+		return 0, err
+	}
+	return flavor.DiskGB, nil
+}
+
 func (c *Client) DeleteVM(serverID string) error {
         const op = "openstack.DeleteVM"
 
@@ -255,37 +475,25 @@ func (c *Client) DeleteImageByName(name string) error {
 	return nil
 }
 
-// CleanupQueuedCandidates удаляет все образы со словом "candidate" в имени и статусом "queued".
-func (c *Client) CleanupQueuedCandidates() error {
-	pages, err := images.List(c.imagesClient, images.ListOpts{
-		Status: images.ImageStatusQueued,
-	}).AllPages()
+// findImageIDsByName возвращает ID всех образов с таким именем.
+func (c *Client) findImageIDsByName(name string) ([]string, error) {
+	pages, err := images.List(c.imagesClient, images.ListOpts{Name: name}).AllPages()
 	if err != nil {
-		return fmt.Errorf("list queued images: %w", err)
+		return nil, err
 	}
-
 	allImages, err := images.ExtractImages(pages)
 	if err != nil {
-		return fmt.Errorf("extract queued images: %w", err)
+		return nil, err
 	}
 
+	ids := make([]string, 0, len(allImages))
 	for _, img := range allImages {
-		if strings.Contains(img.Name, "candidate") {
-			c.log.Warn("deleting queued candidate image",
-				slog.String("id", img.ID),
-				slog.String("name", img.Name),
-			)
-			if err := images.Delete(c.imagesClient, img.ID).ExtractErr(); err != nil {
-				c.log.Error("failed to delete queued candidate",
-					slog.String("id", img.ID),
-					slog.String("err", err.Error()),
-				)
-			}
-		}
+		ids = append(ids, img.ID)
 	}
-	return nil
+	return ids, nil
 }
 
+
 // imageUpdateOpts - костыль для обхода проблем с типами Gophercloud
 type imageUpdateOpts []map[string]interface{}
 
@@ -303,6 +511,14 @@ func (opts imageUpdateOpts) ToImageUpdateMap() ([]interface{}, error) {
 func (c *Client) PromoteImage(candidateID, targetName string) error {
 	const op = "openstack.PromoteImage"
 
+	// 0. Переносим участников (member projects) со старого образа на новый,
+	// чтобы потребители не потеряли доступ после замены.
+	if oldIDs, err := c.findImageIDsByName(targetName); err == nil {
+		c.copyMembers(oldIDs, candidateID)
+	} else {
+		c.log.Warn("failed to find old images for member transfer", slog.String("err", err.Error()))
+	}
+
 	// 1. Удаляем старый (боевой)
 	// Игнорируем ошибку, если образа нет
 	_ = c.DeleteImageByName(targetName)
@@ -0,0 +1,38 @@
+package openstack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
+)
+
+// CreateKeypair регистрирует в Nova ключевую пару с готовым публичным ключом (OpenSSH-формат).
+// Приватный ключ в облако не попадает.
+func (c *Client) CreateKeypair(name, publicKey string) error {
+	const op = "openstack.CreateKeypair"
+
+	computeClient, err := c.computeClient()
+	if err != nil {
+		return fmt.Errorf("%s: compute client error: %w", op, err)
+	}
+
+	if _, err := keypairs.Create(computeClient, keypairs.CreateOpts{Name: name, PublicKey: publicKey}).Extract(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// DeleteKeypair удаляет ключевую пару из Nova. Уже созданные с ней серверы не затрагиваются.
+func (c *Client) DeleteKeypair(name string) error {
+	const op = "openstack.DeleteKeypair"
+
+	computeClient, err := c.computeClient()
+	if err != nil {
+		return fmt.Errorf("%s: compute client error: %w", op, err)
+	}
+
+	if err := keypairs.Delete(computeClient, name, nil).ExtractErr(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package openstack
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/members"
+)
+
+// MemberInfo — проект, с которым расшарен образ, и статус принятия (pending/accepted/rejected).
+type MemberInfo struct {
+	ProjectID string `json:"project_id"`
+	Status    string `json:"status"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// ListMembers возвращает проекты, с которыми расшарен образ.
+func (c *Client) ListMembers(imageID string) ([]MemberInfo, error) {
+	const op = "openstack.ListMembers"
+
+	pages, err := members.List(c.imagesClient, imageID).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	all, err := members.ExtractMembers(pages)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	result := make([]MemberInfo, 0, len(all))
+	for _, m := range all {
+		result = append(result, MemberInfo{
+			ProjectID: m.MemberID,
+			Status:    m.Status,
+			UpdatedAt: m.UpdatedAt.Format("2006-01-02 15:04"),
+		})
+	}
+	return result, nil
+}
+
+// AddMember расшаривает образ проекту. Образ переводится в visibility=shared,
+// иначе Glance не примет участника. Повторное добавление не считается ошибкой.
+func (c *Client) AddMember(imageID, projectID string) error {
+	const op = "openstack.AddMember"
+
+	if err := c.ensureShared(imageID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err := members.Create(c.imagesClient, imageID, projectID).Extract()
+	if err != nil {
+		var conflict gophercloud.ErrDefault409
+		if errors.As(err, &conflict) {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	c.log.Info("image shared", slog.String("id", imageID), slog.String("project", projectID))
+	return nil
+}
+
+// RemoveMember отзывает доступ проекта к образу.
+func (c *Client) RemoveMember(imageID, projectID string) error {
+	if err := members.Delete(c.imagesClient, imageID, projectID).ExtractErr(); err != nil {
+		return fmt.Errorf("openstack.RemoveMember: %w", err)
+	}
+	return nil
+}
+
+// ensureShared переводит private-образ в shared. public/community не трогаем.
+func (c *Client) ensureShared(imageID string) error {
+	img, err := images.Get(c.imagesClient, imageID).Extract()
+	if err != nil {
+		return fmt.Errorf("get image: %w", err)
+	}
+	if img.Visibility != images.ImageVisibilityPrivate {
+		return nil
+	}
+
+	updateOpts := imageUpdateOpts{
+		{
+			"op":    "replace",
+			"path":  "/visibility",
+			"value": string(images.ImageVisibilityShared),
+		},
+	}
+	if _, err := images.Update(c.imagesClient, imageID, updateOpts).Extract(); err != nil {
+		return fmt.Errorf("set visibility shared: %w", err)
+	}
+	return nil
+}
+
+// copyMembers переносит участников со старых образов на новый (используется при promote).
+func (c *Client) copyMembers(oldIDs []string, newID string) {
+	for _, oldID := range oldIDs {
+		list, err := c.ListMembers(oldID)
+		if err != nil {
+			c.log.Warn("failed to list members of old image", slog.String("id", oldID), slog.String("err", err.Error()))
+			continue
+		}
+		for _, m := range list {
+			if err := c.AddMember(newID, m.ProjectID); err != nil {
+				c.log.Error("failed to move image member",
+					slog.String("from", oldID),
+					slog.String("to", newID),
+					slog.String("project", m.ProjectID),
+					slog.String("err", err.Error()),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package openstack
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+)
+
+// TaggedResource — образ или сервер, помеченный меткой менеджера.
+type TaggedResource struct {
+	ID     string
+	Name   string
+	Status string
+	Tags   map[string]string // Свойства образа / метаданные сервера
+}
+
+// IsNotFound сообщает, что ресурс уже удален (HTTP 404).
+func IsNotFound(err error) bool {
+	var notFound gophercloud.ErrDefault404
+	return errors.As(err, &notFound)
+}
+
+// computeClient создает клиент Nova в регионе клиента.
+func (c *Client) computeClient() (*gophercloud.ServiceClient, error) {
+	return openstack.NewComputeV2(c.imagesClient.ProviderClient, gophercloud.EndpointOpts{
+		Region: c.region,
+	})
+}
+
+// ListTaggedImages возвращает образы проекта, у которых есть свойство key.
+func (c *Client) ListTaggedImages(key string) ([]TaggedResource, error) {
+	const op = "openstack.ListTaggedImages"
+
+	pages, err := images.List(c.imagesClient, images.ListOpts{}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	allImages, err := images.ExtractImages(pages)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var result []TaggedResource
+	for _, img := range allImages {
+		props := stringProperties(img.Properties)
+		if _, ok := props[key]; !ok {
+			continue
+		}
+		result = append(result, TaggedResource{ID: img.ID, Name: img.Name, Status: string(img.Status), Tags: props})
+	}
+	return result, nil
+}
+
+// ListTaggedServers возвращает серверы проекта, у которых есть метаданные key.
+func (c *Client) ListTaggedServers(key string) ([]TaggedResource, error) {
+	const op = "openstack.ListTaggedServers"
+
+	computeClient, err := c.computeClient()
+	if err != nil {
+		return nil, fmt.Errorf("%s: compute client error: %w", op, err)
+	}
+
+	pages, err := servers.List(computeClient, servers.ListOpts{}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	all, err := servers.ExtractServers(pages)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var result []TaggedResource
+	for _, srv := range all {
+		if _, ok := srv.Metadata[key]; !ok {
+			continue
+		}
+		result = append(result, TaggedResource{ID: srv.ID, Name: srv.Name, Status: srv.Status, Tags: srv.Metadata})
+	}
+	return result, nil
+}
+
+// DeleteImage удаляет образ по ID.
+func (c *Client) DeleteImage(imageID string) error {
+	if err := images.Delete(c.imagesClient, imageID).ExtractErr(); err != nil {
+		return fmt.Errorf("openstack.DeleteImage: %w", err)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package config
 import (
     "log"
     "os"
+    "time"
 
     "github.com/ilyakaznacheev/cleanenv"
 )
@@ -15,12 +16,32 @@ type Config struct {
         Address  string `yaml:"address" env:"HTTP_ADDRESS" env-default:"0.0.0.0:8080"`
         Username string `yaml:"username" env:"HTTP_USERNAME"`
         Password string `yaml:"password" env:"HTTP_PASSWORD"`
+        // Администратор: все то же, что у пользователя, плюс приватные SSH-ключи тестовых VM
+        AdminUsername string `yaml:"admin_username" env:"HTTP_ADMIN_USERNAME"`
+        AdminPassword string `yaml:"admin_password" env:"HTTP_ADMIN_PASSWORD"`
+        PublicURL string `yaml:"public_url" env:"HTTP_PUBLIC_URL"` // http(s)://host:port, видимый для агентов (REST-отчеты)
     }
     GRPCServer struct {
 	Port          string `yaml:"port" env:"GRPC_PORT" env-default:":50051"`
 	PublicAddress string `yaml:"public_address" env:"GRPC_PUBLIC_ADDRESS"` // IP:PORT, видимый для агентов
+
+	// TLS. Пустые cert/key — plaintext (как раньше).
+	TLSCert     string `yaml:"tls_cert" env:"GRPC_TLS_CERT"`
+	TLSKey      string `yaml:"tls_key" env:"GRPC_TLS_KEY"`
+	TLSCABundle string `yaml:"tls_ca_bundle" env:"GRPC_TLS_CA_BUNDLE"` // CA серверного сертификата, запекается в образ
+
+	// mTLS (опционально): CA, которым менеджер подписывает клиентские сертификаты сборок.
+	ClientCACert  string        `yaml:"client_ca_cert" env:"GRPC_CLIENT_CA_CERT"`
+	ClientCAKey   string        `yaml:"client_ca_key" env:"GRPC_CLIENT_CA_KEY"`
+	ClientCertTTL time.Duration `yaml:"client_cert_ttl" env:"GRPC_CLIENT_CERT_TTL" env-default:"2h"`
    }
 
+    // Агент внутри тестовой VM
+    Agent struct {
+        // Транспорт отчета агента: grpc, http или auto (gRPC, при ошибке — HTTP).
+        Transport string `yaml:"transport" env:"AGENT_TRANSPORT" env-default:"auto"`
+    }
+
 
      OpenStack struct {
    AuthURL    string `yaml:"auth_url" env:"OS_AUTH_URL"`
@@ -39,6 +60,30 @@ type Config struct {
     SSHInjectKey string `yaml:"ssh_inject_key" env:"SSH_INJECT_KEY"`
     }
 
+    // Эфемерные ключевые пары тестовых VM (по паре на сборку, см. GET /api/build/{id}/ssh-key)
+    SSHKeys struct {
+        // Ключ AES-256 (32 байта в base64) для приватных ключей в БД. Пустой — случайный на время
+        // жизни процесса: после рестарта скачать ключи уже созданных VM не получится.
+        EncryptionKey string `yaml:"encryption_key" env:"SSH_KEY_ENCRYPTION_KEY"`
+    }
+
+    // Захват консоли тестовой VM в артефакты сборки (на ошибках — всегда)
+    Console struct {
+        CaptureOnSuccess bool `yaml:"capture_on_success" env:"CONSOLE_CAPTURE_ON_SUCCESS" env-default:"false"`
+    }
+
+    // Сборщик мусора: брошенные кандидаты и тестовые VM
+    GC struct {
+        Interval    time.Duration `yaml:"interval" env:"GC_INTERVAL" env-default:"30m"`
+        GracePeriod time.Duration `yaml:"grace_period" env:"GC_GRACE_PERIOD" env-default:"24h"`
+        Owner       string        `yaml:"owner" env:"GC_OWNER" env-default:"image-manager"` // Метка владельца на ресурсах (уникальна для инсталляции)
+    }
+
+    // Публикация образа в другие регионы/проекты (см. configs/publish-targets.yaml.example)
+    Publish struct {
+        TargetsPath string `yaml:"targets_path" env:"PUBLISH_TARGETS_PATH" env-default:"configs/publish-targets.yaml"`
+    }
+
 }
 
 func MustLoad() *Config {
@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -15,14 +19,293 @@ type DistroConfig struct {
 	OSElement string            `yaml:"os_element"`
 	Env       map[string]string `yaml:"env"`
 	Elements  []string          `yaml:"elements"`
+	Sharing   SharingPolicy     `yaml:"sharing"`
+	Tests     []TestCheck       `yaml:"tests"` // Проверки агента в тестовой VM (пусто = проверки по умолчанию)
+
+	// Встроенные проверки агента, включаемые по имени (см. BuiltinChecks)
+	BuiltinChecks []string `yaml:"builtin_checks"`
+
+	// Ожидания к фактам о VM сверх флейвора (vCPU, память и диск сверяются всегда)
+	Facts FactExpectations `yaml:"facts"`
+
+	// Drop-in проверки агента: исполняемые файлы из AgentChecksDir, элемент agent-checks
+	// кладет их в /etc/image-agent/checks.d образа
+	AgentChecks []string `yaml:"agent_checks"`
+
+	// Проверка второй загрузки: после успешного отчета агент перезагружает VM и проверяет ее еще раз
+	// (fstab, machine-id, имена сетевых интерфейсов, повторный запуск cloud-init). Promote — только
+	// если прошли обе загрузки.
+	RebootTest bool `yaml:"reboot_test"`
+
+	// Наблюдение перед promote: VM работает столько минут, агент шлет проверки здоровья
+	// (OOM, рестарты сервисов, упавшие юниты, блокировка apt). 0 — без наблюдения.
+	SoakMinutes int `yaml:"soak_minutes"`
+
+	// Матрица тестовых VM: кандидат загружается на нескольких флейворах и сетях параллельно,
+	// promote — только если прошли все обязательные ячейки. Пусто — одна VM с флейвором
+	// и сетью из конфига менеджера.
+	TestMatrix TestMatrix `yaml:"test_matrix"`
+
+	// Параметры тестовых VM дистрибутива (том, группы безопасности, config drive, AZ, метаданные)
+	TestVM TestVMOptions `yaml:"test_vm"`
+}
+
+// DefaultBootVolumeGB — размер тома, если флейвор без диска, а boot_volume_gb не задан.
+const DefaultBootVolumeGB = 10
+
+// Префикс меток сервера, которые ставит сам менеджер (владелец, сборка, ячейка).
+const reservedMetadataPrefix = "image_manager_"
+
+// TestVMOptions — параметры тестовой VM. Уровни по возрастанию приоритета: секция test_vm
+// конфига дистрибутива, поле test_vm запроса сборки, ячейка матрицы. Пустые поля берутся
+// с предыдущего уровня, в конце — из конфига менеджера.
+type TestVMOptions struct {
+	Flavor           string            `yaml:"flavor" json:"flavor,omitempty"`                       // ID флейвора
+	Networks         []string          `yaml:"networks" json:"networks,omitempty"`                   // ID сетей, по NIC на каждую (IPv6-only, несколько NIC)
+	SecurityGroups   []string          `yaml:"security_groups" json:"security_groups,omitempty"`     // Имена или ID (пусто — default проекта)
+	ConfigDrive      *bool             `yaml:"config_drive" json:"config_drive,omitempty"`           // Метаданные через config drive вместо сервиса метаданных
+	BootVolumeGB     int               `yaml:"boot_volume_gb" json:"boot_volume_gb,omitempty"`       // > 0 — загрузка с тома Cinder (флейворы с диском 0 ГБ)
+	BootVolumeType   string            `yaml:"boot_volume_type" json:"boot_volume_type,omitempty"`   // Тип тома Cinder (пусто — тип по умолчанию)
+	AvailabilityZone string            `yaml:"availability_zone" json:"availability_zone,omitempty"` // Зона доступности Nova
+	Metadata         map[string]string `yaml:"metadata" json:"metadata,omitempty"`                   // Доп. метаданные сервера
+}
+
+// Merge накладывает непустые поля over на o. Метаданные объединяются по ключам.
+func (o TestVMOptions) Merge(over TestVMOptions) TestVMOptions {
+	if over.Flavor != "" {
+		o.Flavor = over.Flavor
+	}
+	if len(over.Networks) > 0 {
+		o.Networks = over.Networks
+	}
+	if len(over.SecurityGroups) > 0 {
+		o.SecurityGroups = over.SecurityGroups
+	}
+	if over.ConfigDrive != nil {
+		o.ConfigDrive = over.ConfigDrive
+	}
+	if over.BootVolumeGB > 0 {
+		o.BootVolumeGB = over.BootVolumeGB
+	}
+	if over.BootVolumeType != "" {
+		o.BootVolumeType = over.BootVolumeType
+	}
+	if over.AvailabilityZone != "" {
+		o.AvailabilityZone = over.AvailabilityZone
+	}
+	if len(over.Metadata) > 0 {
+		merged := make(map[string]string, len(o.Metadata)+len(over.Metadata))
+		for k, v := range o.Metadata {
+			merged[k] = v
+		}
+		for k, v := range over.Metadata {
+			merged[k] = v
+		}
+		o.Metadata = merged
+	}
+	return o
+}
+
+// Validate проверяет параметры (и из конфига, и из запроса сборки).
+func (o TestVMOptions) Validate() error {
+	if o.BootVolumeGB < 0 {
+		return fmt.Errorf("boot_volume_gb must not be negative")
+	}
+	for k, v := range o.Metadata {
+		// Метки менеджера: по ним GC находит ресурсы сборки, подменять их нельзя
+		if strings.HasPrefix(k, reservedMetadataPrefix) {
+			return fmt.Errorf("metadata key %q: prefix %s is reserved", k, reservedMetadataPrefix)
+		}
+		// Ограничения Nova на метаданные сервера
+		if k == "" || len(k) > 255 || len(v) > 255 {
+			return fmt.Errorf("metadata key %q: key and value must be 1-255 characters", k)
+		}
+	}
+	return nil
+}
+
+// DefaultMaxParallel — сколько VM матрицы живут одновременно, если max_parallel не задан.
+const DefaultMaxParallel = 2
+
+// TestMatrix — набор конфигураций тестовых VM.
+type TestMatrix struct {
+	MaxParallel int          `yaml:"max_parallel"` // Одновременно запущенных VM (квота проекта)
+	Cells       []MatrixCell `yaml:"cells"`
+}
+
+// MatrixCell — одна тестовая VM матрицы. Параметры VM — поверх test_vm дистрибутива и запроса.
+type MatrixCell struct {
+	Name          string `yaml:"name"`     // Уникальное имя: префикс результатов и артефактов
+	Optional      bool   `yaml:"optional"` // Провал ячейки не мешает promote
+	TestVMOptions `yaml:",inline"`
+}
+
+// CellsOrDefault возвращает ячейки матрицы. Без матрицы — одна безымянная ячейка с настройками менеджера.
+func (m TestMatrix) CellsOrDefault() []MatrixCell {
+	if len(m.Cells) == 0 {
+		return []MatrixCell{{}}
+	}
+	return m.Cells
+}
+
+// Parallel возвращает предел одновременно запущенных VM.
+func (m TestMatrix) Parallel() int {
+	if m.MaxParallel > 0 {
+		return m.MaxParallel
+	}
+	return DefaultMaxParallel
+}
+
+var cellNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func (m TestMatrix) validate() error {
+	if m.MaxParallel < 0 {
+		return fmt.Errorf("max_parallel must not be negative")
+	}
+	if len(m.Cells) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	required := 0
+	for _, cell := range m.Cells {
+		// Имя попадает в имя VM, префиксы результатов и имена артефактов
+		if !cellNameRe.MatchString(cell.Name) {
+			return fmt.Errorf("invalid cell name %q (lowercase letters, digits, '-' and '_')", cell.Name)
+		}
+		if seen[cell.Name] {
+			return fmt.Errorf("duplicate cell %q", cell.Name)
+		}
+		seen[cell.Name] = true
+		if err := cell.Validate(); err != nil {
+			return fmt.Errorf("cell %q: %w", cell.Name, err)
+		}
+		if !cell.Optional {
+			required++
+		}
+	}
+	if required == 0 {
+		return fmt.Errorf("at least one cell must be required")
+	}
+	return nil
+}
+
+// AgentChecksDir — каталог с drop-in проверками агента (пути в agent_checks — относительно него).
+const AgentChecksDir = "configs/checks"
+
+// FactExpectations — что гостевая ОС должна увидеть на тестовой VM. Пустые поля не проверяются.
+type FactExpectations struct {
+	Firmware      string        `yaml:"firmware"`        // bios | uefi
+	VirtioDrivers []string      `yaml:"virtio_drivers"`  // Драйверы, которые должны быть на шине virtio
+	MinMTU        int           `yaml:"min_mtu"`         // Минимальный MTU каждого сетевого интерфейса
+	MaxBootTime   time.Duration `yaml:"max_boot_time"`   // Предел systemd-analyze (ядро + userspace)
+	MaxAgentStart time.Duration `yaml:"max_agent_start"` // Предел времени от загрузки до старта агента
+}
+
+// BuiltinChecks — встроенные проверки здоровья загрузки, реализованные в агенте.
+var BuiltinChecks = []string{
+	"root_fs_size",     // Корневая ФС расширена до диска флейвора (growpart)
+	"cloud_init",       // cloud-init status --long: done без ошибок
+	"failed_units",     // Нет упавших systemd-юнитов
+	"time_sync",        // Время синхронизировано (NTP)
+	"dns",              // Резолвится target (по умолчанию dns.google)
+	"metadata",         // Доступен сервис метаданных OpenStack
+	"qemu_guest_agent", // qemu-guest-agent запущен
+	"ssh_host_keys",    // Ключи хоста SSH сгенерированы при этой загрузке, а не запечены в образ
+	"agent_gated",      // Агент стартует только с токеном сборки (в боевых VM неактивен)
+}
+
+// Типы проверок агента.
+const (
+	CheckCommand = "command"
+	CheckFile    = "file"
+	CheckService = "service"
+	CheckPort    = "port"
+	CheckBuiltin = "builtin"
+)
+
+// TestCheck — декларативная проверка, которую агент выполняет в тестовой VM.
+type TestCheck struct {
+	Name            string        `yaml:"name"`
+	Type            string        `yaml:"type"`
+	Command         string        `yaml:"command"`
+	ExpectExitCodes []int         `yaml:"expect_exit_codes"`
+	Path            string        `yaml:"path"`
+	Contains        string        `yaml:"contains"`
+	Matches         string        `yaml:"matches"`
+	Service         string        `yaml:"service"`
+	Port            int           `yaml:"port"`
+	Protocol        string        `yaml:"protocol"`
+	Timeout         time.Duration `yaml:"timeout"`
+	Builtin         string        `yaml:"builtin"`
+	Target          string        `yaml:"target"`
+}
+
+func (c TestCheck) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("check without name")
+	}
+
+	switch c.Type {
+	case CheckCommand:
+		if c.Command == "" {
+			return fmt.Errorf("check %q: command is required", c.Name)
+		}
+	case CheckFile:
+		if c.Path == "" {
+			return fmt.Errorf("check %q: path is required", c.Name)
+		}
+	case CheckService:
+		if c.Service == "" {
+			return fmt.Errorf("check %q: service is required", c.Name)
+		}
+	case CheckPort:
+		if c.Port <= 0 || c.Port > 65535 {
+			return fmt.Errorf("check %q: invalid port %d", c.Name, c.Port)
+		}
+		if c.Protocol != "" && c.Protocol != "tcp" && c.Protocol != "udp" {
+			return fmt.Errorf("check %q: protocol must be tcp or udp", c.Name)
+		}
+	case CheckBuiltin:
+		if !slices.Contains(BuiltinChecks, c.Builtin) {
+			return fmt.Errorf("check %q: unknown builtin %q", c.Name, c.Builtin)
+		}
+	default:
+		return fmt.Errorf("check %q: unknown type %q", c.Name, c.Type)
+	}
+
+	if c.Matches != "" {
+		if _, err := regexp.Compile(c.Matches); err != nil {
+			return fmt.Errorf("check %q: invalid matches: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// SharingPolicy — проекты-потребители, с которыми боевой образ расшаривается через Glance members.
+type SharingPolicy struct {
+	Consumers []string `yaml:"consumers"`
+}
+
+// DistroConfigPath возвращает путь к конфигу дистрибутива: configs/distros/{name}.yaml
+// Короткие алиасы ("debian", "ubuntu") раскрываются в конкретную версию.
+func DistroConfigPath(distroName string) string {
+	switch distroName {
+	case "debian":
+		distroName = "debian-12"
+	case "ubuntu":
+		distroName = "ubuntu-24"
+	}
+
+	// Безопасность: очищаем имя от путей
+	safeName := filepath.Base(distroName)
+	return filepath.Join("configs", "distros", safeName+".yaml")
 }
 
 // LoadDistroConfig ищет и загружает конфиг для указанного дистрибутива.
 // Ищет файл configs/distros/{name}.yaml
 func LoadDistroConfig(distroName string) (*DistroConfig, error) {
-	// Безопасность: очищаем имя от путей
-	safeName := filepath.Base(distroName)
-	path := filepath.Join("configs", "distros", safeName+".yaml")
+	path := DistroConfigPath(distroName)
 
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -34,5 +317,40 @@ func LoadDistroConfig(distroName string) (*DistroConfig, error) {
 		return nil, fmt.Errorf("failed to parse distro config: %w", err)
 	}
 
+	for _, check := range cfg.Tests {
+		if err := check.validate(); err != nil {
+			return nil, fmt.Errorf("invalid tests in %s: %w", path, err)
+		}
+	}
+	for _, name := range cfg.BuiltinChecks {
+		if !slices.Contains(BuiltinChecks, name) {
+			return nil, fmt.Errorf("invalid builtin_checks in %s: unknown check %q", path, name)
+		}
+	}
+	names := make(map[string]string)
+	for _, check := range cfg.AgentChecks {
+		if _, err := os.Stat(filepath.Join(AgentChecksDir, check)); err != nil {
+			return nil, fmt.Errorf("invalid agent_checks in %s: %w", path, err)
+		}
+		// В checks.d файлы ложатся плоско — одинаковые имена из разных каталогов затрут друг друга
+		base := filepath.Base(check)
+		if prev, ok := names[base]; ok {
+			return nil, fmt.Errorf("invalid agent_checks in %s: %s and %s have the same file name", path, prev, check)
+		}
+		names[base] = check
+	}
+	if cfg.SoakMinutes < 0 {
+		return nil, fmt.Errorf("invalid soak_minutes in %s: must not be negative", path)
+	}
+	if err := cfg.TestVM.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid test_vm in %s: %w", path, err)
+	}
+	if err := cfg.TestMatrix.validate(); err != nil {
+		return nil, fmt.Errorf("invalid test_matrix in %s: %w", path, err)
+	}
+	if fw := cfg.Facts.Firmware; fw != "" && fw != "bios" && fw != "uefi" {
+		return nil, fmt.Errorf("invalid facts in %s: firmware must be bios or uefi, got %q", path, fw)
+	}
+
 	return &cfg, nil
 }
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestTestMatrixValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix TestMatrix
+		err    string // Пусто — матрица корректна
+	}{
+		{"no matrix", TestMatrix{}, ""},
+		{"negative max_parallel", TestMatrix{MaxParallel: -1}, "max_parallel must not be negative"},
+		{"single cell", TestMatrix{Cells: []MatrixCell{{Name: "default"}}}, ""},
+		{"required and optional", TestMatrix{Cells: []MatrixCell{
+			{Name: "bios"},
+			{Name: "ipv6-only", Optional: true},
+		}}, ""},
+		{"only optional", TestMatrix{Cells: []MatrixCell{
+			{Name: "bios", Optional: true},
+			{Name: "uefi", Optional: true},
+		}}, "at least one cell must be required"},
+		{"duplicate", TestMatrix{Cells: []MatrixCell{{Name: "bios"}, {Name: "bios", Optional: true}}}, `duplicate cell "bios"`},
+		{"empty name", TestMatrix{Cells: []MatrixCell{{Name: ""}}}, "invalid cell name"},
+		{"uppercase name", TestMatrix{Cells: []MatrixCell{{Name: "BIOS"}}}, "invalid cell name"},
+		{"path in name", TestMatrix{Cells: []MatrixCell{{Name: "../x"}}}, "invalid cell name"},
+		{"leading dash", TestMatrix{Cells: []MatrixCell{{Name: "-x"}}}, "invalid cell name"},
+		{"invalid options", TestMatrix{Cells: []MatrixCell{
+			{Name: "volume", TestVMOptions: TestVMOptions{BootVolumeGB: -5}},
+		}}, `cell "volume": boot_volume_gb must not be negative`},
+		{"reserved metadata", TestMatrix{Cells: []MatrixCell{
+			{Name: "meta", TestVMOptions: TestVMOptions{Metadata: map[string]string{reservedMetadataPrefix + "owner": "x"}}},
+		}}, "is reserved"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.matrix.validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTestMatrixYAML(t *testing.T) {
+	data := `
+test_matrix:
+  max_parallel: 3
+  cells:
+    - name: bios
+      flavor: m1.small
+    - name: ipv6-only
+      optional: true
+      networks: [net-v6]
+      config_drive: true
+`
+	var cfg DistroConfig
+	if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	m := cfg.TestMatrix
+	if err := m.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Parallel() != 3 || len(m.CellsOrDefault()) != 2 {
+		t.Fatalf("parallel=%d cells=%d", m.Parallel(), len(m.CellsOrDefault()))
+	}
+	// Параметры VM ячейки — inline рядом с name/optional
+	bios, v6 := m.Cells[0], m.Cells[1]
+	if bios.Optional || bios.Flavor != "m1.small" {
+		t.Errorf("bios: %+v", bios)
+	}
+	if !v6.Optional || len(v6.Networks) != 1 || v6.ConfigDrive == nil || !*v6.ConfigDrive {
+		t.Errorf("ipv6-only: %+v", v6)
+	}
+}
+
+func TestTestMatrixDefaults(t *testing.T) {
+	var m TestMatrix
+	if m.Parallel() != DefaultMaxParallel {
+		t.Errorf("parallel = %d, want %d", m.Parallel(), DefaultMaxParallel)
+	}
+	// Без матрицы — одна безымянная обязательная ячейка
+	cells := m.CellsOrDefault()
+	if len(cells) != 1 || cells[0].Name != "" || cells[0].Optional {
+		t.Errorf("default cells: %+v", cells)
+	}
+}
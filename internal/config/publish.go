@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AuthProfile — набор кредов Keystone, на который ссылаются цели публикации.
+type AuthProfile struct {
+	AuthURL    string `yaml:"auth_url"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	DomainName string `yaml:"domain_name"`
+}
+
+// PublishTarget — регион/проект, куда копируется образ после успешного теста.
+type PublishTarget struct {
+	Name       string `yaml:"name"`
+	Profile    string `yaml:"profile"`
+	Region     string `yaml:"region"`
+	ProjectID  string `yaml:"project_id"`
+	Visibility string `yaml:"visibility"` // private | shared | community | public
+}
+
+// PublishConfig описывает все цели публикации.
+type PublishConfig struct {
+	Profiles map[string]AuthProfile `yaml:"profiles"`
+	Targets  []PublishTarget        `yaml:"targets"`
+}
+
+// LoadPublishConfig читает файл целей публикации.
+// Переменные окружения вида ${VAR} раскрываются, чтобы не хранить пароли в файле.
+// Отсутствие файла не ошибка: публикация просто выключена.
+func LoadPublishConfig(path string) (*PublishConfig, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &PublishConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read publish config %s: %w", path, err)
+	}
+
+	var cfg PublishConfig
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse publish config: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, t := range cfg.Targets {
+		if t.Name == "" {
+			return nil, fmt.Errorf("publish target #%d: name is required", i)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("publish target %s: duplicate name", t.Name)
+		}
+		seen[t.Name] = true
+
+		if _, ok := cfg.Profiles[t.Profile]; !ok {
+			return nil, fmt.Errorf("publish target %s: unknown auth profile %q", t.Name, t.Profile)
+		}
+		switch t.Visibility {
+		case "":
+			cfg.Targets[i].Visibility = "private"
+		case "private", "shared", "community", "public":
+		default:
+			return nil, fmt.Errorf("publish target %s: invalid visibility %q", t.Name, t.Visibility)
+		}
+	}
+
+	return &cfg, nil
+}
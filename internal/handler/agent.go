@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"image-manager/internal/service"
+	pb "image-manager/pkg/pb"
+)
+
+// Максимальный размер запроса агента (details может содержать вывод проверок,
+// кусок диагностики в protojson — base64 от 256 KB)
+const maxAgentReportSize = 1 << 20
+
+// RegisterAgentRoutes настраивает маршруты для агентов внутри тестовых VM.
+// Регистрируются вне basic auth: у агента нет учетки UI, он предъявляет токен сборки (как в gRPC).
+// Клиентского сертификата здесь нет: с включенным mTLS Reporter такие запросы отклоняет.
+func (h *Handler) RegisterAgentRoutes(r chi.Router) {
+	r.Post("/api/agent/report", h.AgentReport)
+	r.Post("/api/agent/heartbeat", h.AgentHeartbeat)
+	r.Post("/api/agent/diagnostics", h.AgentDiagnostics)
+	r.Post("/api/agent/plan", h.AgentTestPlan)
+}
+
+// AgentReport — REST-аналог gRPC ReportStatus для сред, где ingress не пропускает HTTP/2.
+// Тело и ответ — StatusRequest/StatusResponse в protojson, обработка та же (Reporter.Process).
+func (h *Handler) AgentReport(w http.ResponseWriter, r *http.Request) {
+	req := &pb.StatusRequest{}
+	if !readAgentRequest(w, r, req) {
+		return
+	}
+
+	h.log.Info("received report via http",
+		slog.String("vm_id", req.VmId),
+		slog.String("phase", req.Phase),
+		slog.Bool("success", req.Success),
+	)
+
+	resp, err := h.reporter.Process(r.Context(), req)
+	if err != nil {
+		h.agentError(w, err)
+		return
+	}
+	writeAgentResponse(w, resp)
+}
+
+// AgentHeartbeat — промежуточные сообщения агента (START, HEARTBEAT, CHECK_DONE) для HTTP-транспорта,
+// где нет стрима Session. Тело — StatusRequest в protojson.
+func (h *Handler) AgentHeartbeat(w http.ResponseWriter, r *http.Request) {
+	req := &pb.StatusRequest{}
+	if !readAgentRequest(w, r, req) {
+		return
+	}
+
+	resp, err := h.reporter.Heartbeat(r.Context(), req)
+	if err != nil {
+		h.agentError(w, err)
+		return
+	}
+	writeAgentResponse(w, resp)
+}
+
+// AgentDiagnostics — REST-аналог UploadDiagnostics: один DiagnosticsChunk на запрос, в ответ DiagnosticsAck.
+func (h *Handler) AgentDiagnostics(w http.ResponseWriter, r *http.Request) {
+	req := &pb.DiagnosticsChunk{}
+	if !readAgentRequest(w, r, req) {
+		return
+	}
+
+	ack, err := h.reporter.ReceiveDiagnostics(r.Context(), req)
+	if err != nil {
+		h.agentError(w, err)
+		return
+	}
+	writeAgentResponse(w, ack)
+}
+
+// AgentTestPlan — REST-аналог gRPC GetTestPlan (TestPlanRequest -> TestPlan в protojson).
+func (h *Handler) AgentTestPlan(w http.ResponseWriter, r *http.Request) {
+	req := &pb.TestPlanRequest{}
+	if !readAgentRequest(w, r, req) {
+		return
+	}
+
+	h.log.Info("test plan requested via http", slog.String("vm_id", req.VmId))
+
+	plan, err := h.reporter.TestPlan(r.Context(), req)
+	if err != nil {
+		h.agentError(w, err)
+		return
+	}
+	writeAgentResponse(w, plan)
+}
+
+func readAgentRequest(w http.ResponseWriter, r *http.Request, msg proto.Message) bool {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAgentReportSize))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return false
+	}
+	if err := protojson.Unmarshal(body, msg); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeAgentResponse(w http.ResponseWriter, msg proto.Message) {
+	out, err := protojson.Marshal(msg)
+	if err != nil {
+		http.Error(w, "encode error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// agentError переводит ошибки сервиса в HTTP-коды (как grpcError для gRPC).
+func (h *Handler) agentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidToken):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, service.ErrUnknownVM):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrDiagnosticsOffset):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrDiagnosticsTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, service.ErrDiagnosticsNotRequested):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		h.log.Error("failed to process agent request", slog.String("err", err.Error()))
+		http.Error(w, "request processing failed", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListArtifacts возвращает список артефактов сборки (без содержимого).
+func (h *Handler) ListArtifacts(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	list, err := h.store.ListArtifacts(id)
+	if err != nil {
+		h.log.Error("failed to list artifacts", slog.Int64("id", id), slog.String("err", err.Error()))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetArtifact отдает содержимое артефакта сборки.
+// ?download=1 — отдать как файл (Content-Disposition: attachment).
+func (h *Handler) GetArtifact(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	name := chi.URLParam(r, "name")
+
+	a, err := h.store.GetArtifact(id, name)
+	if err != nil {
+		http.Error(w, "artifact not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", a.ContentType)
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="build-%d-%s"`, id, a.Name))
+	}
+	w.Write(a.Content)
+}
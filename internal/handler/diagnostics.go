@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"image-manager/internal/service"
+)
+
+// RequestDiagnostics просит агентов упавших тестовых VM сборки собрать диагностику.
+// Агент ждет на VM после провала тестов и получит команду со следующим heartbeat;
+// архив появится артефактом diagnostics.tar.gz (у ячеек матрицы — <ячейка>.diagnostics.tar.gz).
+func (h *Handler) RequestDiagnostics(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if _, _, err := h.store.GetBuildStatus(id); err != nil {
+		http.Error(w, "build not found", http.StatusNotFound)
+		return
+	}
+
+	// VM с агентом остается только после провала тестов или наблюдения
+	n, err := h.store.RequestDiagnostics(id)
+	if err != nil {
+		h.log.Error("failed to request diagnostics", slog.Int64("id", id), slog.String("err", err.Error()))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "diagnostics are available only for test VMs in ERROR_TEST or ERROR_SOAK while the agent token is valid", http.StatusConflict)
+		return
+	}
+	_ = h.store.AppendLog(id, fmt.Sprintf("Diagnostics requested from %d test VM(s), waiting for agent heartbeat...", n))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{
+		"build_id": id,
+		"artifact": service.DiagnosticsArtifact,
+	})
+}
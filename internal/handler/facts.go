@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"image-manager/internal/storage"
+)
+
+// Сколько сборок показывать в тренде по умолчанию и максимум
+const (
+	defaultTrendLimit = 30
+	maxTrendLimit     = 500
+)
+
+// GetBuildFacts возвращает факты о тестовой VM сборки (vCPU, память, диски, сети, ядро, время загрузки).
+func (h *Handler) GetBuildFacts(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	facts, err := h.store.GetFacts(id)
+	if err != nil {
+		h.log.Error("failed to get facts", slog.Int64("id", id), slog.String("err", err.Error()))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if facts == nil {
+		http.Error(w, "no facts for build", http.StatusNotFound)
+		return
+	}
+
+	// Дистрибутив нужен UI, чтобы рядом показать тренд времени загрузки
+	var distro string
+	if info, err := h.store.GetBuildInfo(id); err == nil {
+		distro = info.Distro
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*storage.BuildFacts
+		Distro string `json:"distro"`
+	}{facts, distro})
+}
+
+// GetBootTimeTrend возвращает время загрузки тестовых VM по последним сборкам дистрибутива.
+// ?distro= обязателен, ?limit= — сколько сборок (по умолчанию 30).
+func (h *Handler) GetBootTimeTrend(w http.ResponseWriter, r *http.Request) {
+	distro := r.URL.Query().Get("distro")
+	if distro == "" {
+		http.Error(w, "distro is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultTrendLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxTrendLimit)
+	}
+
+	points, err := h.store.BootTimes(distro, limit)
+	if err != nil {
+		h.log.Error("failed to get boot times", slog.String("distro", distro), slog.String("err", err.Error()))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(points)
+}
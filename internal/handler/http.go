@@ -12,29 +12,40 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/config"
 	"image-manager/internal/service"
 	"image-manager/internal/storage"
 )
 
 // Handler группирует зависимости
 type Handler struct {
-	log      *slog.Logger
-	builder  *service.Builder
-	store    *storage.Storage
-	osClient *openstack.Client
-	flavorID string
-	netID    string
+	log       *slog.Logger
+	builder   *service.Builder
+	store     *storage.Storage
+	osClient  *openstack.Client
+	publisher *service.Publisher
+	gc        *service.GarbageCollector
+	console   *service.ConsoleCollector
+	reporter  *service.Reporter
+	keys      *service.SSHKeyVault
+	flavorID  string
+	netID     string
 }
 
 // New — конструктор
-func New(log *slog.Logger, b *service.Builder, s *storage.Storage, osc *openstack.Client, flavorID, netID string) *Handler {
+func New(log *slog.Logger, b *service.Builder, s *storage.Storage, osc *openstack.Client, p *service.Publisher, gc *service.GarbageCollector, console *service.ConsoleCollector, reporter *service.Reporter, keys *service.SSHKeyVault, flavorID, netID string) *Handler {
 	return &Handler{
-		log:      log,
-		builder:  b,
-		store:    s,
-		osClient: osc,
-		flavorID: flavorID,
-		netID:    netID,
+		log:       log,
+		builder:   b,
+		store:     s,
+		osClient:  osc,
+		publisher: p,
+		gc:        gc,
+		console:   console,
+		reporter:  reporter,
+		keys:      keys,
+		flavorID:  flavorID,
+		netID:     netID,
 	}
 }
 
@@ -99,8 +110,86 @@ func (w *PhaseDetectWriter) Write(p []byte) (n int, err error) {
 func (h *Handler) RegisterRoutes(r chi.Router) {
 	r.Post("/build", h.StartBuild)
 	r.Get("/api/images", h.GetCloudImages)
+	r.Get("/api/images/{id}/members", h.GetImageMembers)
+	r.Post("/api/images/{id}/members", h.AddImageMember)
+	r.Delete("/api/images/{id}/members", h.RemoveImageMember)
 	r.Get("/api/build/{id}", h.GetBuildStatus)
 	r.Get("/api/history", h.GetBuildHistory)
+	r.Post("/api/build/{id}/publish/retry", h.RetryPublish)
+	r.Get("/api/build/{id}/artifacts", h.ListArtifacts)
+	r.Get("/api/build/{id}/artifacts/{name}", h.GetArtifact)
+	r.Post("/api/build/{id}/diagnostics", h.RequestDiagnostics)
+	r.Get("/api/build/{id}/ssh-key", h.GetSSHKey)
+	r.Get("/api/build/{id}/facts", h.GetBuildFacts)
+	r.Get("/api/trends/boot-time", h.GetBootTimeTrend)
+	r.Get("/api/build/{id}/tests", h.GetBuildTests)
+	r.Get("/api/build/{id}/junit.xml", h.GetBuildJUnit)
+	r.Get("/api/build/{id}/wait", h.WaitBuild)
+	r.Get("/api/gc/report", h.GCReport)
+	r.Post("/api/gc/run", h.GCRun)
+}
+
+// GCReport показывает, что удалит сборщик мусора (dry-run, ничего не удаляет).
+func (h *Handler) GCReport(w http.ResponseWriter, r *http.Request) {
+	items, err := h.gc.Plan()
+	if err != nil {
+		h.log.Error("gc plan failed", slog.String("err", err.Error()))
+		http.Error(w, "gc error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"dry_run": true,
+		"items":   items,
+	})
+}
+
+// GCRun запускает сборку мусора немедленно.
+func (h *Handler) GCRun(w http.ResponseWriter, r *http.Request) {
+	items, err := h.gc.Collect()
+	if err != nil {
+		h.log.Error("gc run failed", slog.String("err", err.Error()))
+		http.Error(w, "gc error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"dry_run": false,
+		"items":   items,
+	})
+}
+
+// RetryPublish повторяет публикацию образа в цели, где она упала.
+func (h *Handler) RetryPublish(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	status, _, err := h.store.GetBuildStatus(id)
+	if err != nil {
+		http.Error(w, "build not found", http.StatusNotFound)
+		return
+	}
+	if status != "SUCCESS" {
+		http.Error(w, "only successful builds can be published", http.StatusConflict)
+		return
+	}
+
+	count, err := h.publisher.RetryFailed(id)
+	if err != nil {
+		h.log.Error("failed to retry publish", slog.Int64("id", id), slog.String("err", err.Error()))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{
+		"build_id": id,
+		"retried":  count,
+	})
 }
 
 // GetBuildHistory возвращает список последних сборок
@@ -131,12 +220,47 @@ func (h *Handler) GetBuildStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	publish, err := h.store.GetPublishTargets(id)
+	if err != nil {
+		h.log.Warn("failed to get publish targets", slog.Int64("id", id), slog.String("err", err.Error()))
+	}
+
+	resp := map[string]any{
+		"id":      idStr,
+		"status":  status,
+		"logs":    logs,
+		"publish": publish,
+	}
+	if hb, err := h.store.GetHeartbeat(id); err == nil && !hb.LastAt.IsZero() {
+		resp["agent"] = hb
+	}
+	if vms, err := h.store.GetTestVMs(id); err == nil {
+		// Окно наблюдения, которое сборка ждет дольше всего
+		var soak *storage.Soak
+		for _, vm := range vms {
+			if s, err := h.store.GetSoak(vm.ID); err == nil && s != nil && (soak == nil || s.EndsAt.After(soak.EndsAt)) {
+				soak = s
+			}
+		}
+		if soak != nil {
+			soak.RemainingSeconds = max(0, int(time.Until(soak.EndsAt).Seconds()))
+			resp["soak"] = soak
+		}
+		// Параметры, с которыми созданы VM (флейвор, сети, том, группы безопасности...)
+		if len(vms) > 0 {
+			resp["test_vms"] = vms
+		}
+	}
+	// Ключ VM, оставленной для отладки, может скачать администратор
+	if name, encrypted, err := h.store.GetSSHKey(id); err == nil && name != "" {
+		resp["ssh_key"] = map[string]any{
+			"name":      name,
+			"available": encrypted != nil && h.hasDebugVM(id),
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"id":     idStr,
-		"status": status,
-		"logs":   logs,
-	})
+	json.NewEncoder(w).Encode(resp)
 }
 
 // GetCloudImages возвращает список образов из OpenStack
@@ -157,6 +281,10 @@ func (h *Handler) StartBuild(w http.ResponseWriter, r *http.Request) {
 	type Request struct {
 		ImageName string `json:"image_name"`
 		Distro    string `json:"distro"`
+		Force     bool   `json:"force"` // Пройти весь пайплайн, даже если образ не изменился
+
+		// Параметры тестовых VM поверх test_vm дистрибутива (ячейки матрицы — поверх них)
+		TestVM config.TestVMOptions `json:"test_vm"`
 	}
 	var req Request
 
@@ -169,10 +297,14 @@ func (h *Handler) StartBuild(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "image_name and distro fields are required", http.StatusBadRequest)
 		return
 	}
+	if err := req.TestVM.Validate(); err != nil {
+		http.Error(w, "invalid test_vm: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	h.log.Info("received build request", slog.String("image", req.ImageName))
 
-	id, err := h.store.CreateBuild(req.ImageName)
+	id, err := h.store.CreateBuild(req.ImageName, req.Distro)
 	if err != nil {
 		h.log.Error("failed to save build to db", slog.String("error", err.Error()))
 		http.Error(w, "database error", http.StatusInternalServerError)
@@ -207,6 +339,38 @@ func (h *Handler) StartBuild(w http.ResponseWriter, r *http.Request) {
 		}
 		_ = h.store.AppendLog(id, "Build successful. Image size optimized.")
 
+		// Сравниваем отпечаток сборки с боевым образом: если ничего не поменялось,
+		// нет смысла заливать, бутить и тестировать кандидата.
+		fingerprint, err := h.builder.Fingerprint(req.ImageName, req.Distro)
+		if err != nil {
+			h.log.Warn("failed to fingerprint build (ignoring)", slog.String("err", err.Error()))
+			_ = h.store.AppendLog(id, fmt.Sprintf("Fingerprint unavailable, running full pipeline: %s", err.Error()))
+		}
+
+		// Метки владельца: по ним сборщик мусора найдет кандидата, даже если мы упадем до записи в БД
+		imageProps := h.gc.Tags(id)
+		if fingerprint != nil {
+			for k, v := range fingerprint.Properties() {
+				imageProps[k] = v
+			}
+			_ = h.store.AppendLog(id, fmt.Sprintf("Build fingerprint: manifest=%s content=%s", fingerprint.ManifestSHA256, fingerprint.ContentSHA256))
+
+			if req.Force {
+				_ = h.store.AppendLog(id, "Force flag set: skipping comparison with production image.")
+			} else {
+				prodProps, err := h.osClient.GetImageProperties(req.ImageName)
+				if err != nil {
+					h.log.Warn("failed to get production image properties (ignoring)", slog.String("err", err.Error()))
+					_ = h.store.AppendLog(id, fmt.Sprintf("Production image unavailable for comparison, running full pipeline: %s", err.Error()))
+				} else if fingerprint.Matches(prodProps) {
+					h.log.Info("background: image unchanged, skipping upload", slog.Int64("id", id))
+					_ = h.store.UpdateBuildStatus(id, "NO_CHANGE")
+					_ = h.store.AppendLog(id, "Image is identical to production (same package manifest and content). Nothing to do.")
+					return
+				}
+			}
+		}
+
 		// ШАГ Б: Загрузка
 		_ = h.store.UpdateBuildStatus(id, "UPLOADING")
 		_ = h.store.AppendLog(id, "Uploading to OpenStack Glance (Candidate)...")
@@ -215,91 +379,64 @@ func (h *Handler) StartBuild(w http.ResponseWriter, r *http.Request) {
 		
 		h.log.Info("background: starting upload", slog.String("file", targetFilename))
 
-		// Очищаем старых кандидатов перед загрузкой нового
-		if err := h.osClient.DeleteImageByName(candidateName); err != nil {
-			h.log.Warn("failed to delete old candidate (ignoring)", slog.String("err", err.Error()))
-		}
-
-		// Удаляем все зависшие образы с candidate в имени и статусом queued
-		if err := h.osClient.CleanupQueuedCandidates(); err != nil {
-			h.log.Warn("failed to cleanup queued candidates (ignoring)", slog.String("err", err.Error()))
-		}
+		// Очищаем наших старых кандидатов перед загрузкой нового.
+		// Чужие образы (даже с "candidate" в имени) не трогаем.
+		h.gc.CollectSuperseded(candidateName)
 
-		glanceID, err := h.osClient.UploadImage(targetFilename, candidateName)
+		glanceID, err := h.osClient.UploadImage(targetFilename, candidateName, imageProps)
 		if err != nil {
 			h.log.Error("background: upload failed", slog.String("error", err.Error()))
 			_ = h.store.UpdateBuildStatus(id, "ERROR_UPLOAD")
 			_ = h.store.AppendLog(id, fmt.Sprintf("Upload failed: %s", err.Error()))
-			// После ошибки загрузки чистим зависшие queued-кандидаты
-			if cleanErr := h.osClient.CleanupQueuedCandidates(); cleanErr != nil {
-				h.log.Warn("post-failure cleanup failed", slog.String("err", cleanErr.Error()))
-			}
+			// Зависший образ (если UploadImage не смог его удалить) найдет GC по метке
 			return
 		}
 		
 		_ = h.store.SetGlanceID(id, glanceID)
+		h.gc.Track(id, service.ResourceImage, glanceID, candidateName)
 
 		h.log.Info("background: image uploaded", slog.String("glance_id", glanceID))
 		_ = h.store.AppendLog(id, fmt.Sprintf("Candidate uploaded. ID: %s", glanceID))
 
-		// ШАГ В: Создание VM
+		// ШАГ В: Создание тестовых VM
 		_ = h.store.UpdateBuildStatus(id, "BOOTING_VM")
-		_ = h.store.AppendLog(id, "Creating Test VM...")
-		h.log.Info("background: creating test vm...")
-
-		vmName := req.ImageName + "-test-agent"
-		vmID, err := h.osClient.CreateVM(vmName, glanceID, h.flavorID, h.netID, "")
 
+		// Одноразовый токен (и клиентский сертификат при mTLS): без них отчет VM не примут.
+		// Один на сборку: все VM матрицы отчитываются им
+		var userData string
+		creds, err := h.reporter.IssueCredentials(id)
+		if err == nil {
+			userData, err = service.AgentUserData(creds)
+		}
 		if err != nil {
-			h.log.Error("background: vm create failed", slog.String("error", err.Error()))
+			h.log.Error("background: failed to issue agent credentials", slog.String("error", err.Error()))
 			_ = h.store.UpdateBuildStatus(id, "ERROR_VM_BOOT")
-			_ = h.store.AppendLog(id, fmt.Sprintf("VM boot failed: %s", err.Error()))
+			_ = h.store.AppendLog(id, fmt.Sprintf("Failed to issue agent credentials: %s", err.Error()))
+			h.gc.ReleaseToken(id) // Токен мог выпуститься до ошибки сертификата
 			return
 		}
-		
-		_ = h.store.SetVMID(id, vmID)
-		_ = h.store.AppendLog(id, fmt.Sprintf("VM created. ID: %s. Waiting for ACTIVE status...", vmID))
 
-		// Ждем, пока VM станет ACTIVE
-		if err := h.osClient.WaitForVMActive(vmID, 5*time.Minute); err != nil {
-			h.log.Error("background: vm failed to become active", slog.String("error", err.Error()))
-			_ = h.store.UpdateBuildStatus(id, "ERROR_VM_BOOT")
-			_ = h.store.AppendLog(id, fmt.Sprintf("VM boot failed (not active): %s", err.Error()))
-			// Пытаемся удалить сломанную VM
-			_ = h.osClient.DeleteVM(vmID)
-			return
+		// Эфемерная ключевая пара сборки: приватный ключ выдается администратору для отладки
+		// оставленной VM. Не вышло — VM идут с общей парой OS_SSH_KEY_NAME
+		keyName, err := h.keys.Issue(id)
+		if err != nil {
+			h.log.Warn("failed to create ssh keypair (using shared key)", slog.String("error", err.Error()))
+			_ = h.store.AppendLog(id, fmt.Sprintf("Failed to create SSH keypair, test VMs use the shared key: %s", err.Error()))
+		} else {
+			_ = h.store.AppendLog(id, fmt.Sprintf("SSH keypair %s created for test VMs.", keyName))
 		}
 
-		_ = h.store.AppendLog(id, "VM is ACTIVE. Waiting for agent report...")
-
-		// ШАГ Г: Ожидание агента
-		h.log.Info("background: vm active, waiting for agent...", slog.String("vm_id", vmID))
-		_ = h.store.UpdateBuildStatus(id, "WAITING_AGENT")
-
-		// WATCHDOG (10 min total: 3 warning + 7 kill)
-		go func(bid int64, vid string) {
-            // 1. Wait 3 minutes for initial check
-            time.Sleep(3 * time.Minute)
-			status, _, _ := h.store.GetBuildStatus(bid)
-            if status == "WAITING_AGENT" {
-                // Change status to ERROR_TIMEOUT so UI shows red, but keep VM alive
-                _ = h.store.UpdateBuildStatus(bid, "ERROR_TIMEOUT")
-                _ = h.store.AppendLog(bid, "TIMEOUT: Agent did not report in 3 minutes. Please start agent manually: /usr/local/bin/agent. VM will be terminated in 7 minutes.")
-            }
-
-            // 2. Wait remaining 7 minutes before killing
-			time.Sleep(7 * time.Minute)
-			status, _, _ = h.store.GetBuildStatus(bid)
-            // Kill if it's still in error state or waiting (meaning no success report came in)
-			if status == "WAITING_AGENT" || status == "ERROR_TIMEOUT" {
-				h.log.Warn("WATCHDOG: Final timeout reached", slog.Int64("id", bid))
-                // Ensure status is error
-				_ = h.store.UpdateBuildStatus(bid, "ERROR_TIMEOUT") 
-				_ = h.store.AppendLog(bid, "FINAL TIMEOUT: Terminating VM.")
-				_ = h.osClient.DeleteVM(vid)
-			}
-		}(id, vmID)
+		// Без матрицы — одна VM с флейвором и сетью менеджера
+		var matrix config.TestMatrix
+		base := req.TestVM
+		if distroCfg, err := config.LoadDistroConfig(req.Distro); err == nil {
+			matrix = distroCfg.TestMatrix
+			base = distroCfg.TestVM.Merge(req.TestVM)
+		} else {
+			h.log.Warn("failed to load distro config, using a single test vm", slog.String("err", err.Error()))
+		}
 
+		go h.launchTestVMs(id, req.ImageName, req.Distro, glanceID, userData, keyName, matrix, base)
 	}()
 
 	w.Header().Set("Content-Type", "application/json")
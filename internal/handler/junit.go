@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"image-manager/internal/service"
+	"image-manager/internal/storage"
+)
+
+// Сколько последних строк лога сборки прикладывать к упавшему этапу
+const junitLogTail = 50
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr,omitempty"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+// Этапы пайплайна в порядке выполнения: статусы, которыми заканчивается провал этапа, статусы,
+// пока он идет, и статусы, на которых пайплайн остановился после пройденного этапа
+// (ERROR_TEST — отчет агента пришел, но проверки упали: до soak и promote дело не дошло).
+var junitStages = []struct {
+	name      string
+	failure   []string
+	inProcess []string
+	stop      []string
+}{
+	{"build", []string{"ERROR_BUILD"}, []string{"PENDING", "BUILDING"}, nil},
+	{"upload", []string{"ERROR_UPLOAD"}, []string{"UPLOADING"}, nil},
+	{"vm-boot", []string{"ERROR_VM_BOOT"}, []string{"BOOTING_VM"}, nil},
+	{"agent-report", []string{"ERROR_TIMEOUT", service.StatusTerminated}, []string{"WAITING_AGENT"}, []string{"ERROR_TEST"}},
+	{"soak", []string{"ERROR_SOAK"}, []string{service.StatusSoaking}, nil},
+	{"promote", nil, []string{service.StatusPromoting}, nil},
+}
+
+// GetBuildJUnit отдает результаты сборки в формате JUnit XML (для Jenkins).
+// Этапы пайплайна (DIB, загрузка, загрузка VM, отчет агента, soak, promote) и проверки агента —
+// отдельные test case.
+func (h *Handler) GetBuildJUnit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	info, err := h.store.GetBuildInfo(id)
+	if err != nil {
+		http.Error(w, "build not found", http.StatusNotFound)
+		return
+	}
+	status, logs, err := h.store.GetBuildStatus(id)
+	if err != nil {
+		http.Error(w, "build not found", http.StatusNotFound)
+		return
+	}
+	results, err := h.store.GetTestResults(id)
+	if err != nil {
+		h.log.Error("failed to get test results", slog.Int64("id", id), slog.String("err", err.Error()))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	suite := buildJUnitSuite(info, status, logs, results)
+
+	out, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		http.Error(w, "encode error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
+
+func buildJUnitSuite(info *storage.BuildInfo, status, logs string, results []storage.TestResult) junitTestSuite {
+	suite := junitTestSuite{Name: fmt.Sprintf("image-manager.build-%d.%s", info.ID, info.ImageName)}
+	stageClass := "image-manager.pipeline"
+	checkClass := "image-manager.agent"
+	if info.Distro != "" {
+		checkClass += "." + info.Distro
+	}
+
+	// Фазы DIB (BUILD_INSTALL и т.п.) — это этап build
+	phase := status
+	if strings.HasPrefix(status, "BUILD_") {
+		phase = "BUILDING"
+	}
+
+	// Пока этап не провалился и не идет — он пройден; после текущего/упавшего — не выполнялся
+	reached := true
+	for _, st := range junitStages {
+		tc := junitTestCase{Name: st.name, ClassName: stageClass}
+
+		switch {
+		case !reached:
+			tc.Skipped = &junitMessage{Message: "not reached"}
+		case status == "NO_CHANGE" && st.name != "build":
+			tc.Skipped = &junitMessage{Message: "image unchanged, stage skipped"}
+		case slices.Contains(st.failure, status):
+			tc.Failure = &junitMessage{Message: status, Body: tailLines(logs, junitLogTail)}
+			reached = false
+		case slices.Contains(st.inProcess, phase):
+			tc.Skipped = &junitMessage{Message: "in progress (" + status + ")"}
+			reached = false
+		case slices.Contains(st.stop, status):
+			reached = false
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	for _, res := range results {
+		tc := junitTestCase{
+			Name:      res.Name,
+			ClassName: checkClass,
+			Time:      fmt.Sprintf("%.3f", float64(res.DurationMs)/1000),
+			SystemOut: res.Stdout,
+			SystemErr: res.Stderr,
+		}
+		body := checkFailureBody(res)
+		switch res.Status {
+		case service.CheckFail:
+			tc.Failure = &junitMessage{Message: res.Message, Body: body}
+		case service.CheckError:
+			tc.Error = &junitMessage{Message: res.Message, Body: body}
+		case service.CheckSkip:
+			tc.Skipped = &junitMessage{Message: res.Message}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	// Старый агент: результатов по проверкам нет, но тест упал
+	if len(results) == 0 && status == "ERROR_TEST" {
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      "agent-tests",
+			ClassName: checkClass,
+			Failure:   &junitMessage{Message: status, Body: tailLines(logs, junitLogTail)},
+		})
+	}
+
+	for _, tc := range suite.Cases {
+		suite.Tests++
+		switch {
+		case tc.Failure != nil:
+			suite.Failures++
+		case tc.Error != nil:
+			suite.Errors++
+		case tc.Skipped != nil:
+			suite.Skipped++
+		}
+	}
+	return suite
+}
+
+func checkFailureBody(res storage.TestResult) string {
+	var parts []string
+	if res.Expected != "" {
+		parts = append(parts, "expected: "+res.Expected)
+	}
+	if res.Actual != "" {
+		parts = append(parts, "actual: "+res.Actual)
+	}
+	return strings.Join(parts, "\n")
+}
+
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
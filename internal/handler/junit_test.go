@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"image-manager/internal/service"
+	"image-manager/internal/storage"
+)
+
+// stageOutcome сводит test case этапа к одному слову для сравнения.
+func stageOutcome(tc junitTestCase) string {
+	switch {
+	case tc.Failure != nil:
+		return "failure"
+	case tc.Error != nil:
+		return "error"
+	case tc.Skipped != nil:
+		return "skipped"
+	}
+	return "passed"
+}
+
+func TestBuildJUnitSuiteStages(t *testing.T) {
+	info := &storage.BuildInfo{ID: 7, ImageName: "Ubuntu-24", Distro: "ubuntu"}
+	tests := []struct {
+		status string
+		want   []string // build, upload, vm-boot, agent-report, soak, promote
+	}{
+		{"BUILDING", []string{"skipped", "skipped", "skipped", "skipped", "skipped", "skipped"}},
+		{"BUILD_INSTALL", []string{"skipped", "skipped", "skipped", "skipped", "skipped", "skipped"}},
+		{"ERROR_BUILD", []string{"failure", "skipped", "skipped", "skipped", "skipped", "skipped"}},
+		{"NO_CHANGE", []string{"passed", "skipped", "skipped", "skipped", "skipped", "skipped"}},
+		{"UPLOADING", []string{"passed", "skipped", "skipped", "skipped", "skipped", "skipped"}},
+		{"ERROR_VM_BOOT", []string{"passed", "passed", "failure", "skipped", "skipped", "skipped"}},
+		{"WAITING_AGENT", []string{"passed", "passed", "passed", "skipped", "skipped", "skipped"}},
+		{"ERROR_TIMEOUT", []string{"passed", "passed", "passed", "failure", "skipped", "skipped"}},
+		{"TERMINATED", []string{"passed", "passed", "passed", "failure", "skipped", "skipped"}},
+		{"ERROR_TEST", []string{"passed", "passed", "passed", "passed", "skipped", "skipped"}},
+		{"SOAKING", []string{"passed", "passed", "passed", "passed", "skipped", "skipped"}},
+		{"ERROR_SOAK", []string{"passed", "passed", "passed", "passed", "failure", "skipped"}},
+		{"PROMOTING", []string{"passed", "passed", "passed", "passed", "passed", "skipped"}},
+		{"SUCCESS", []string{"passed", "passed", "passed", "passed", "passed", "passed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			suite := buildJUnitSuite(info, tt.status, "line 1\nline 2\n", nil)
+			if len(suite.Cases) < len(junitStages) {
+				t.Fatalf("got %d cases", len(suite.Cases))
+			}
+			for i, st := range junitStages {
+				if got := stageOutcome(suite.Cases[i]); got != tt.want[i] {
+					t.Errorf("stage %s: %s, want %s", st.name, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildJUnitSuiteChecks(t *testing.T) {
+	info := &storage.BuildInfo{ID: 7, ImageName: "Ubuntu-24", Distro: "ubuntu"}
+	results := []storage.TestResult{
+		{Name: "cloud_init", Status: service.CheckPass, DurationMs: 1500, Stdout: "status: done"},
+		{Name: "ssh_host_keys", Status: service.CheckFail, Message: "host keys baked into image", Expected: "host keys generated after boot", Actual: "ssh_host_rsa_key"},
+		{Name: "dns", Status: service.CheckError, Message: "timeout", Stderr: "resolv.conf missing"},
+		{Name: "selinux", Status: service.CheckSkip, Message: "not applicable"},
+	}
+	suite := buildJUnitSuite(info, "ERROR_TEST", "", results)
+
+	if suite.Name != "image-manager.build-7.Ubuntu-24" {
+		t.Errorf("suite name %q", suite.Name)
+	}
+	// 6 этапов + 4 проверки; agent-tests для старого агента не добавляется. soak и promote не выполнялись
+	if suite.Tests != 10 || suite.Failures != 1 || suite.Errors != 1 || suite.Skipped != 3 {
+		t.Errorf("counters tests=%d failures=%d errors=%d skipped=%d", suite.Tests, suite.Failures, suite.Errors, suite.Skipped)
+	}
+
+	checks := suite.Cases[len(junitStages):]
+	if checks[0].ClassName != "image-manager.agent.ubuntu" || checks[0].Time != "1.500" || checks[0].SystemOut != "status: done" {
+		t.Errorf("pass case: %+v", checks[0])
+	}
+	if f := checks[1].Failure; f == nil || f.Message != "host keys baked into image" ||
+		f.Body != "expected: host keys generated after boot\nactual: ssh_host_rsa_key" {
+		t.Errorf("fail case: %+v", checks[1].Failure)
+	}
+	if checks[2].Error == nil || checks[2].SystemErr != "resolv.conf missing" {
+		t.Errorf("error case: %+v", checks[2])
+	}
+	if checks[3].Skipped == nil || checks[3].Skipped.Message != "not applicable" {
+		t.Errorf("skip case: %+v", checks[3])
+	}
+}
+
+func TestBuildJUnitSuiteOldAgent(t *testing.T) {
+	info := &storage.BuildInfo{ID: 1, ImageName: "Debian-12"}
+	suite := buildJUnitSuite(info, "ERROR_TEST", "agent: FAIL\n", nil)
+
+	last := suite.Cases[len(suite.Cases)-1]
+	if last.Name != "agent-tests" || last.ClassName != "image-manager.agent" || last.Failure == nil {
+		t.Fatalf("got %+v, want failed agent-tests", last)
+	}
+	if last.Failure.Body != "agent: FAIL" {
+		t.Errorf("failure body %q", last.Failure.Body)
+	}
+}
+
+// Watchdog уронил VM в окне soak (агент замолчал): проверок с провалом нет, но сборка упала.
+func TestBuildJUnitSuiteSoakWatchdog(t *testing.T) {
+	info := &storage.BuildInfo{ID: 3, ImageName: "Ubuntu-24", Distro: "ubuntu"}
+	results := []storage.TestResult{{Name: "cloud_init", Status: service.CheckPass}}
+	suite := buildJUnitSuite(info, "ERROR_SOAK", "SOAK FAILED: agent silent for 3m0s during the soak window.\n", results)
+
+	if suite.Failures != 1 {
+		t.Fatalf("got %d failures, want 1", suite.Failures)
+	}
+	var soak junitTestCase
+	for _, tc := range suite.Cases {
+		if tc.Name == "soak" {
+			soak = tc
+		}
+	}
+	if soak.Failure == nil || soak.Failure.Message != "ERROR_SOAK" || !strings.Contains(soak.Failure.Body, "SOAK FAILED") {
+		t.Errorf("soak case: %+v", soak)
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 60; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	got := tailLines(strings.Join(lines, "\n")+"\n", junitLogTail)
+	if want := strings.Join(lines[10:], "\n"); got != want {
+		t.Errorf("got %d lines starting %q", strings.Count(got, "\n")+1, strings.SplitN(got, "\n", 2)[0])
+	}
+	if got := tailLines("one\ntwo", 5); got != "one\ntwo" {
+		t.Errorf("short log changed: %q", got)
+	}
+}
+
+func TestGetBuildJUnit(t *testing.T) {
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	id, err := store.CreateBuild("Ubuntu-24", "ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.UpdateBuildStatus(id, "ERROR_TEST")
+	_ = store.AppendLog(id, "Agent: [FAIL] ssh_host_keys <stale & baked>")
+	err = store.SaveTestResults(id, []storage.TestResult{
+		{Name: "ssh_host_keys", Status: service.CheckFail, Message: `keys "baked" <into> image`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{log: slog.New(slog.NewTextHandler(io.Discard, nil)), store: store}
+	router := chi.NewRouter()
+	router.Get("/api/build/{id}/junit.xml", h.GetBuildJUnit)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/build/%d/junit.xml", id), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/xml" {
+		t.Errorf("content type %q", ct)
+	}
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, xml.Header) {
+		t.Errorf("no xml header: %q", body[:min(len(body), 40)])
+	}
+
+	// Спецсимволы из сообщений и лога экранированы: документ разбирается обратно
+	var doc junitTestSuites
+	if err := xml.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid xml: %v", err)
+	}
+	if len(doc.Suites) != 1 {
+		t.Fatalf("got %d suites", len(doc.Suites))
+	}
+	suite := doc.Suites[0]
+	if suite.Tests != 7 || suite.Failures != 1 {
+		t.Errorf("tests=%d failures=%d, want 7 and 1", suite.Tests, suite.Failures)
+	}
+	check := suite.Cases[len(suite.Cases)-1]
+	if check.Failure == nil || check.Failure.Message != `keys "baked" <into> image` {
+		t.Errorf("check case: %+v", check)
+	}
+
+	for path, code := range map[string]int{
+		"/api/build/abc/junit.xml": http.StatusBadRequest,
+		"/api/build/999/junit.xml": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != code {
+			t.Errorf("%s: status %d, want %d", path, rec.Code, code)
+		}
+	}
+}
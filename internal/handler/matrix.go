@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/config"
+	"image-manager/internal/service"
+)
+
+// testVM — ячейка матрицы, зарегистрированная в БД и ждущая запуска.
+type testVM struct {
+	id   int64
+	cell config.MatrixCell
+	opts openstack.VMOptions
+}
+
+// launchTestVMs создает тестовые VM кандидата по ячейкам матрицы, не больше matrix.Parallel()
+// одновременно. Слот освобождается, когда VM перестала ждать агента (прошла, упала или удалена watchdog).
+// base — параметры VM дистрибутива и запроса, ячейки накладываются поверх. keyName — ключевая
+// пара сборки (пусто — общая).
+func (h *Handler) launchTestVMs(buildID int64, imageName, distro, glanceID, userData, keyName string, matrix config.TestMatrix, base config.TestVMOptions) {
+	cells := matrix.CellsOrDefault()
+	if len(matrix.Cells) > 0 {
+		_ = h.store.AppendLog(buildID, fmt.Sprintf("Test matrix: %d cells, up to %d VMs in parallel.", len(cells), matrix.Parallel()))
+	}
+
+	vms := make([]testVM, 0, len(cells))
+	for _, cell := range cells {
+		opts := h.vmOptions(buildID, imageName, distro, cell, base)
+		opts.KeyName = keyName
+		// Параметры записываются в сборку такими, с какими VM реально создается
+		raw, _ := json.Marshal(opts)
+		id, err := h.store.AddTestVM(buildID, cell.Name, !cell.Optional, raw)
+		if err != nil {
+			h.log.Error("background: failed to register test vm", slog.String("error", err.Error()))
+			_ = h.store.UpdateBuildStatus(buildID, "ERROR_VM_BOOT")
+			_ = h.store.AppendLog(buildID, fmt.Sprintf("Failed to register test VM: %s", err.Error()))
+			h.gc.ReleaseToken(buildID)
+			return
+		}
+		vms = append(vms, testVM{id: id, cell: cell, opts: opts})
+	}
+
+	slots := make(chan struct{}, matrix.Parallel())
+	for _, vm := range vms {
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots }()
+			h.runTestVM(buildID, imageName, glanceID, userData, vm)
+		}()
+	}
+}
+
+// vmOptions — параметры VM ячейки поверх base; незаданные берутся из конфига менеджера.
+func (h *Handler) vmOptions(buildID int64, imageName, distro string, cell config.MatrixCell, base config.TestVMOptions) openstack.VMOptions {
+	o := base.Merge(cell.TestVMOptions)
+	opts := openstack.VMOptions{
+		FlavorID:         o.Flavor,
+		NetworkIDs:       o.Networks,
+		SecurityGroups:   o.SecurityGroups,
+		ConfigDrive:      o.ConfigDrive != nil && *o.ConfigDrive,
+		BootVolumeGB:     o.BootVolumeGB,
+		BootVolumeType:   o.BootVolumeType,
+		AvailabilityZone: o.AvailabilityZone,
+		Metadata:         o.Metadata,
+	}
+	if opts.FlavorID == "" {
+		opts.FlavorID = h.flavorID
+	}
+	if len(opts.NetworkIDs) == 0 {
+		opts.NetworkIDs = []string{h.netID}
+	}
+
+	// Флейвор без диска: из образа не загрузиться, нужен том
+	if opts.BootVolumeGB == 0 {
+		if flavor, err := h.osClient.GetFlavor(opts.FlavorID); err != nil {
+			h.log.Warn("failed to get flavor (ignoring)", slog.String("flavor", opts.FlavorID), slog.String("err", err.Error()))
+		} else if flavor.DiskGB == 0 {
+			opts.BootVolumeGB = config.DefaultBootVolumeGB
+			_ = h.store.AppendLog(buildID, service.LogPrefix(cell.Name)+fmt.Sprintf("Flavor %s has no root disk: booting from a %d GB volume.", flavor.Name, opts.BootVolumeGB))
+		}
+	}
+
+	// Метки сборки: по ним GC находит VM, а оператор — сборку по серверу
+	metadata := h.gc.Tags(buildID)
+	for k, v := range o.Metadata {
+		metadata[k] = v
+	}
+	metadata[service.TagImage] = imageName
+	metadata[service.TagDistro] = distro
+	opts.Tags = []string{"image-manager", fmt.Sprintf("build-%d", buildID)}
+	if cell.Name != "" {
+		metadata[service.TagCell] = cell.Name
+		opts.Tags = append(opts.Tags, "cell-"+cell.Name)
+	}
+	opts.Metadata = metadata
+	return opts
+}
+
+// runTestVM создает VM ячейки и ждет, пока она перестанет ждать агента.
+func (h *Handler) runTestVM(buildID int64, imageName, glanceID, userData string, vm testVM) {
+	prefix := service.LogPrefix(vm.cell.Name)
+
+	// Пока ячейка ждала слота, обязательная VM могла упасть
+	if !h.reporter.SetVMStatus(buildID, vm.id, "PENDING", "BOOTING_VM") {
+		return
+	}
+	_ = h.store.AppendLog(buildID, prefix+"Creating Test VM...")
+	h.log.Info("background: creating test vm...", slog.String("cell", vm.cell.Name))
+
+	vmName := imageName + "-test-agent"
+	if vm.cell.Name != "" {
+		vmName += "-" + vm.cell.Name
+	}
+	vmID, err := h.osClient.CreateVM(vmName, glanceID, userData, vm.opts)
+	if err != nil {
+		h.log.Error("background: vm create failed", slog.String("error", err.Error()))
+		_ = h.store.AppendLog(buildID, prefix+fmt.Sprintf("VM boot failed: %s", err.Error()))
+		h.reporter.SetVMStatus(buildID, vm.id, "BOOTING_VM", "ERROR_VM_BOOT")
+		return
+	}
+
+	_ = h.store.SetTestVMServer(vm.id, vmID)
+	h.gc.Track(buildID, service.ResourceServer, vmID, vmName)
+	_ = h.store.AppendLog(buildID, prefix+fmt.Sprintf("VM created. ID: %s. Waiting for ACTIVE status...", vmID))
+
+	// Ждем, пока VM станет ACTIVE
+	if err := h.osClient.WaitForVMActive(vmID, 5*time.Minute); err != nil {
+		h.log.Error("background: vm failed to become active", slog.String("error", err.Error()))
+		_ = h.store.AppendLog(buildID, prefix+fmt.Sprintf("VM boot failed (not active): %s", err.Error()))
+		h.console.Capture(buildID, vm.cell.Name, vmID, "ERROR_VM_BOOT")
+		// Пытаемся удалить сломанную VM
+		_ = h.gc.DeleteServer(vmID)
+		h.reporter.SetVMStatus(buildID, vm.id, "BOOTING_VM", "ERROR_VM_BOOT")
+		return
+	}
+
+	// ШАГ Г: Ожидание агента
+	if !h.reporter.SetVMStatus(buildID, vm.id, "BOOTING_VM", "WAITING_AGENT") {
+		// Агент успел отчитаться раньше, чем мы увидели ACTIVE, — или ячейку отменили
+		if cur, err := h.store.GetTestVM(vm.id); err == nil && cur.Status == service.StatusCancelled {
+			_ = h.gc.DeleteServer(vmID)
+			return
+		}
+	}
+	_ = h.store.AppendLog(buildID, prefix+"VM is ACTIVE. Waiting for agent report...")
+	h.log.Info("background: vm active, waiting for agent...", slog.String("vm_id", vmID))
+
+	// Запасной канал: агент не достучался до gRPC и написал подписанный отчет в серийную консоль.
+	// Ждем столько же, сколько watchdog может держать VM.
+	go h.reporter.WatchConsole(buildID, vmID, 20*time.Second, agentMaxWait)
+
+	h.watchAgent(buildID, vmID, time.Now())
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type memberRequest struct {
+	ProjectID string `json:"project_id"`
+}
+
+// GetImageMembers возвращает проекты, с которыми расшарен образ, и статус принятия.
+func (h *Handler) GetImageMembers(w http.ResponseWriter, r *http.Request) {
+	imageID := chi.URLParam(r, "id")
+
+	list, err := h.osClient.ListMembers(imageID)
+	if err != nil {
+		h.log.Error("failed to list image members", slog.String("image", imageID), slog.String("error", err.Error()))
+		http.Error(w, "upstream error", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// AddImageMember расшаривает образ проекту.
+func (h *Handler) AddImageMember(w http.ResponseWriter, r *http.Request) {
+	imageID := chi.URLParam(r, "id")
+
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+		http.Error(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.osClient.AddMember(imageID, req.ProjectID); err != nil {
+		h.log.Error("failed to add image member", slog.String("image", imageID), slog.String("error", err.Error()))
+		http.Error(w, "upstream error", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// RemoveImageMember отзывает доступ проекта к образу.
+func (h *Handler) RemoveImageMember(w http.ResponseWriter, r *http.Request) {
+	imageID := chi.URLParam(r, "id")
+
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+		http.Error(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.osClient.RemoveMember(imageID, req.ProjectID); err != nil {
+		h.log.Error("failed to remove image member", slog.String("image", imageID), slog.String("error", err.Error()))
+		http.Error(w, "upstream error", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"image-manager/internal/service"
+)
+
+type adminKey struct{}
+
+// WithAdmin помечает запрос как пришедший от администратора (проверяет basic auth в main).
+func WithAdmin(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), adminKey{}, true))
+}
+
+// isAdmin сообщает, что запрос пришел с учеткой администратора.
+func isAdmin(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminKey{}).(bool)
+	return admin
+}
+
+// keptForDebug — статусы, в которых тестовая VM остается жить для отладки.
+func keptForDebug(status string) bool {
+	return status == "ERROR_TEST" || status == "ERROR_SOAK" || status == "ERROR_TIMEOUT"
+}
+
+// hasDebugVM сообщает, что у сборки есть тестовая VM, оставленная для отладки и еще не удаленная.
+func (h *Handler) hasDebugVM(buildID int64) bool {
+	vms, err := h.store.GetTestVMs(buildID)
+	if err != nil {
+		return false
+	}
+	for _, vm := range vms {
+		if vm.VMID == "" || !keptForDebug(vm.Status) {
+			continue
+		}
+		if live, err := h.store.IsResourceLive(service.ResourceServer, vm.VMID); err == nil && live {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSSHKey отдает приватный ключ эфемерной ключевой пары сборки (PEM). Только администратору
+// и только пока тестовая VM оставлена для отладки: вместе с последней VM пара и ключ удаляются.
+func (h *Handler) GetSSHKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if !isAdmin(r) {
+		http.Error(w, "admin credentials required", http.StatusForbidden)
+		return
+	}
+
+	if _, _, err := h.store.GetBuildStatus(id); err != nil {
+		http.Error(w, "build not found", http.StatusNotFound)
+		return
+	}
+
+	if !h.hasDebugVM(id) {
+		http.Error(w, "ssh key is available only while a test VM is kept for debugging (ERROR_TEST, ERROR_SOAK, ERROR_TIMEOUT)", http.StatusConflict)
+		return
+	}
+
+	key, err := h.keys.PrivateKey(id)
+	if err != nil {
+		if errors.Is(err, service.ErrNoSSHKey) {
+			http.Error(w, "build has no ephemeral ssh key (test VMs use the shared keypair)", http.StatusNotFound)
+			return
+		}
+		h.log.Error("failed to get ssh key", slog.Int64("id", id), slog.String("err", err.Error()))
+		http.Error(w, "ssh key unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	// Выдача ключа — событие для аудита: видно в логе сборки
+	user, _, _ := r.BasicAuth()
+	h.log.Warn("ssh private key downloaded", slog.Int64("id", id), slog.String("user", user), slog.String("remote", r.RemoteAddr))
+	_ = h.store.AppendLog(id, fmt.Sprintf("SSH private key downloaded by %s.", user))
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="build-%d.pem"`, id))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(key)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"image-manager/internal/service"
+)
+
+// Ожидание завершения сборки: по умолчанию и верхняя граница
+const (
+	defaultWaitTimeout = 30 * time.Minute
+	maxWaitTimeout     = 2 * time.Hour
+)
+
+// Период опроса БД (в тестах короче)
+var waitPollInterval = 2 * time.Second
+
+// WaitBuild блокируется, пока сборка не придет в конечный статус (или не истечет ?timeout=, например 45m).
+// 200 — сборка завершена; 408 — таймаут ожидания, сборка еще идет. Тело в обоих случаях одинаковое.
+// Предупреждение watchdog (ERROR_TIMEOUT) ожидание не прерывает: агент еще может ожить.
+func (h *Handler) WaitBuild(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	timeout := defaultWaitTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		timeout, err = time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			http.Error(w, "invalid timeout (expected duration, e.g. 30m)", http.StatusBadRequest)
+			return
+		}
+		timeout = min(timeout, maxWaitTimeout)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, _, err := h.store.GetBuildStatus(id)
+		if err != nil {
+			http.Error(w, "build not found", http.StatusNotFound)
+			return
+		}
+
+		if h.buildFinished(id, status) {
+			writeWaitResult(w, http.StatusOK, idStr, status, true)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return // Клиент ушел
+		case <-deadline.C:
+			writeWaitResult(w, http.StatusRequestTimeout, idStr, status, false)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// buildFinished — service.BuildFinished с тестовыми VM сборки из БД. Ошибка чтения — ждем дальше.
+func (h *Handler) buildFinished(id int64, status string) bool {
+	if status != "ERROR_TIMEOUT" {
+		return service.IsFinalStatus(status)
+	}
+	vms, err := h.store.GetTestVMs(id)
+	if err != nil {
+		return false
+	}
+	return service.BuildFinished(status, vms)
+}
+
+func writeWaitResult(w http.ResponseWriter, code int, id, status string, finished bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":       id,
+		"status":   status,
+		"finished": finished,
+		"success":  status == "SUCCESS" || status == "NO_CHANGE",
+	})
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"image-manager/internal/service"
+	"image-manager/internal/storage"
+)
+
+// waitBuild создает сборку с обязательной тестовой VM в статусе ERROR_TIMEOUT (предупреждение watchdog).
+func waitBuild(t *testing.T) (*Handler, int64, int64) {
+	t.Helper()
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	buildID, err := store.CreateBuild("Ubuntu-24", "ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmID, err := store.AddTestVM(buildID, "", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.UpdateTestVMStatus(vmID, "PENDING", "ERROR_TIMEOUT"); err != nil || !ok {
+		t.Fatalf("set ERROR_TIMEOUT: %v", err)
+	}
+	if err := store.UpdateBuildStatus(buildID, "ERROR_TIMEOUT"); err != nil {
+		t.Fatal(err)
+	}
+
+	old := waitPollInterval
+	waitPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { waitPollInterval = old })
+
+	return &Handler{log: slog.New(slog.NewTextHandler(io.Discard, nil)), store: store}, buildID, vmID
+}
+
+type waitResult struct {
+	code     int
+	status   string
+	finished bool
+}
+
+// startWait вызывает /wait в фоне и отдает его ответ в канал.
+func startWait(h *Handler, buildID int64) <-chan waitResult {
+	router := chi.NewRouter()
+	router.Get("/api/build/{id}/wait", h.WaitBuild)
+
+	done := make(chan waitResult, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/build/%d/wait?timeout=10s", buildID), nil))
+		var body struct {
+			Status   string `json:"status"`
+			Finished bool   `json:"finished"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		done <- waitResult{rec.Code, body.Status, body.Finished}
+	}()
+	return done
+}
+
+// Агент ожил после предупреждения watchdog и прошел: /wait дожидается SUCCESS.
+func TestWaitBuildSurvivesTimeoutWarning(t *testing.T) {
+	h, buildID, vmID := waitBuild(t)
+	done := startWait(h, buildID)
+
+	select {
+	case res := <-done:
+		t.Fatalf("wait returned on the watchdog warning: %+v", res)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	for _, step := range []struct{ from, to string }{
+		{"ERROR_TIMEOUT", "WAITING_AGENT"},
+		{"WAITING_AGENT", "SUCCESS"},
+	} {
+		if _, err := h.store.UpdateTestVMStatus(vmID, step.from, step.to); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.store.UpdateBuildStatus(buildID, step.to); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	select {
+	case res := <-done:
+		if res.code != http.StatusOK || res.status != "SUCCESS" || !res.finished {
+			t.Errorf("got %+v, want finished SUCCESS", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait did not return after SUCCESS")
+	}
+}
+
+// Watchdog удалил обязательную VM: ERROR_TIMEOUT окончательный, /wait возвращается сразу.
+func TestWaitBuildFinalTimeout(t *testing.T) {
+	h, buildID, vmID := waitBuild(t)
+	if _, err := h.store.UpdateTestVMStatus(vmID, "ERROR_TIMEOUT", service.StatusTerminated); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case res := <-startWait(h, buildID):
+		if res.code != http.StatusOK || res.status != "ERROR_TIMEOUT" || !res.finished {
+			t.Errorf("got %+v, want finished ERROR_TIMEOUT", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait did not return after the final timeout")
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"image-manager/internal/service"
+)
+
+// Таймауты watchdog считаются от последнего сигнала агента (heartbeat), а не от старта VM:
+// длинный прогон проверок с живым агентом не должен убиваться.
+const (
+	watchdogTick   = 15 * time.Second
+	agentWarnAfter = 3 * time.Minute  // Тишина, после которой VM помечается ERROR_TIMEOUT (VM жива)
+	agentKillAfter = 10 * time.Minute // Тишина, после которой VM удаляется
+	agentMaxWait   = 60 * time.Minute // Абсолютный предел даже при живых heartbeat (зависший агент)
+)
+
+// watchAgent следит за агентом тестовой VM, пока она ждет отчета. Статус сборки сводится
+// из статусов ее VM (см. Reporter.SetVMStatus).
+// activeAt — момент, когда VM стала ACTIVE: до первого heartbeat тишина отсчитывается от него.
+func (h *Handler) watchAgent(buildID int64, vmID string, activeAt time.Time) {
+	ticker := time.NewTicker(watchdogTick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		vm, err := h.store.GetTestVMByVMID(vmID)
+		if err != nil || (vm.Status != "WAITING_AGENT" && vm.Status != "ERROR_TIMEOUT" && vm.Status != service.StatusSoaking) {
+			return
+		}
+		prefix := service.LogPrefix(vm.Cell)
+
+		last, phase := activeAt, "none"
+		if hb := vm.Heartbeat; hb.LastAt.After(last) {
+			last, phase = hb.LastAt, hb.Phase
+		}
+		silence := time.Since(last)
+
+		switch {
+		case vm.Status == service.StatusSoaking:
+			// Окно наблюдения длиннее agentMaxWait; замолчавший агент — это зависшая VM (OOM,
+			// сеть), а не незапущенный агент: VM падает и остается для отладки
+			if silence >= agentWarnAfter {
+				h.log.Warn("WATCHDOG: Agent silent during soak", slog.Int64("id", buildID), slog.String("cell", vm.Cell))
+				if h.reporter.SetVMStatus(buildID, vm.ID, vm.Status, "ERROR_SOAK") {
+					_ = h.store.AppendLog(buildID, prefix+fmt.Sprintf("SOAK FAILED: agent silent for %s during the soak window. Keeping VM for debug.", silence.Round(time.Second)))
+					h.console.Capture(buildID, vm.Cell, vmID, "ERROR_SOAK")
+				}
+				return
+			}
+
+		case silence >= agentKillAfter || time.Since(activeAt) >= agentMaxWait:
+			h.log.Warn("WATCHDOG: Final timeout reached", slog.Int64("id", buildID), slog.String("cell", vm.Cell), slog.String("last_phase", phase))
+			if !h.reporter.SetVMStatus(buildID, vm.ID, vm.Status, service.StatusTerminated) {
+				continue // Отчет пришел между чтением статуса и его сменой
+			}
+			_ = h.store.AppendLog(buildID, prefix+fmt.Sprintf("FINAL TIMEOUT: no final report (last agent phase: %s, silent for %s). Terminating VM.", phase, silence.Round(time.Second)))
+			// Перезаписываем консоль: с момента предупреждения там могло появиться больше
+			h.console.Capture(buildID, vm.Cell, vmID, "ERROR_TIMEOUT")
+			_ = h.gc.DeleteServer(vmID)
+			return
+
+		case silence >= agentWarnAfter && vm.Status == "WAITING_AGENT":
+			// UI показывает красный статус, но VM живет: агента можно запустить руками
+			if h.reporter.SetVMStatus(buildID, vm.ID, vm.Status, "ERROR_TIMEOUT") {
+				_ = h.store.AppendLog(buildID, prefix+fmt.Sprintf("TIMEOUT: Agent silent for %s (last phase: %s). Please start agent manually: /usr/local/bin/agent. VM will be terminated after %s of silence.",
+					silence.Round(time.Second), phase, agentKillAfter))
+				h.console.Capture(buildID, vm.Cell, vmID, "ERROR_TIMEOUT")
+			}
+		}
+	}
+}
@@ -2,15 +2,20 @@ package grpc
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 
 	// Импортируем сгенерированный код и наши пакеты
-	"image-manager/internal/adapter/openstack"
-	"image-manager/internal/storage"
+	"image-manager/internal/service"
 	pb "image-manager/pkg/pb"
 )
 
@@ -19,30 +24,36 @@ type AgentServer struct {
 	pb.UnimplementedAgentServiceServer // Обязательная встройка
 
 	log      *slog.Logger
-	store    *storage.Storage
-	osClient *openstack.Client // Исправили опечатку (было ocClient)
+	reporter *service.Reporter // Вся логика обработки отчетов (общая с запасными каналами)
 }
 
 // NewAgentServer - конструктор
-// Добавили аргумент osc (OpenStack Client)
-func NewAgentServer(log *slog.Logger, store *storage.Storage, osc *openstack.Client) *AgentServer {
+func NewAgentServer(log *slog.Logger, reporter *service.Reporter) *AgentServer {
 	return &AgentServer{
 		log:      log,
-		store:    store,
-		osClient: osc,
+		reporter: reporter,
 	}
 }
 
-// Run запускает gRPC сервер на указанном порту (блокирующая функция)
-func (s *AgentServer) Run(port string) error {
-	s.log.Info("starting gRPC server", slog.String("port", port))
+// Run запускает gRPC сервер на указанном порту (блокирующая функция).
+// tlsCfg == nil — plaintext.
+func (s *AgentServer) Run(port string, tlsCfg *tls.Config) error {
+	s.log.Info("starting gRPC server",
+		slog.String("port", port),
+		slog.Bool("tls", tlsCfg != nil),
+		slog.Bool("mtls", tlsCfg != nil && tlsCfg.ClientCAs != nil),
+	)
 
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	grpcServer := grpc.NewServer()
+	var opts []grpc.ServerOption
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	grpcServer := grpc.NewServer(opts...)
 
 	// Регистрируем нашу реализацию сервера
 	pb.RegisterAgentServiceServer(grpcServer, s)
@@ -61,51 +72,88 @@ func (s *AgentServer) ReportStatus(ctx context.Context, req *pb.StatusRequest) (
 		slog.String("details", req.Details),
 	)
 
-	// По умолчанию говорим: "Жди"
-	command := "WAIT"
+	resp, err := s.reporter.Process(withClientIdentity(ctx), req)
+	return resp, grpcError(err)
+}
+
+// GetTestPlan отдает агенту план проверок его сборки.
+func (s *AgentServer) GetTestPlan(ctx context.Context, req *pb.TestPlanRequest) (*pb.TestPlan, error) {
+	s.log.Info("gRPC: test plan requested", slog.String("vm_id", req.VmId))
+
+	plan, err := s.reporter.TestPlan(withClientIdentity(ctx), req)
+	return plan, grpcError(err)
+}
+
+// Session — долгоживущий стрим агента. Каждое сообщение получает ответ с командой;
+// TESTS_DONE обрабатывается как обычный итоговый отчет.
+func (s *AgentServer) Session(stream pb.AgentService_SessionServer) error {
+	ctx := withClientIdentity(stream.Context())
 
-	if req.Success {
-		s.log.Info("Test PASSED. Promoting image...", slog.String("id", req.VmId))
-		
-		// 1. PROMOTE IMAGE
-		// Получаем данные о билде, чтобы знать ID кандидата и целевое имя
-		buildInfo, err := s.store.GetBuildInfoByVMID(req.VmId)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			s.log.Error("failed to get build info for promotion", slog.String("err", err.Error()))
+			return err
+		}
+
+		var resp *pb.StatusResponse
+		if service.IsFinalPhase(req.Phase) {
+			s.log.Info("gRPC: received report via session",
+				slog.String("vm_id", req.VmId),
+				slog.Bool("success", req.Success),
+				slog.String("details", req.Details),
+			)
+			resp, err = s.reporter.Process(ctx, req)
 		} else {
-			// Подменяем образ
-			if err := s.osClient.PromoteImage(buildInfo.GlanceID, buildInfo.ImageName); err != nil {
-				s.log.Error("CRITICAL: PROMOTION FAILED", slog.String("err", err.Error()))
-				// TODO: Возможно, стоит пометить статус как ERROR_PROMOTE?
-			} else {
-				s.log.Info("Image promoted to production", slog.String("name", buildInfo.ImageName))
-			}
+			s.log.Debug("gRPC: heartbeat", slog.String("vm_id", req.VmId), slog.String("phase", req.Phase))
+			resp, err = s.reporter.Heartbeat(ctx, req)
 		}
-		
-		// ОБНОВЛЯЕМ СТАТУС В БАЗЕ
-		if err := s.store.UpdateBuildStatusByVMID(req.VmId, "SUCCESS"); err != nil {
-			s.log.Error("failed to update db status", slog.String("err", err.Error()))
+		if err != nil {
+			return grpcError(err)
 		}
 
-		// Удаляем VM через наш клиент
-		if err := s.osClient.DeleteVM(req.VmId); err != nil {
-			s.log.Error("failed to delete vm", slog.String("err", err.Error()))
-		} else {
-			s.log.Info("VM deleted successfully")
+		if err := stream.Send(resp); err != nil {
+			return err
 		}
+	}
+}
+
+// UploadDiagnostics принимает архив диагностики кусками и отвечает итоговым подтверждением.
+func (s *AgentServer) UploadDiagnostics(stream pb.AgentService_UploadDiagnosticsServer) error {
+	ctx := withClientIdentity(stream.Context())
 
-		// Говорим агенту выключиться (он сделает самоуничтожение)
-		command = "SHUTDOWN"
-	} else {
-		s.log.Warn("Test FAILED. Keeping VM for debug.", slog.String("details", req.Details))
-		
-		// ОБНОВЛЯЕМ СТАТУС НА ОШИБКУ
-		_ = s.store.UpdateBuildStatusByVMID(req.VmId, "ERROR_TEST")
-		
-		// Не удаляем VM, чтобы админ мог зайти и посмотреть.
+	ack := &pb.DiagnosticsAck{}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(ack)
+		}
+		if err != nil {
+			return err
+		}
+
+		ack, err = s.reporter.ReceiveDiagnostics(ctx, chunk)
+		if err != nil {
+			return grpcError(err)
+		}
 	}
+}
 
-	return &pb.StatusResponse{
-		Command: command,
-	}, nil
+// grpcError переводит ошибки сервиса в коды gRPC.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidToken):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, service.ErrUnknownVM):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, service.ErrDiagnosticsOffset):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, service.ErrDiagnosticsTooLarge):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, service.ErrDiagnosticsNotRequested):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return err
 }
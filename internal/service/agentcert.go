@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Пути клиентского сертификата внутри тестовой VM (кладет cloud-init).
+const (
+	AgentCertPath = "/etc/image-manager-agent.crt"
+	AgentKeyPath  = "/etc/image-manager-agent.key"
+)
+
+// Префикс CN клиентского сертификата: "build-<id>".
+const agentCertCNPrefix = "build-"
+
+// CertIssuer выпускает короткоживущие клиентские сертификаты агентов (mTLS).
+type CertIssuer struct {
+	ca  *x509.Certificate
+	key any
+	ttl time.Duration
+}
+
+// NewCertIssuer загружает CA для подписи клиентских сертификатов.
+func NewCertIssuer(certFile, keyFile string, ttl time.Duration) (*CertIssuer, error) {
+	const op = "service.NewCertIssuer"
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !ca.IsCA {
+		return nil, fmt.Errorf("%s: certificate %s is not a CA", op, certFile)
+	}
+
+	return &CertIssuer{ca: ca, key: pair.PrivateKey, ttl: ttl}, nil
+}
+
+// Issue выпускает сертификат для агента сборки. Возвращает cert и key в PEM.
+func (c *CertIssuer) Issue(buildID int64) ([]byte, []byte, error) {
+	const op = "service.CertIssuer.Issue"
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: agentCertCNPrefix + strconv.FormatInt(buildID, 10)},
+		NotBefore:    now.Add(-5 * time.Minute), // Запас на расхождение часов VM
+		NotAfter:     now.Add(c.ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.ca, &key.PublicKey, c.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// BuildIDFromCert извлекает ID сборки из CN клиентского сертификата.
+func BuildIDFromCert(cert *x509.Certificate) (int64, bool) {
+	cn := cert.Subject.CommonName
+	if !strings.HasPrefix(cn, agentCertCNPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(cn, agentCertCNPrefix), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+type clientBuildKey struct{}
+
+// WithClientBuildID кладет в контекст ID сборки из проверенного клиентского сертификата.
+func WithClientBuildID(ctx context.Context, buildID int64) context.Context {
+	return context.WithValue(ctx, clientBuildKey{}, buildID)
+}
+
+func clientBuildID(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(clientBuildKey{}).(int64)
+	return id, ok
+}
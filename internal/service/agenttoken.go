@@ -0,0 +1,123 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AgentTokenPath — куда cloud-init кладет токен внутри тестовой VM.
+const AgentTokenPath = "/etc/image-manager-agent.token"
+
+// ErrInvalidToken — отчет без токена сборки или с чужим/отозванным токеном.
+var ErrInvalidToken = errors.New("invalid agent token")
+
+// IssueToken выпускает одноразовый токен агента для сборки.
+// В БД хранится только хеш; сам токен уходит в VM через user data.
+func (r *Reporter) IssueToken(buildID int64) (string, error) {
+	const op = "service.Reporter.IssueToken"
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	token := hex.EncodeToString(raw)
+
+	if err := r.store.SetAgentTokenHash(buildID, hashToken(token)); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	return token, nil
+}
+
+// verifyToken сверяет токен из отчета с токеном сборки.
+func (r *Reporter) verifyToken(buildID int64, token string) error {
+	if token == "" {
+		return ErrInvalidToken
+	}
+
+	expected, err := r.store.GetAgentTokenHash(buildID)
+	if err != nil {
+		return err
+	}
+	if expected == "" {
+		return ErrInvalidToken
+	}
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(hashToken(token))) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// reportKey — ключ подписи отчетов сборки через серийную консоль (serialreport.TokenKey).
+// Совпадает с хешем токена, поэтому сам токен менеджеру не нужен.
+func (r *Reporter) reportKey(buildID int64) ([]byte, error) {
+	stored, err := r.store.GetAgentTokenHash(buildID)
+	if err != nil {
+		return nil, err
+	}
+	if stored == "" {
+		return nil, ErrInvalidToken
+	}
+	return hex.DecodeString(stored)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AgentCredentials — то, что получает агент конкретной сборки.
+type AgentCredentials struct {
+	Token   string
+	CertPEM []byte // Пусто, если mTLS выключен
+	KeyPEM  []byte
+}
+
+// IssueCredentials выпускает токен и (если включен mTLS) клиентский сертификат для сборки.
+func (r *Reporter) IssueCredentials(buildID int64) (AgentCredentials, error) {
+	token, err := r.IssueToken(buildID)
+	if err != nil {
+		return AgentCredentials{}, err
+	}
+	creds := AgentCredentials{Token: token}
+
+	if r.certs != nil {
+		creds.CertPEM, creds.KeyPEM, err = r.certs.Issue(buildID)
+		if err != nil {
+			return AgentCredentials{}, err
+		}
+	}
+	return creds, nil
+}
+
+type cloudConfigFile struct {
+	Path        string `yaml:"path"`
+	Owner       string `yaml:"owner"`
+	Permissions string `yaml:"permissions"`
+	Content     string `yaml:"content"`
+}
+
+// AgentUserData — cloud-config для тестовой VM: кладет токен (и сертификат агента) в /etc.
+func AgentUserData(creds AgentCredentials) (string, error) {
+	files := []cloudConfigFile{
+		{Path: AgentTokenPath, Owner: "root:root", Permissions: "0600", Content: creds.Token},
+	}
+	if len(creds.CertPEM) > 0 {
+		files = append(files,
+			cloudConfigFile{Path: AgentCertPath, Owner: "root:root", Permissions: "0644", Content: string(creds.CertPEM)},
+			cloudConfigFile{Path: AgentKeyPath, Owner: "root:root", Permissions: "0600", Content: string(creds.KeyPEM)},
+		)
+	}
+
+	data, err := yaml.Marshal(map[string]any{"write_files": files})
+	if err != nil {
+		return "", fmt.Errorf("service.AgentUserData: %w", err)
+	}
+	return "#cloud-config\n" + string(data), nil
+}
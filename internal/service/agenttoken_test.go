@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"image-manager/internal/storage"
+	pb "image-manager/pkg/pb"
+)
+
+// newTestVM добавляет сборке тестовую VM с сервером vmID.
+func newTestVM(t *testing.T, r *Reporter, buildID int64, vmID string) int64 {
+	t.Helper()
+	id, err := r.store.AddTestVM(buildID, "", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.store.SetTestVMServer(id, vmID); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestVerifyToken(t *testing.T) {
+	r := newTestReporter(t)
+	buildID := newBuild(t, r.store, "WAITING_AGENT")
+	other := newBuild(t, r.store, "WAITING_AGENT")
+
+	token, err := r.IssueToken(buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := r.IssueToken(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == otherToken || len(token) != 64 {
+		t.Fatalf("tokens: %q, %q", token, otherToken)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", token, true},
+		{"missing", "", false},
+		{"wrong", strings.Repeat("0", 64), false},
+		{"token of another build", otherToken, false},
+		{"hash instead of token", hashToken(token), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.verifyToken(buildID, tt.token)
+			if tt.ok && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+
+	// Перевыпуск заменяет токен: старый больше не действует
+	reissued, err := r.IssueToken(buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.verifyToken(buildID, token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("old token after reissue: got %v, want ErrInvalidToken", err)
+	}
+
+	// После promote токен отозван
+	if err := r.store.RevokeAgentToken(buildID); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.verifyToken(buildID, reissued); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("revoked token: got %v, want ErrInvalidToken", err)
+	}
+	if err := r.verifyToken(other, otherToken); err != nil {
+		t.Errorf("revoke touched another build: %v", err)
+	}
+}
+
+func TestVerifyTokenNeverIssued(t *testing.T) {
+	r := newTestReporter(t)
+	buildID := newBuild(t, r.store, "WAITING_AGENT")
+
+	// Пустой хеш в БД не должен совпасть с пустым токеном
+	if err := r.verifyToken(buildID, ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v, want ErrInvalidToken", err)
+	}
+	if err := r.verifyToken(buildID, "anything"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	r := newTestReporter(t)
+	buildID := newBuild(t, r.store, "WAITING_AGENT")
+	newTestVM(t, r, buildID, "vm-1")
+	token, err := r.IssueToken(buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		mtls  bool // Менеджер выпускает клиентские сертификаты
+		ctx   context.Context
+		vmID  string
+		token string
+		want  error
+	}{
+		{"valid", false, context.Background(), "vm-1", token, nil},
+		{"unknown vm", false, context.Background(), "vm-unknown", token, ErrUnknownVM},
+		{"missing token", false, context.Background(), "vm-1", "", ErrInvalidToken},
+		{"wrong token", false, context.Background(), "vm-1", "wrong", ErrInvalidToken},
+		{"client certificate of the build", false, WithClientBuildID(context.Background(), buildID), "vm-1", token, nil},
+		{"client certificate of another build", false, WithClientBuildID(context.Background(), buildID+1), "vm-1", token, ErrInvalidToken},
+		{"mTLS on, client certificate of the build", true, WithClientBuildID(context.Background(), buildID), "vm-1", token, nil},
+		{"mTLS on, no cert", true, context.Background(), "vm-1", token, ErrInvalidToken},
+		{"mTLS on, client certificate of another build", true, WithClientBuildID(context.Background(), buildID+1), "vm-1", token, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.certs = nil
+			if tt.mtls {
+				r.certs = &CertIssuer{}
+			}
+			info, err := r.authenticate(tt.ctx, tt.vmID, tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if err == nil && info.ID != buildID {
+				t.Errorf("got build %d, want %d", info.ID, buildID)
+			}
+		})
+	}
+}
+
+func TestAgentUserData(t *testing.T) {
+	data, err := AgentUserData(AgentCredentials{Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(data, "#cloud-config\n") {
+		t.Fatalf("not a cloud-config: %q", data)
+	}
+
+	var cfg struct {
+		WriteFiles []cloudConfigFile `yaml:"write_files"`
+	}
+	if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.WriteFiles) != 1 {
+		t.Fatalf("got %d files, want 1", len(cfg.WriteFiles))
+	}
+	f := cfg.WriteFiles[0]
+	if f.Path != AgentTokenPath || f.Content != "secret" || f.Permissions != "0600" {
+		t.Errorf("token file: %+v", f)
+	}
+}
+
+// Токен одноразовый: отзывается, когда исход сборки решен и архивов диагностики не ждем.
+func TestTokenRevokedOnFinish(t *testing.T) {
+	tests := []struct {
+		name     string
+		vm       string // Статус VM до перехода
+		build    string
+		to       string
+		diag     bool // Упавшая VM ждет архив диагностики
+		finished bool // После перехода токен отозван
+	}{
+		{"ERROR_TEST waits for diagnostics", "WAITING_AGENT", "WAITING_AGENT", "ERROR_TEST", true, false},
+		{"ERROR_TEST without diagnostics", "WAITING_AGENT", "WAITING_AGENT", "ERROR_TEST", false, true},
+		{"ERROR_SOAK by watchdog", StatusSoaking, StatusSoaking, "ERROR_SOAK", false, true},
+		{"ERROR_VM_BOOT", "BOOTING_VM", "BOOTING_VM", "ERROR_VM_BOOT", false, true},
+		{"timeout warning", "WAITING_AGENT", "WAITING_AGENT", "ERROR_TIMEOUT", false, false},
+		{"final timeout", "ERROR_TIMEOUT", "ERROR_TIMEOUT", StatusTerminated, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReporter(t)
+			buildID, ids := matrixBuild(t, r, tt.build, storage.TestVM{Required: true, Status: tt.vm})
+			token, err := r.IssueToken(buildID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Как в Process: архив ждем раньше, чем выполнятся отложенные вызовы settle
+			var later followUp
+			if !r.setVMStatus(buildID, ids[0], tt.vm, tt.to, &later) {
+				t.Fatal("transition rejected")
+			}
+			if tt.diag && !r.expectDiagnostics(ids[0]) {
+				t.Fatal("failed vm does not expect diagnostics")
+			}
+			later.run()
+
+			err = r.verifyToken(buildID, token)
+			if tt.finished && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got %v, want revoked token", err)
+			}
+			if !tt.finished && err != nil {
+				t.Errorf("token revoked too early: %v", err)
+			}
+		})
+	}
+}
+
+func TestTokenRevokedAfterDiagnostics(t *testing.T) {
+	r := newTestReporter(t)
+	buildID, id, token := failedVM(t, r, "vm-1")
+	_, other, otherToken := failedVM(t, r, "vm-2")
+	for _, vm := range []int64{id, other} {
+		if !r.expectDiagnostics(vm) {
+			t.Fatal("failed vm does not expect diagnostics")
+		}
+	}
+
+	// Архив получен — токен больше не нужен
+	chunk := &pb.DiagnosticsChunk{VmId: "vm-1", Token: token, Data: []byte("bundle"), Last: true}
+	if _, err := r.ReceiveDiagnostics(context.Background(), chunk); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.verifyToken(buildID, token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("after diagnostics: got %v, want revoked token", err)
+	}
+
+	// Агент так и не залил архив, GC удалил VM — токен тоже отзывается
+	if err := r.verifyToken(other, otherToken); err != nil {
+		t.Fatalf("token revoked before the vm was removed: %v", err)
+	}
+	if err := r.store.AddResource(other, ResourceServer, "vm-2", "test-vm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.store.MarkResourceDeleted(ResourceServer, "vm-2"); err != nil {
+		t.Fatal(err)
+	}
+	r.gc.ReleaseToken(other)
+	if err := r.verifyToken(other, otherToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("after gc: got %v, want revoked token", err)
+	}
+}
+
+// Упала обязательная ячейка: остальные отменены, токен отозван.
+func TestTokenRevokedOnCancel(t *testing.T) {
+	r := newTestReporter(t)
+	buildID, ids := matrixBuild(t, r, "WAITING_AGENT",
+		storage.TestVM{Cell: "bios", Required: true, Status: "BOOTING_VM"},
+		storage.TestVM{Cell: "uefi", Required: true, Status: "WAITING_AGENT"},
+	)
+	token, err := r.IssueToken(buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetVMStatus(buildID, ids[0], "BOOTING_VM", "ERROR_VM_BOOT")
+	if got := vmStatus(t, r, ids[1]); got != StatusCancelled {
+		t.Fatalf("second vm: %s, want %s", got, StatusCancelled)
+	}
+	if err := r.verifyToken(buildID, token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v, want revoked token", err)
+	}
+}
@@ -48,6 +48,32 @@ func (b *Builder) ensureScriptsExecutable() error {
 	})
 }
 
+// imageEnv — переменные окружения DIB из конфига менеджера, которые попадают в образ
+// (адрес менеджера, CA, транспорт агента, SSH-ключ). Участвуют и в отпечатке сборки.
+func (b *Builder) imageEnv() []string {
+	var env []string
+	if b.cfg.GRPCServer.PublicAddress != "" {
+		env = append(env, "MANAGER_ADDRESS="+b.cfg.GRPCServer.PublicAddress)
+	}
+
+	// CA серверного сертификата gRPC: элемент agent-install кладет его в образ
+	if b.cfg.GRPCServer.TLSCABundle != "" {
+		caPath, _ := filepath.Abs(b.cfg.GRPCServer.TLSCABundle)
+		env = append(env, "MANAGER_CA_BUNDLE="+caPath)
+	}
+
+	// REST-канал для сред, где ingress не пропускает HTTP/2 (gRPC)
+	if b.cfg.HTTPServer.PublicURL != "" {
+		env = append(env, "MANAGER_HTTP_URL="+b.cfg.HTTPServer.PublicURL)
+	}
+	env = append(env, "AGENT_TRANSPORT="+b.cfg.Agent.Transport)
+
+	if b.cfg.OpenStack.SSHInjectKey != "" {
+		env = append(env, "SSH_INJECT_KEY="+b.cfg.OpenStack.SSHInjectKey)
+	}
+	return env
+}
+
 // BuildImage запускает реальный процесс сборки.
 func (b *Builder) BuildImage(imageName string, distro string, logWriter io.Writer) error {
 	const op = "service.Builder.BuildImage"
@@ -67,12 +93,8 @@ func (b *Builder) BuildImage(imageName string, distro string, logWriter io.Write
 	)
     
     // --- ЗАГРУЗКА КОНФИГА ОС ---
-    configName := distro
-    if distro == "debian" { configName = "debian-12" }
-    if distro == "ubuntu" { configName = "ubuntu-24" }
-
     // Используем loadErr, чтобы избежать конфликтов имен
-    distroCfg, loadErr := config.LoadDistroConfig(configName)
+    distroCfg, loadErr := config.LoadDistroConfig(distro)
     if loadErr != nil {
         return fmt.Errorf("%s: unknown distro '%s' (config load failed): %w", op, distro, loadErr)
     }
@@ -86,6 +108,17 @@ func (b *Builder) BuildImage(imageName string, distro string, logWriter io.Write
     
     elements = append(elements, distroCfg.Elements...)
 
+	// Drop-in проверки агента: элемент agent-checks копирует их в /etc/image-agent/checks.d
+	if len(distroCfg.AgentChecks) > 0 {
+		elements = append(elements, "agent-checks")
+		paths := make([]string, 0, len(distroCfg.AgentChecks))
+		for _, check := range distroCfg.AgentChecks {
+			abs, _ := filepath.Abs(filepath.Join(config.AgentChecksDir, check))
+			paths = append(paths, abs)
+		}
+		extraEnv = append(extraEnv, "AGENT_CHECKS="+strings.Join(paths, " "))
+	}
+
     for k, v := range distroCfg.Env {
         extraEnv = append(extraEnv, fmt.Sprintf("%s=%s", k, v))
     }
@@ -106,15 +139,20 @@ func (b *Builder) BuildImage(imageName string, distro string, logWriter io.Write
 	cmd.Env = append(cmd.Env, "ELEMENTS_PATH="+localElementsPath)
 	cmd.Env = append(cmd.Env, "DIB_CLOUD_INIT_DATASOURCES=OpenStack,ConfigDrive,None")
 	
-	if b.cfg.GRPCServer.PublicAddress != "" {
-		cmd.Env = append(cmd.Env, "MANAGER_ADDRESS="+b.cfg.GRPCServer.PublicAddress)
-	} else {
+	if b.cfg.GRPCServer.PublicAddress == "" {
 		b.log.Warn("GRPC_PUBLIC_ADDRESS is empty! Agent might not connect back.")
 	}
-
-    if b.cfg.OpenStack.SSHInjectKey != "" {
-        cmd.Env = append(cmd.Env, "SSH_INJECT_KEY="+b.cfg.OpenStack.SSHInjectKey)
-    }
+	cmd.Env = append(cmd.Env, b.imageEnv()...)
+
+	// Агент собирается из исходников элементом agent-install (extra-data.d/40-build-agent).
+	// Без исходников или Go (Docker-образ менеджера) ставится готовый elements/agent-install/agent
+	if _, err := os.Stat(filepath.Join(wd, "cmd", "agent")); err == nil {
+		if _, err := exec.LookPath("go"); err == nil {
+			cmd.Env = append(cmd.Env, "AGENT_SOURCE_DIR="+wd)
+		} else {
+			b.log.Warn("go toolchain not found, using the prebuilt agent binary")
+		}
+	}
 
 	cmd.Env = append(cmd.Env, extraEnv...)
 
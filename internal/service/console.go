@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/storage"
+)
+
+// ConsoleArtifact — имя артефакта с выводом серийной консоли тестовой VM.
+const ConsoleArtifact = "console.log"
+
+// ConsoleCollector сохраняет консоль тестовой VM (Nova console output) в артефакты сборки.
+// Образы собираются с enable-serial-console и journal-to-console, так что там виден весь boot и journald.
+type ConsoleCollector struct {
+	log       *slog.Logger
+	store     *storage.Storage
+	osClient  *openstack.Client
+	onSuccess bool
+}
+
+func NewConsoleCollector(log *slog.Logger, store *storage.Storage, osc *openstack.Client, onSuccess bool) *ConsoleCollector {
+	return &ConsoleCollector{
+		log:       log,
+		store:     store,
+		osClient:  osc,
+		onSuccess: onSuccess,
+	}
+}
+
+// Capture забирает консоль VM ячейки cell и сохраняет ее в артефакт сборки.
+// reason попадает в лог сборки ("ERROR_TIMEOUT", "ERROR_VM_BOOT" и т.д.).
+// Вызывать до удаления VM: после удаления Nova консоль уже не отдаст.
+func (c *ConsoleCollector) Capture(buildID int64, cell, vmID, reason string) {
+	prefix, artifact := LogPrefix(cell), CellArtifact(cell, ConsoleArtifact)
+
+	out, err := c.osClient.GetConsoleOutput(vmID, 0)
+	if err != nil {
+		c.log.Warn("failed to capture console output", slog.String("vm_id", vmID), slog.String("err", err.Error()))
+		_ = c.store.AppendLog(buildID, prefix+fmt.Sprintf("Console log capture failed (%s): %s", reason, err.Error()))
+		return
+	}
+
+	if err := c.store.SaveArtifact(buildID, artifact, "text/plain; charset=utf-8", []byte(out)); err != nil {
+		c.log.Error("failed to save console artifact", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+		return
+	}
+	_ = c.store.AppendLog(buildID, prefix+fmt.Sprintf("Console log captured (%s, %d bytes). See artifact %s.", reason, len(out), artifact))
+}
+
+// CaptureOnSuccess сохраняет консоль успешной сборки, если это включено в конфиге.
+func (c *ConsoleCollector) CaptureOnSuccess(buildID int64, cell, vmID string) {
+	if c.onSuccess {
+		c.Capture(buildID, cell, vmID, "SUCCESS")
+	}
+}
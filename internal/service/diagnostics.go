@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"image-manager/pkg/pb"
+)
+
+// CommandCollectDiagnostics — команда агенту собрать диагностику и залить ее через UploadDiagnostics.
+const CommandCollectDiagnostics = "COLLECT_DIAGNOSTICS"
+
+// DiagnosticsArtifact — имя артефакта сборки с архивом диагностики.
+const DiagnosticsArtifact = "diagnostics.tar.gz"
+
+// DiagnosticsCollectors — что просим собрать. Произвольных команд нет: агент знает только эти имена.
+var DiagnosticsCollectors = []string{
+	"journal", "dmesg", "cloud_init", "network", "df", "failed_units", "packages",
+}
+
+// Предел архива: он целиком держится в памяти до последнего куска и ложится в SQLite
+const maxDiagnosticsSize = 32 << 20
+
+var (
+	ErrDiagnosticsOffset   = errors.New("unexpected diagnostics chunk offset")
+	ErrDiagnosticsTooLarge = errors.New("diagnostics bundle too large")
+	// Архив принимается только после команды COLLECT_DIAGNOSTICS и пока VM ждет его
+	ErrDiagnosticsNotRequested = errors.New("diagnostics were not requested")
+)
+
+func diagnosticsResponse() *pb.StatusResponse {
+	return &pb.StatusResponse{Command: CommandCollectDiagnostics, Collect: DiagnosticsCollectors}
+}
+
+// expectDiagnostics отмечает, что агенту упавшей VM отдается COLLECT_DIAGNOSTICS: с этого
+// момента ReceiveDiagnostics принимает от него архив. false — VM уже не упавшая (отменена),
+// команду отдавать не нужно.
+func (r *Reporter) expectDiagnostics(testVMID int64) bool {
+	ok, err := r.store.ExpectDiagnostics(testVMID)
+	if err != nil {
+		r.log.Error("failed to mark diagnostics request", slog.Int64("test_vm_id", testVMID), slog.String("err", err.Error()))
+		return false
+	}
+	return ok
+}
+
+// ReceiveDiagnostics принимает кусок архива диагностики. Куски идут строго по порядку;
+// кусок с offset 0 начинает загрузку заново (ретрай агента после обрыва). Архив принимается
+// только от VM, которой отдана команда COLLECT_DIAGNOSTICS и которая все еще ждет его.
+func (r *Reporter) ReceiveDiagnostics(ctx context.Context, chunk *pb.DiagnosticsChunk) (*pb.DiagnosticsAck, error) {
+	const op = "service.Reporter.ReceiveDiagnostics"
+
+	buildInfo, err := r.authenticate(ctx, chunk.VmId, chunk.Token)
+	if err != nil {
+		return nil, err
+	}
+	id := buildInfo.ID
+	vm, err := r.store.GetTestVMByVMID(chunk.VmId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	// Архивы упавших VM одной сборки грузятся независимо
+	key := vm.ID
+	r.pruneUploads(key)
+	defer r.lockVM(key)()
+
+	expected, err := r.store.DiagnosticsExpected(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !expected {
+		r.dropUpload(key)
+		return nil, fmt.Errorf("%w: vm %s", ErrDiagnosticsNotRequested, chunk.VmId)
+	}
+
+	if chunk.Offset == 0 {
+		r.setUpload(key, nil)
+	}
+	buf := r.upload(key)
+	if chunk.Offset != int64(len(buf)) {
+		return &pb.DiagnosticsAck{Received: int64(len(buf))}, fmt.Errorf("%w: got %d, want %d", ErrDiagnosticsOffset, chunk.Offset, len(buf))
+	}
+	if len(buf)+len(chunk.Data) > maxDiagnosticsSize {
+		r.dropUpload(key)
+		return nil, fmt.Errorf("%w: limit %d bytes", ErrDiagnosticsTooLarge, maxDiagnosticsSize)
+	}
+	buf = append(buf, chunk.Data...)
+	r.setUpload(key, buf)
+
+	if !chunk.Last {
+		return &pb.DiagnosticsAck{Received: int64(len(buf))}, nil
+	}
+
+	r.dropUpload(key)
+	artifact := CellArtifact(vm.Cell, DiagnosticsArtifact)
+	if err := r.store.SaveArtifact(id, artifact, "application/gzip", buf); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	// Архив получен: если сборка завершена и других архивов не ждем, токен больше не нужен
+	if err := r.store.ClearDiagnosticsExpected(key); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	r.gc.ReleaseToken(id)
+
+	r.log.Info("diagnostics bundle received", slog.Int64("build_id", id), slog.Int("bytes", len(buf)))
+	_ = r.store.AppendLog(id, LogPrefix(vm.Cell)+fmt.Sprintf("Diagnostics bundle received from agent (%d KB): artifact %s.", len(buf)/1024, artifact))
+
+	return &pb.DiagnosticsAck{Received: int64(len(buf)), Complete: true}, nil
+}
+
+// upload, setUpload и dropUpload работают с буфером загрузки тестовой VM. Порядок кусков
+// одной VM держит lockVM, r.mu защищает только саму карту.
+func (r *Reporter) upload(testVMID int64) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.uploads[testVMID]
+}
+
+func (r *Reporter) setUpload(testVMID int64, buf []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.uploads[testVMID] = buf
+}
+
+func (r *Reporter) dropUpload(testVMID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.uploads, testVMID)
+}
+
+// pruneUploads выбрасывает буферы других VM, которые больше не ждут архив: агент оборвал
+// загрузку, а VM с тех пор отменили или удалили ее сервер (GC), и дозаливки уже не будет.
+func (r *Reporter) pruneUploads(except int64) {
+	r.mu.Lock()
+	ids := make([]int64, 0, len(r.uploads))
+	for id := range r.uploads {
+		if id != except {
+			ids = append(ids, id)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, id := range ids {
+		if expected, err := r.store.DiagnosticsExpected(id); err == nil && !expected {
+			r.dropUpload(id)
+		}
+	}
+}
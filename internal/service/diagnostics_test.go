@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pb "image-manager/pkg/pb"
+)
+
+// failedVM создает сборку с упавшей тестовой VM vmID и выдает токен ее агенту.
+func failedVM(t *testing.T, r *Reporter, vmID string) (int64, int64, string) {
+	t.Helper()
+	buildID := newBuild(t, r.store, "ERROR_TEST")
+	id := newTestVM(t, r, buildID, vmID)
+	if ok, err := r.store.UpdateTestVMStatus(id, "PENDING", "ERROR_TEST"); err != nil || !ok {
+		t.Fatalf("set ERROR_TEST: %v", err)
+	}
+	token, err := r.IssueToken(buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buildID, id, token
+}
+
+func (r *Reporter) hasUpload(testVMID int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.uploads[testVMID]
+	return ok
+}
+
+func TestReceiveDiagnosticsRequiresCommand(t *testing.T) {
+	r := newTestReporter(t)
+	buildID, id, token := failedVM(t, r, "vm-1")
+	chunk := &pb.DiagnosticsChunk{VmId: "vm-1", Token: token, Data: []byte("part"), Last: true}
+
+	// Команды COLLECT_DIAGNOSTICS не было — архив не принимается
+	if _, err := r.ReceiveDiagnostics(context.Background(), chunk); !errors.Is(err, ErrDiagnosticsNotRequested) {
+		t.Fatalf("got %v, want ErrDiagnosticsNotRequested", err)
+	}
+
+	if !r.expectDiagnostics(id) {
+		t.Fatal("failed vm does not expect diagnostics")
+	}
+	ack, err := r.ReceiveDiagnostics(context.Background(), chunk)
+	if err != nil || !ack.Complete {
+		t.Fatalf("ack %v, err %v", ack, err)
+	}
+	if _, err := r.store.GetArtifact(buildID, DiagnosticsArtifact); err != nil {
+		t.Errorf("artifact not saved: %v", err)
+	}
+
+	// Архив получен, сборка завершена: токен отозван, повторный архив не принимается
+	if _, err := r.ReceiveDiagnostics(context.Background(), chunk); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("second bundle: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestReceiveDiagnosticsAfterVMLeft(t *testing.T) {
+	r := newTestReporter(t)
+	buildID, id, token := failedVM(t, r, "vm-1")
+	if !r.expectDiagnostics(id) {
+		t.Fatal("failed vm does not expect diagnostics")
+	}
+
+	chunk := &pb.DiagnosticsChunk{VmId: "vm-1", Token: token, Data: []byte("part")}
+	if _, err := r.ReceiveDiagnostics(context.Background(), chunk); err != nil {
+		t.Fatal(err)
+	}
+	if !r.hasUpload(id) {
+		t.Fatal("no upload buffer after the first chunk")
+	}
+
+	// VM ушла из ERROR_TEST посреди загрузки: буфер выброшен, дозаливка отвергнута
+	if !r.SetVMStatus(buildID, id, "ERROR_TEST", StatusTerminated) {
+		t.Fatal("transition rejected")
+	}
+	if r.hasUpload(id) {
+		t.Error("upload buffer kept after the vm left ERROR_TEST")
+	}
+	chunk = &pb.DiagnosticsChunk{VmId: "vm-1", Token: token, Offset: 4, Data: []byte("rest"), Last: true}
+	if _, err := r.ReceiveDiagnostics(context.Background(), chunk); !errors.Is(err, ErrDiagnosticsNotRequested) {
+		t.Errorf("got %v, want ErrDiagnosticsNotRequested", err)
+	}
+	if r.expectDiagnostics(id) {
+		t.Error("terminated vm accepted COLLECT_DIAGNOSTICS")
+	}
+}
+
+// Сервер упавшей VM удалил GC посреди загрузки: буфер выбрасывается при следующей загрузке.
+func TestPruneUploadsOfDeletedServers(t *testing.T) {
+	r := newTestReporter(t)
+	buildID, id, token := failedVM(t, r, "vm-1")
+	_, other, otherToken := failedVM(t, r, "vm-2")
+	for _, vm := range []int64{id, other} {
+		if !r.expectDiagnostics(vm) {
+			t.Fatal("failed vm does not expect diagnostics")
+		}
+	}
+
+	if _, err := r.ReceiveDiagnostics(context.Background(), &pb.DiagnosticsChunk{VmId: "vm-1", Token: token, Data: []byte("part")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.store.AddResource(buildID, ResourceServer, "vm-1", "test-vm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.store.MarkResourceDeleted(ResourceServer, "vm-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.ReceiveDiagnostics(context.Background(), &pb.DiagnosticsChunk{VmId: "vm-2", Token: otherToken, Data: []byte("part")}); err != nil {
+		t.Fatal(err)
+	}
+	if r.hasUpload(id) {
+		t.Error("upload buffer of the deleted server kept")
+	}
+	if !r.hasUpload(other) {
+		t.Error("upload buffer of the live vm dropped")
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/config"
+	"image-manager/internal/storage"
+	pb "image-manager/pkg/pb"
+)
+
+// Гость видит меньше RAM флейвора (резерв ядра, crashkernel) — допускаем до 15%
+const memoryMinRatio = 0.85
+
+// Размер диска сверяем с точностью до 1%
+const diskSizeTolerance = 0.01
+
+// serverFlavor — флейвор тестовой VM для сверки фактов (nil — агент без фактов или Nova не ответила).
+// Запрос в Nova, поэтому вызывается до блокировки VM.
+func (r *Reporter) serverFlavor(req *pb.StatusRequest) *openstack.Flavor {
+	if req.Facts == nil {
+		return nil
+	}
+	flavor, err := r.osClient.GetServerFlavor(req.VmId)
+	if err != nil {
+		r.log.Warn("facts: failed to get flavor", slog.String("vm_id", req.VmId), slog.String("err", err.Error()))
+		return nil
+	}
+	return flavor
+}
+
+// applyFacts сохраняет факты из итогового отчета и сверяет их с флейвором тестовой VM (nil — неизвестен)
+// и секцией facts конфига дистрибутива. Результаты сверки добавляются к проверкам отчета,
+// расхождение делает отчет неуспешным.
+func (r *Reporter) applyFacts(buildInfo *storage.BuildInfo, vm *storage.TestVM, req *pb.StatusRequest, flavor *openstack.Flavor) {
+	facts := req.Facts
+	if facts == nil {
+		return // Агент старой версии
+	}
+
+	var expect config.FactExpectations
+	if buildInfo.Distro != "" {
+		if distroCfg, err := config.LoadDistroConfig(buildInfo.Distro); err == nil {
+			expect = distroCfg.Facts
+		} else {
+			r.log.Warn("facts: failed to load distro config", slog.String("distro", buildInfo.Distro), slog.String("err", err.Error()))
+		}
+	}
+
+	details, _ := protojson.MarshalOptions{UseProtoNames: true}.Marshal(facts)
+	saved := storage.BuildFacts{
+		VCPUs:             int(facts.Vcpus),
+		MemoryMB:          facts.MemoryMb,
+		Kernel:            facts.Kernel,
+		Firmware:          facts.Firmware,
+		BootSeconds:       facts.BootSeconds,
+		AgentStartSeconds: facts.AgentStartSeconds,
+		Details:           details,
+	}
+	if flavor != nil {
+		saved.Flavor = flavor.Name
+	}
+	// Тренд времени загрузки — по первой загрузке: перезагрузка (reboot_test) обычно быстрее.
+	// У матрицы — по первой ячейке, чтобы флейворы разных ячеек не смешивались в одном тренде
+	if req.Boot < finalBoot && r.primaryVM(vm) {
+		if err := r.store.SaveFacts(buildInfo.ID, saved); err != nil {
+			r.log.Error("failed to save facts", slog.Int64("build_id", buildInfo.ID), slog.String("err", err.Error()))
+		}
+	}
+
+	results := compareFacts(facts, flavor, bootVolumeGB(vm), expect)
+	var mismatched []string
+	for _, res := range results {
+		if res.Status == CheckFail {
+			mismatched = append(mismatched, res.Name)
+		}
+	}
+	if len(mismatched) > 0 {
+		req.Success = false
+		req.Details = strings.TrimPrefix(req.Details+"; facts mismatch: "+strings.Join(mismatched, ", "), "; ")
+	}
+	req.Results = append(req.Results, results...)
+}
+
+// bootVolumeGB — размер загрузочного тома тестовой VM из ее параметров (0 — загрузка с диска флейвора).
+func bootVolumeGB(vm *storage.TestVM) int {
+	var opts openstack.VMOptions
+	if vm == nil || json.Unmarshal(vm.Options, &opts) != nil {
+		return 0
+	}
+	return opts.BootVolumeGB
+}
+
+// compareFacts сверяет факты гостя с флейвором (nil — неизвестен) и ожиданиями дистрибутива.
+// volumeGB > 0 — VM загружена с тома: корневой диск сверяется с размером тома, а не с диском флейвора.
+func compareFacts(f *pb.HostFacts, flavor *openstack.Flavor, volumeGB int, expect config.FactExpectations) []*pb.CheckResult {
+	var results []*pb.CheckResult
+	add := func(name, status, expected, actual, message string) {
+		results = append(results, &pb.CheckResult{
+			Name: "facts:" + name, Status: status, Expected: expected, Actual: actual, Message: message,
+		})
+	}
+
+	if flavor == nil {
+		add("flavor", CheckSkip, "", "", "flavor of the test VM is unknown")
+	} else {
+		expected := fmt.Sprintf("%d vCPU (flavor %s)", flavor.VCPUs, flavor.Name)
+		actual := fmt.Sprintf("%d vCPU", f.Vcpus)
+		if int(f.Vcpus) == flavor.VCPUs {
+			add("vcpus", CheckPass, expected, actual, "")
+		} else {
+			add("vcpus", CheckFail, expected, actual, "guest sees a different vCPU count than the flavor")
+		}
+
+		minMB := int64(float64(flavor.RAMMB) * memoryMinRatio)
+		expected = fmt.Sprintf("%d-%d MB (flavor %d MB)", minMB, flavor.RAMMB, flavor.RAMMB)
+		actual = fmt.Sprintf("%d MB", f.MemoryMb)
+		if f.MemoryMb >= minMB && f.MemoryMb <= int64(flavor.RAMMB) {
+			add("memory", CheckPass, expected, actual, "")
+		} else {
+			add("memory", CheckFail, expected, actual, "guest memory does not match the flavor")
+		}
+
+		diskGB, source := flavor.DiskGB, "flavor"
+		if volumeGB > 0 {
+			diskGB, source = volumeGB, "boot volume"
+		}
+		if diskGB == 0 {
+			add("disk", CheckSkip, "", "", "flavor has no root disk (boot from volume)")
+		} else {
+			want := int64(diskGB) << 30
+			expected = fmt.Sprintf("disk of %d GiB (%s)", diskGB, source)
+			actual = formatDisks(f.Disks)
+			if slices.ContainsFunc(f.Disks, func(d *pb.DiskFact) bool {
+				diff := float64(d.SizeBytes - want)
+				return diff >= -float64(want)*diskSizeTolerance && diff <= float64(want)*diskSizeTolerance
+			}) {
+				add("disk", CheckPass, expected, actual, "")
+			} else {
+				add("disk", CheckFail, expected, actual, "no disk of the "+source+" size")
+			}
+		}
+	}
+
+	if expect.Firmware != "" {
+		if f.Firmware == expect.Firmware {
+			add("firmware", CheckPass, expect.Firmware, f.Firmware, "")
+		} else {
+			add("firmware", CheckFail, expect.Firmware, f.Firmware, "unexpected firmware type")
+		}
+	}
+
+	if len(expect.VirtioDrivers) > 0 {
+		var missing []string
+		for _, drv := range expect.VirtioDrivers {
+			if !slices.Contains(f.VirtioDrivers, drv) {
+				missing = append(missing, drv)
+			}
+		}
+		expected, actual := strings.Join(expect.VirtioDrivers, ", "), strings.Join(f.VirtioDrivers, ", ")
+		if len(missing) == 0 {
+			add("virtio", CheckPass, expected, actual, "")
+		} else {
+			add("virtio", CheckFail, expected, actual, "missing virtio drivers: "+strings.Join(missing, ", "))
+		}
+	}
+
+	if expect.MinMTU > 0 {
+		var low []string
+		for _, nic := range f.Nics {
+			if int(nic.Mtu) < expect.MinMTU {
+				low = append(low, fmt.Sprintf("%s=%d", nic.Name, nic.Mtu))
+			}
+		}
+		expected := fmt.Sprintf("MTU >= %d", expect.MinMTU)
+		if len(f.Nics) == 0 {
+			add("mtu", CheckFail, expected, "no interfaces", "guest has no network interfaces")
+		} else if len(low) > 0 {
+			add("mtu", CheckFail, expected, strings.Join(low, ", "), "MTU below expected")
+		} else {
+			add("mtu", CheckPass, expected, fmt.Sprintf("%d interfaces", len(f.Nics)), "")
+		}
+	}
+
+	addDuration := func(name string, seconds float64, max time.Duration) {
+		if max <= 0 {
+			return
+		}
+		expected := "<= " + max.String()
+		if seconds == 0 {
+			add(name, CheckSkip, expected, "", "agent could not measure it")
+			return
+		}
+		took := time.Duration(seconds * float64(time.Second)).Round(100 * time.Millisecond)
+		if took <= max {
+			add(name, CheckPass, expected, took.String(), "")
+		} else {
+			add(name, CheckFail, expected, took.String(), "slower than expected")
+		}
+	}
+	addDuration("boot_time", f.BootSeconds, expect.MaxBootTime)
+	addDuration("agent_start", f.AgentStartSeconds, expect.MaxAgentStart)
+
+	return results
+}
+
+func formatDisks(disks []*pb.DiskFact) string {
+	if len(disks) == 0 {
+		return "no disks"
+	}
+	parts := make([]string, 0, len(disks))
+	for _, d := range disks {
+		parts = append(parts, fmt.Sprintf("%s %.1f GiB", d.Name, float64(d.SizeBytes)/(1<<30)))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"testing"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/config"
+	"image-manager/internal/storage"
+	pb "image-manager/pkg/pb"
+)
+
+func diskResult(t *testing.T, results []*pb.CheckResult) *pb.CheckResult {
+	t.Helper()
+	for _, res := range results {
+		if res.Name == "facts:disk" {
+			return res
+		}
+	}
+	t.Fatal("no facts:disk result")
+	return nil
+}
+
+func TestCompareFactsDisk(t *testing.T) {
+	const gib = int64(1) << 30
+	tests := []struct {
+		name     string
+		diskGB   int
+		volumeGB int
+		disk     int64
+		want     string
+	}{
+		{"flavor disk", 20, 0, 20 * gib, CheckPass},
+		{"flavor disk mismatch", 20, 0, 10 * gib, CheckFail},
+		{"boot volume", 0, 10, 10 * gib, CheckPass},
+		{"boot volume mismatch", 0, 10, 20 * gib, CheckFail},
+		// Том задан на флейворе с диском: корень на томе, диск флейвора не используется
+		{"boot volume over flavor disk", 20, 10, 10 * gib, CheckPass},
+		{"flavor disk with boot volume", 20, 10, 20 * gib, CheckFail},
+		{"no disk", 0, 0, 10 * gib, CheckSkip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			facts := &pb.HostFacts{Vcpus: 2, MemoryMb: 2048, Disks: []*pb.DiskFact{{Name: "vda", SizeBytes: tt.disk}}}
+			flavor := &openstack.Flavor{Name: "m1", VCPUs: 2, RAMMB: 2048, DiskGB: tt.diskGB}
+			if got := diskResult(t, compareFacts(facts, flavor, tt.volumeGB, config.FactExpectations{})); got.Status != tt.want {
+				t.Errorf("got %s (%s), want %s", got.Status, got.Expected, tt.want)
+			}
+		})
+	}
+}
+
+func TestBootVolumeGB(t *testing.T) {
+	tests := []struct {
+		options string
+		want    int
+	}{
+		{`{"boot_volume_gb":15}`, 15},
+		{`{"flavor_id":"m1"}`, 0},
+		{`{}`, 0},
+		{`garbage`, 0},
+	}
+	for _, tt := range tests {
+		if got := bootVolumeGB(&storage.TestVM{Options: []byte(tt.options)}); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.options, got, tt.want)
+		}
+	}
+}
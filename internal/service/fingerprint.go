@@ -0,0 +1,190 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"image-manager/internal/config"
+)
+
+// Ключи свойств Glance, в которых хранится "отпечаток" собранного образа.
+const (
+	PropManifestSHA256 = "image_manager_manifest_sha256"
+	PropContentSHA256  = "image_manager_content_sha256"
+)
+
+// Fingerprint — отпечаток сборки.
+// ManifestSHA256 — хеш списка пакетов (dib-manifest dpkg/rpm).
+// ContentSHA256 — хеш входных данных сборки: конфиг дистрибутива, дерево elements/, исходники агента,
+// drop-in проверки дистрибутива и окружение DIB, попадающее в образ (см. hashBuildInputs).
+// Если оба совпадают с боевым образом, пересобранный образ ничем не отличается от него.
+type Fingerprint struct {
+	ManifestSHA256 string
+	ContentSHA256  string
+}
+
+// Properties возвращает отпечаток в виде свойств Glance.
+func (f *Fingerprint) Properties() map[string]string {
+	return map[string]string{
+		PropManifestSHA256: f.ManifestSHA256,
+		PropContentSHA256:  f.ContentSHA256,
+	}
+}
+
+// Matches сравнивает отпечаток со свойствами образа в Glance.
+func (f *Fingerprint) Matches(props map[string]string) bool {
+	if f.ManifestSHA256 == "" || f.ContentSHA256 == "" {
+		return false
+	}
+	return props[PropManifestSHA256] == f.ManifestSHA256 &&
+		props[PropContentSHA256] == f.ContentSHA256
+}
+
+// Fingerprint считает отпечаток только что собранного образа.
+// Вызывать нужно до Cleanup, иначе манифест DIB уже будет удален.
+func (b *Builder) Fingerprint(imageName, distro string) (*Fingerprint, error) {
+	const op = "service.Builder.Fingerprint"
+
+	manifest, err := findPackageManifest(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	manifestHash, err := hashManifest(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	contentHash, err := hashBuildInputs(distro, b.imageEnv())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Fingerprint{ManifestSHA256: manifestHash, ContentSHA256: contentHash}, nil
+}
+
+// findPackageManifest ищет манифест пакетов, который оставляет DIB (элементы dpkg/yum + manifests).
+func findPackageManifest(imageName string) (string, error) {
+	patterns := []string{
+		filepath.Join(imageName+".d", "dib-manifests", "dib-manifest-dpkg-*"),
+		filepath.Join(imageName+".d", "dib-manifests", "dib-manifest-rpm-*"),
+		"dib-manifest-dpkg-*" + imageName + "*",
+		"dib-manifest-rpm-*" + imageName + "*",
+	}
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(p)
+		if len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+	return "", fmt.Errorf("package manifest for %s not found", imageName)
+}
+
+// hashManifest хеширует отсортированный список строк манифеста,
+// чтобы порядок вывода пакетного менеджера не влиял на результат.
+func hashManifest(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read manifest: %w", err)
+	}
+
+	var lines []string
+	for _, l := range strings.Split(string(data), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// agentSources — исходники агента: элемент agent-install собирает его из них при сборке образа.
+// Без исходников (Docker-образ менеджера) ставится готовый бинарник из elements/.
+var agentSources = []string{
+	"go.mod", "go.sum",
+	filepath.Join("cmd", "agent"), filepath.Join("pkg", "pb"), filepath.Join("pkg", "serialreport"),
+}
+
+// hashBuildInputs хеширует все, что попадает в образ помимо пакетов: конфиг дистрибутива,
+// файлы elements/, исходники агента, drop-in проверки дистрибутива и окружение DIB (env).
+func hashBuildInputs(distro string, env []string) (string, error) {
+	h := sha256.New()
+
+	distroPath := config.DistroConfigPath(distro)
+	if err := hashFile(h, distroPath, distroPath); err != nil {
+		return "", err
+	}
+
+	if err := hashTree(h, "elements"); err != nil {
+		return "", fmt.Errorf("hash elements: %w", err)
+	}
+
+	for _, path := range agentSources {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := hashTree(h, path); err != nil {
+			return "", fmt.Errorf("hash agent sources: %w", err)
+		}
+	}
+
+	distroCfg, err := config.LoadDistroConfig(distro)
+	if err != nil {
+		return "", err
+	}
+	for _, check := range distroCfg.AgentChecks {
+		if err := hashTree(h, filepath.Join(config.AgentChecksDir, check)); err != nil {
+			return "", fmt.Errorf("hash agent checks: %w", err)
+		}
+	}
+
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		if name == "MANAGER_CA_BUNDLE" {
+			// Путь зависит от каталога менеджера, в образ попадает содержимое
+			if err := hashFile(h, "env:"+name, value); err != nil {
+				return "", err
+			}
+			continue
+		}
+		fmt.Fprintf(h, "env:%s\n", kv)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashTree хеширует файл или все файлы каталога (filepath.Walk обходит их в лексическом порядке).
+func hashTree(w io.Writer, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return hashFile(w, path, path)
+	})
+}
+
+func hashFile(w io.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	// Имя файла тоже участвует в хеше, чтобы переименование не прошло незамеченным
+	fmt.Fprintf(w, "%s\n", name)
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	return nil
+}
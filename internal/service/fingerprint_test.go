@@ -0,0 +1,192 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"image-manager/internal/config"
+)
+
+// writeFile создает файл со всеми родительскими каталогами.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// buildTree готовит в текущем каталоге то, что оставляет сборка: конфиг дистрибутива с drop-in
+// проверкой, elements/, исходники агента и манифест DIB.
+func buildTree(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	writeFile(t, filepath.Join("configs", "distros", "ubuntu-24.yaml"), "name: ubuntu-24\nagent_checks: [ubuntu/apt.sh]\n")
+	writeFile(t, filepath.Join("configs", "checks", "ubuntu", "apt.sh"), "#!/bin/sh\napt-get check\n")
+	writeFile(t, filepath.Join("configs", "checks", "debian", "apt.sh"), "#!/bin/sh\n")
+	writeFile(t, filepath.Join("elements", "agent-install", "install.d", "50-install-agent"), "#!/bin/bash\n")
+	writeFile(t, "go.mod", "module image-manager\n")
+	writeFile(t, "go.sum", "")
+	writeFile(t, filepath.Join("cmd", "agent", "main.go"), "package main\n")
+	writeFile(t, filepath.Join("pkg", "pb", "agent.pb.go"), "package pb\n")
+	writeFile(t, filepath.Join("pkg", "serialreport", "serialreport.go"), "package serialreport\n")
+	writeFile(t, "ca.pem", "CA 1\n")
+	writeFile(t, filepath.Join("Ubuntu-24.d", "dib-manifests", "dib-manifest-dpkg-ubuntu"), "openssh-server 1:9.6\nbash 5.2\n")
+}
+
+// buildEnv — окружение DIB, как его собирает Builder.imageEnv.
+func buildEnv(addr string) []string {
+	ca, _ := filepath.Abs("ca.pem")
+	return []string{"MANAGER_ADDRESS=" + addr, "MANAGER_CA_BUNDLE=" + ca, "AGENT_TRANSPORT=grpc"}
+}
+
+func TestHashManifestIgnoresOrder(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	writeFile(t, a, "openssh-server 1:9.6\nbash 5.2\n")
+	writeFile(t, b, "\n  bash 5.2\nopenssh-server 1:9.6  \n\n")
+
+	hashA, err := hashManifest(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashB, err := hashManifest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashA != hashB {
+		t.Errorf("order and blank lines changed the hash: %s != %s", hashA, hashB)
+	}
+
+	writeFile(t, b, "openssh-server 1:9.7\nbash 5.2\n")
+	if hashC, _ := hashManifest(b); hashC == hashA {
+		t.Error("package version change did not change the hash")
+	}
+}
+
+func TestHashBuildInputs(t *testing.T) {
+	buildTree(t)
+	base, err := hashBuildInputs("ubuntu", buildEnv("im.example.com:50051"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := hashBuildInputs("ubuntu-24", buildEnv("im.example.com:50051")); again != base {
+		t.Errorf("distro alias changed the hash: %s != %s", again, base)
+	}
+	// Проверка, на которую дистрибутив не ссылается, в образ не попадает
+	writeFile(t, filepath.Join("configs", "checks", "debian", "apt.sh"), "#!/bin/sh\nexit 1\n")
+	if again, _ := hashBuildInputs("ubuntu", buildEnv("im.example.com:50051")); again != base {
+		t.Error("unreferenced check changed the hash")
+	}
+
+	script := filepath.Join("elements", "agent-install", "install.d", "50-install-agent")
+	tests := []struct {
+		name   string
+		change func(t *testing.T)
+	}{
+		{"distro config", func(t *testing.T) {
+			writeFile(t, filepath.Join("configs", "distros", "ubuntu-24.yaml"), "name: ubuntu-24\nsoak_minutes: 5\n")
+		}},
+		{"element content", func(t *testing.T) { writeFile(t, script, "#!/bin/bash\nexit 0\n") }},
+		{"element rename", func(t *testing.T) {
+			if err := os.Rename(script, filepath.Join(filepath.Dir(script), "60-install-agent")); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"new element file", func(t *testing.T) { writeFile(t, filepath.Join("elements", "extra", "README"), "") }},
+		{"agent source", func(t *testing.T) {
+			writeFile(t, filepath.Join("cmd", "agent", "main.go"), "package main\n\nfunc main() {}\n")
+		}},
+		{"new agent file", func(t *testing.T) { writeFile(t, filepath.Join("cmd", "agent", "checks.go"), "package main\n") }},
+		{"protocol", func(t *testing.T) { writeFile(t, filepath.Join("pkg", "pb", "agent.pb.go"), "package pb\n// v2\n") }},
+		{"serial report", func(t *testing.T) {
+			writeFile(t, filepath.Join("pkg", "serialreport", "serialreport.go"), "package serialreport\n// v2\n")
+		}},
+		{"go.mod", func(t *testing.T) { writeFile(t, "go.mod", "module image-manager\n\ngo 1.24\n") }},
+		{"go.sum", func(t *testing.T) { writeFile(t, "go.sum", "example.com/x v1.0.0 h1:abc=\n") }},
+		{"agent check", func(t *testing.T) {
+			writeFile(t, filepath.Join("configs", "checks", "ubuntu", "apt.sh"), "#!/bin/sh\napt-get check -q\n")
+		}},
+		{"ca bundle", func(t *testing.T) { writeFile(t, "ca.pem", "CA 2\n") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buildTree(t)
+			tt.change(t)
+			got, err := hashBuildInputs("ubuntu", buildEnv("im.example.com:50051"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == base {
+				t.Error("change did not affect the hash")
+			}
+		})
+	}
+
+	// Адрес менеджера зашит в конфиг агента в образе
+	t.Run("manager address", func(t *testing.T) {
+		buildTree(t)
+		got, err := hashBuildInputs("ubuntu", buildEnv("im2.example.com:50051"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == base {
+			t.Error("manager address did not affect the hash")
+		}
+	})
+}
+
+func TestBuilderFingerprint(t *testing.T) {
+	buildTree(t)
+	b := &Builder{cfg: &config.Config{}}
+
+	first, err := b.Fingerprint("Ubuntu-24", "ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ManifestSHA256 == "" || first.ContentSHA256 == "" {
+		t.Fatalf("empty fingerprint: %+v", first)
+	}
+	second, err := b.Fingerprint("Ubuntu-24", "ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *first != *second {
+		t.Errorf("rebuild of the same inputs: %+v != %+v", first, second)
+	}
+
+	if _, err := b.Fingerprint("Debian-12", "ubuntu"); err == nil {
+		t.Error("expected an error without a package manifest")
+	}
+}
+
+// Решение NO_CHANGE: отпечаток сборки совпадает со свойствами боевого образа.
+func TestFingerprintMatches(t *testing.T) {
+	fp := &Fingerprint{ManifestSHA256: "m1", ContentSHA256: "c1"}
+	tests := []struct {
+		name  string
+		fp    *Fingerprint
+		props map[string]string
+		want  bool
+	}{
+		{"same build", fp, fp.Properties(), true},
+		{"extra properties", fp, map[string]string{PropManifestSHA256: "m1", PropContentSHA256: "c1", "os_distro": "ubuntu"}, true},
+		{"packages changed", fp, map[string]string{PropManifestSHA256: "m2", PropContentSHA256: "c1"}, false},
+		{"elements changed", fp, map[string]string{PropManifestSHA256: "m1", PropContentSHA256: "c2"}, false},
+		{"production without fingerprint", fp, map[string]string{"os_distro": "ubuntu"}, false},
+		{"no production image", fp, nil, false},
+		{"empty fingerprint", &Fingerprint{}, map[string]string{PropManifestSHA256: "", PropContentSHA256: ""}, false},
+		{"partial fingerprint", &Fingerprint{ManifestSHA256: "m1"}, map[string]string{PropManifestSHA256: "m1", PropContentSHA256: ""}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fp.Matches(tt.props); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
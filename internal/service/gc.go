@@ -0,0 +1,383 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/storage"
+)
+
+// Метки, которыми менеджер помечает свои ресурсы (свойства Glance / метаданные Nova).
+const (
+	TagOwner   = "image_manager_owner"
+	TagBuildID = "image_manager_build_id"
+
+	// Только у тестовых VM: чтобы по серверу в Horizon было видно, что и зачем он проверяет
+	TagImage  = "image_manager_image"
+	TagDistro = "image_manager_distro"
+	TagCell   = "image_manager_cell"
+)
+
+// Виды ресурсов в таблице resources.
+const (
+	ResourceImage   = "image"
+	ResourceServer  = "server"
+	ResourceKeypair = "keypair" // Эфемерная ключевая пара тестовых VM сборки (cloud_id — имя)
+)
+
+// IsTerminalStatus сообщает, что сборка завершена и ее ресурсы больше не нужны пайплайну.
+func IsTerminalStatus(status string) bool {
+	return status == "SUCCESS" || status == "NO_CHANGE" || strings.HasPrefix(status, "ERROR")
+}
+
+// IsFinalStatus сообщает, что исход сборки решен и статус больше не изменится. ERROR_TIMEOUT
+// сюда не входит: это предупреждение watchdog, агент еще может ожить (см. BuildFinished).
+// SOAKING и PROMOTING — сборка еще идет.
+func IsFinalStatus(status string) bool {
+	switch status {
+	case "ERROR_TIMEOUT", StatusSoaking, StatusPromoting:
+		return false
+	}
+	return IsTerminalStatus(status)
+}
+
+// GCItem — ресурс, который сборщик мусора удалил бы (или удалил).
+type GCItem struct {
+	Kind        string `json:"kind"`
+	CloudID     string `json:"cloud_id"`
+	Name        string `json:"name"`
+	BuildID     int64  `json:"build_id"`
+	BuildStatus string `json:"build_status"`
+	Tracked     bool   `json:"tracked"` // false = найден только по метке (например, менеджер упал до записи в БД)
+	Error       string `json:"error,omitempty"`
+}
+
+// GarbageCollector удаляет брошенные кандидаты и тестовые VM.
+// Трогает только ресурсы этого менеджера: записанные в таблицу resources
+// или помеченные его меткой owner, и только после завершения сборки + grace period.
+type GarbageCollector struct {
+	log      *slog.Logger
+	store    *storage.Storage
+	osClient *openstack.Client
+	owner    string
+	grace    time.Duration
+}
+
+func NewGarbageCollector(log *slog.Logger, store *storage.Storage, osc *openstack.Client, owner string, grace time.Duration) *GarbageCollector {
+	return &GarbageCollector{
+		log:      log,
+		store:    store,
+		osClient: osc,
+		owner:    owner,
+		grace:    grace,
+	}
+}
+
+// Tags возвращает метки для ресурсов сборки.
+func (g *GarbageCollector) Tags(buildID int64) map[string]string {
+	return map[string]string{
+		TagOwner:   g.owner,
+		TagBuildID: strconv.FormatInt(buildID, 10),
+	}
+}
+
+// Track записывает созданный ресурс в БД.
+func (g *GarbageCollector) Track(buildID int64, kind, cloudID, name string) {
+	if err := g.store.AddResource(buildID, kind, cloudID, name); err != nil {
+		g.log.Error("gc: failed to track resource", slog.String("kind", kind), slog.String("id", cloudID), slog.String("err", err.Error()))
+	}
+}
+
+// Run периодически запускает сборку мусора, пока не отменен ctx.
+func (g *GarbageCollector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			items, err := g.Collect()
+			if err != nil {
+				g.log.Error("gc: run failed", slog.String("err", err.Error()))
+				continue
+			}
+			if len(items) > 0 {
+				g.log.Info("gc: run finished", slog.Int("deleted", len(items)))
+			}
+		}
+	}
+}
+
+// Plan возвращает ресурсы, которые будут удалены (dry-run).
+func (g *GarbageCollector) Plan() ([]GCItem, error) {
+	const op = "service.GarbageCollector.Plan"
+
+	// Ресурсы с нашей меткой, которых нет в БД (крэш между созданием и записью)
+	images, err := g.osClient.ListTaggedImages(TagOwner)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	servers, err := g.osClient.ListTaggedServers(TagOwner)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	items, err := g.plan(images, servers, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return items, nil
+}
+
+// plan сводит план из ресурсов в БД и найденных в облаке по метке владельца.
+func (g *GarbageCollector) plan(images, servers []openstack.TaggedResource, now time.Time) ([]GCItem, error) {
+	var items []GCItem
+
+	tracked, err := g.store.ListLiveResources()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range tracked {
+		if !g.expired(r.BuildID, r.BuildStatus, r.BuildUpdate, now) {
+			continue
+		}
+		items = append(items, GCItem{
+			Kind:        r.Kind,
+			CloudID:     r.CloudID,
+			Name:        r.Name,
+			BuildID:     r.BuildID,
+			BuildStatus: r.BuildStatus,
+			Tracked:     true,
+		})
+	}
+
+	for _, res := range images {
+		if item, ok := g.untracked(ResourceImage, res, now); ok {
+			items = append(items, item)
+		}
+	}
+	for _, res := range servers {
+		if item, ok := g.untracked(ResourceServer, res, now); ok {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+// Collect удаляет ресурсы из плана. Возвращает то, что удалось удалить.
+func (g *GarbageCollector) Collect() ([]GCItem, error) {
+	items, err := g.Plan()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []GCItem
+	for _, item := range items {
+		if err := g.delete(item.Kind, item.CloudID); err != nil {
+			g.log.Error("gc: failed to delete resource",
+				slog.String("kind", item.Kind),
+				slog.String("id", item.CloudID),
+				slog.String("err", err.Error()),
+			)
+			continue
+		}
+		g.log.Info("gc: resource deleted",
+			slog.String("kind", item.Kind),
+			slog.String("id", item.CloudID),
+			slog.Int64("build_id", item.BuildID),
+		)
+		_ = g.store.AppendLog(item.BuildID, fmt.Sprintf("GC: deleted %s %s (%s)", item.Kind, item.CloudID, item.Name))
+		deleted = append(deleted, item)
+	}
+
+	// Пары сборок, у которых VM так и не создались (ERROR_VM_BOOT), — без grace period
+	deleted = append(deleted, g.releaseKeypairs()...)
+	return deleted, nil
+}
+
+// CollectSuperseded сразу (без grace period) удаляет образы-кандидаты с тем же именем,
+// оставшиеся от завершенных сборок. Вызывается перед загрузкой нового кандидата.
+func (g *GarbageCollector) CollectSuperseded(name string) {
+	tracked, err := g.store.ListLiveResources()
+	if err != nil {
+		g.log.Warn("gc: failed to list resources", slog.String("err", err.Error()))
+		return
+	}
+	for _, r := range tracked {
+		if r.Kind != ResourceImage || r.Name != name || !g.finished(r.BuildID, r.BuildStatus) {
+			continue
+		}
+		if err := g.delete(r.Kind, r.CloudID); err != nil {
+			g.log.Warn("gc: failed to delete superseded candidate", slog.String("id", r.CloudID), slog.String("err", err.Error()))
+			continue
+		}
+		g.log.Info("gc: superseded candidate deleted", slog.String("id", r.CloudID), slog.Int64("build_id", r.BuildID))
+	}
+}
+
+// DeleteServer удаляет тестовую VM и отмечает это в БД. Ключевая пара сборки удаляется
+// вместе с ее последней VM.
+func (g *GarbageCollector) DeleteServer(vmID string) error {
+	if err := g.delete(ResourceServer, vmID); err != nil {
+		return err
+	}
+	g.releaseKeypairs()
+	return nil
+}
+
+// ReleaseToken отзывает токен агентов сборки, когда он больше никому не нужен: сборка
+// завершена (BuildFinished) и ни одна упавшая VM не ждет заливки диагностики. Вызывается при
+// завершении сборки, после приема архива и при удалении тестовой VM.
+func (g *GarbageCollector) ReleaseToken(buildID int64) {
+	status, _, err := g.store.GetBuildStatus(buildID)
+	if err != nil {
+		g.log.Warn("gc: failed to get build status", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+		return
+	}
+	if !g.finished(buildID, status) {
+		return
+	}
+	if waiting, err := g.store.AwaitingDiagnostics(buildID); err != nil || waiting {
+		return
+	}
+	if hash, err := g.store.GetAgentTokenHash(buildID); err != nil || hash == "" {
+		return // Уже отозван
+	}
+	if err := g.store.RevokeAgentToken(buildID); err != nil {
+		g.log.Error("failed to revoke agent token", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+		return
+	}
+	g.log.Info("agent token revoked", slog.Int64("build_id", buildID), slog.String("status", status))
+}
+
+// releaseKeypairs удаляет ключевые пары сборок, у которых не осталось тестовых VM
+// (и новых уже не будет). Возвращает удаленные.
+func (g *GarbageCollector) releaseKeypairs() []GCItem {
+	unused, err := g.store.ListUnusedKeypairs()
+	if err != nil {
+		g.log.Warn("gc: failed to list unused keypairs", slog.String("err", err.Error()))
+		return nil
+	}
+
+	var deleted []GCItem
+	for _, r := range unused {
+		if err := g.delete(ResourceKeypair, r.CloudID); err != nil {
+			g.log.Warn("gc: failed to delete keypair", slog.String("name", r.CloudID), slog.String("err", err.Error()))
+			continue
+		}
+		g.log.Info("gc: keypair deleted", slog.String("name", r.CloudID), slog.Int64("build_id", r.BuildID))
+		_ = g.store.AppendLog(r.BuildID, fmt.Sprintf("SSH keypair %s deleted with the last test VM.", r.CloudID))
+		deleted = append(deleted, GCItem{
+			Kind:        r.Kind,
+			CloudID:     r.CloudID,
+			Name:        r.Name,
+			BuildID:     r.BuildID,
+			BuildStatus: r.BuildStatus,
+			Tracked:     true,
+		})
+	}
+	return deleted
+}
+
+func (g *GarbageCollector) delete(kind, cloudID string) error {
+	var err error
+	switch kind {
+	case ResourceImage:
+		err = g.osClient.DeleteImage(cloudID)
+	case ResourceServer:
+		err = g.osClient.DeleteVM(cloudID)
+	case ResourceKeypair:
+		err = g.osClient.DeleteKeypair(cloudID)
+	default:
+		return fmt.Errorf("unknown resource kind %q", kind)
+	}
+
+	// Уже удален руками или другим процессом — тоже успех
+	if err != nil && !openstack.IsNotFound(err) {
+		return err
+	}
+	if kind == ResourceKeypair {
+		// Пары больше нет — приватный ключ к ней не нужен никому
+		if err := g.store.DropSSHPrivateKey(cloudID); err != nil {
+			return err
+		}
+	}
+	if err := g.store.MarkResourceDeleted(kind, cloudID); err != nil {
+		return err
+	}
+	if kind == ResourceServer {
+		// Агента удаленной VM больше нет — возможно, токен сборки ждал только его
+		if vm, err := g.store.GetTestVMByVMID(cloudID); err == nil {
+			g.ReleaseToken(vm.BuildID)
+		}
+	}
+	return nil
+}
+
+// untracked проверяет ресурс, найденный по метке, но отсутствующий в БД.
+func (g *GarbageCollector) untracked(kind string, res openstack.TaggedResource, now time.Time) (GCItem, bool) {
+	if res.Tags[TagOwner] != g.owner {
+		return GCItem{}, false
+	}
+
+	if known, err := g.store.IsResourceTracked(kind, res.ID); err != nil || known {
+		return GCItem{}, false
+	}
+
+	buildID, err := strconv.ParseInt(res.Tags[TagBuildID], 10, 64)
+	if err != nil {
+		return GCItem{}, false
+	}
+
+	state, err := g.store.GetBuildState(buildID)
+	if err != nil {
+		return GCItem{}, false
+	}
+
+	// Боевой образ несет метки кандидата, из которого он получился
+	if kind == ResourceImage && state.GlanceID == res.ID && state.Status == "SUCCESS" {
+		return GCItem{}, false
+	}
+
+	if !g.expired(buildID, state.Status, state.UpdatedAt, now) {
+		return GCItem{}, false
+	}
+
+	return GCItem{
+		Kind:        kind,
+		CloudID:     res.ID,
+		Name:        res.Name,
+		BuildID:     buildID,
+		BuildStatus: state.Status,
+	}, true
+}
+
+// expired: сборка завершена и grace period прошел. Неизвестное время считаем "свежим".
+func (g *GarbageCollector) expired(buildID int64, status string, updatedAt, now time.Time) bool {
+	if updatedAt.IsZero() || !g.finished(buildID, status) {
+		return false
+	}
+	return now.Sub(updatedAt) >= g.grace
+}
+
+// finished — BuildFinished с тестовыми VM сборки из БД: по предупреждению watchdog
+// (ERROR_TIMEOUT) кандидат и VM не трогаем, агент еще может ожить.
+func (g *GarbageCollector) finished(buildID int64, status string) bool {
+	if status != "ERROR_TIMEOUT" {
+		return IsFinalStatus(status)
+	}
+	vms, err := g.store.GetTestVMs(buildID)
+	if err != nil {
+		g.log.Warn("gc: failed to get test vms", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+		return false
+	}
+	return BuildFinished(status, vms)
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/storage"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// newTestStore открывает пустую БД во временном каталоге.
+func newTestStore(t *testing.T) *storage.Storage {
+	t.Helper()
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// newBuild создает сборку в статусе status.
+func newBuild(t *testing.T, store *storage.Storage, status string) int64 {
+	t.Helper()
+	id, err := store.CreateBuild("Ubuntu-24", "ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateBuildStatus(id, status); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func planIDs(items []GCItem) []string {
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.CloudID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestGCPlan(t *testing.T) {
+	store := newTestStore(t)
+	gc := NewGarbageCollector(discardLogger(), store, nil, "im-test", time.Hour)
+
+	failed := newBuild(t, store, "ERROR_TEST")
+	gc.Track(failed, ResourceImage, "img-failed", "Ubuntu-24-candidate")
+	gc.Track(failed, ResourceServer, "vm-failed", "test-vm")
+
+	running := newBuild(t, store, "WAITING_AGENT")
+	gc.Track(running, ResourceImage, "img-running", "Ubuntu-24-candidate")
+	gc.Track(running, ResourceServer, "vm-running", "test-vm")
+
+	// Выпущенный кандидат: исключен из GC при promote, но в облаке несет метки сборки
+	promoted := newBuild(t, store, "SUCCESS")
+	gc.Track(promoted, ResourceImage, "img-prod", "Ubuntu-24-candidate")
+	if err := store.SetGlanceID(promoted, "img-prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ReleaseResource(ResourceImage, "img-prod"); err != nil {
+		t.Fatal(err)
+	}
+
+	tagged := func(id string, buildID string) openstack.TaggedResource {
+		return openstack.TaggedResource{ID: id, Name: id, Tags: map[string]string{TagOwner: "im-test", TagBuildID: buildID}}
+	}
+	images := []openstack.TaggedResource{
+		tagged("img-prod", itoa(promoted)),
+		tagged("img-failed", itoa(failed)), // Уже в БД: не должен попасть в план дважды
+		tagged("img-lost", itoa(failed)),   // Менеджер упал до записи в БД
+		tagged("img-lost-running", itoa(running)),
+		tagged("img-no-build", "oops"),
+		{ID: "img-foreign", Tags: map[string]string{TagOwner: "someone-else", TagBuildID: itoa(failed)}},
+	}
+	servers := []openstack.TaggedResource{
+		tagged("vm-lost", itoa(failed)),
+	}
+
+	now := time.Now().UTC()
+	tests := []struct {
+		name string
+		now  time.Time
+		want []string
+	}{
+		{"within grace period", now.Add(30 * time.Minute), nil},
+		{"after grace period", now.Add(2 * time.Hour), []string{"img-failed", "img-lost", "vm-failed", "vm-lost"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := gc.plan(images, servers, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := planIDs(items); !slices.Equal(got, tt.want) {
+				t.Errorf("plan = %v, want %v", got, tt.want)
+			}
+			for _, item := range items {
+				lost := item.CloudID == "img-lost" || item.CloudID == "vm-lost"
+				if item.Tracked == lost {
+					t.Errorf("%s: tracked = %v", item.CloudID, item.Tracked)
+				}
+				if item.BuildID != failed || item.BuildStatus != "ERROR_TEST" {
+					t.Errorf("%s: build %d %s, want %d ERROR_TEST", item.CloudID, item.BuildID, item.BuildStatus, failed)
+				}
+			}
+		})
+	}
+}
+
+func TestGCPlanSkipsDeleted(t *testing.T) {
+	store := newTestStore(t)
+	gc := NewGarbageCollector(discardLogger(), store, nil, "im-test", 0)
+
+	id := newBuild(t, store, "ERROR_VM_BOOT")
+	gc.Track(id, ResourceServer, "vm-gone", "test-vm")
+	if err := store.MarkResourceDeleted(ResourceServer, "vm-gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := gc.plan(nil, nil, time.Now().UTC().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("plan = %v, want empty", planIDs(items))
+	}
+}
+
+func TestGCExpired(t *testing.T) {
+	store := newTestStore(t)
+	gc := &GarbageCollector{log: discardLogger(), store: store, grace: time.Hour}
+	now := time.Now().UTC()
+	tests := []struct {
+		status  string
+		vm      string // Статус обязательной тестовой VM ("" — без VM)
+		updated time.Time
+		want    bool
+	}{
+		{"SUCCESS", "", now.Add(-2 * time.Hour), true},
+		{"NO_CHANGE", "", now.Add(-time.Hour), true},
+		// Предупреждение watchdog: агент еще может ожить
+		{"ERROR_TIMEOUT", "ERROR_TIMEOUT", now.Add(-2 * time.Hour), false},
+		// Watchdog удалил VM — сборка завершена по таймауту
+		{"ERROR_TIMEOUT", StatusTerminated, now.Add(-2 * time.Hour), true},
+		{"ERROR_TEST", "", now.Add(-59 * time.Minute), false},
+		{"WAITING_AGENT", "", now.Add(-48 * time.Hour), false},
+		{StatusSoaking, "", now.Add(-48 * time.Hour), false},
+		{StatusPromoting, "", now.Add(-48 * time.Hour), false},
+		{"ERROR_BUILD", "", time.Time{}, false}, // Время неизвестно — считаем свежим
+	}
+	for _, tt := range tests {
+		buildID := newBuild(t, store, tt.status)
+		if tt.vm != "" {
+			id, err := store.AddTestVM(buildID, "", true, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := store.UpdateTestVMStatus(id, "PENDING", tt.vm); err != nil || !ok {
+				t.Fatalf("set %s: %v", tt.vm, err)
+			}
+		}
+		if got := gc.expired(buildID, tt.status, tt.updated, now); got != tt.want {
+			t.Errorf("expired(%s/%s, %s ago) = %v, want %v", tt.status, tt.vm, now.Sub(tt.updated), got, tt.want)
+		}
+	}
+}
+
+func itoa(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
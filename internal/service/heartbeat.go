@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"image-manager/internal/storage"
+	"image-manager/pkg/pb"
+)
+
+// Этапы, о которых агент сообщает в поле phase.
+const (
+	PhaseStart     = "START"      // Агент запустился после загрузки VM
+	PhaseHeartbeat = "HEARTBEAT"  // Агент жив, проверки идут
+	PhaseCheckDone = "CHECK_DONE" // Завершена одна проверка (результат в results)
+	PhaseTestsDone = "TESTS_DONE" // Итоговый отчет
+	PhaseSoak      = "SOAK"       // Проверки здоровья в окне наблюдения после итогового отчета
+)
+
+// IsFinalPhase — сообщение с итоговым отчетом. Все, что не промежуточный этап, считаем итогом:
+// агенты старых версий шлют единственный отчет с phase BOOT_CHECK или пустым.
+func IsFinalPhase(phase string) bool {
+	switch phase {
+	case PhaseStart, PhaseHeartbeat, PhaseCheckDone, PhaseSoak:
+		return false
+	}
+	return true
+}
+
+// Heartbeat обрабатывает промежуточное сообщение агента (все, кроме TESTS_DONE):
+// фиксирует этап и время, чтобы watchdog отсчитывал тишину от последнего сигнала.
+func (r *Reporter) Heartbeat(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	buildInfo, err := r.authenticate(ctx, req.VmId, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	vm, err := r.store.GetTestVMByVMID(req.VmId)
+	if err != nil {
+		return nil, fmt.Errorf("service.Heartbeat: %w", err)
+	}
+
+	// Как в Process: вызовы OpenStack — после снятия блокировки VM
+	var later followUp
+	defer later.run()
+	defer r.lockVM(vm.ID)()
+
+	if vm, err = r.store.GetTestVM(vm.ID); err != nil {
+		return nil, fmt.Errorf("service.Heartbeat: %w", err)
+	}
+	r.touch(buildInfo.ID, vm, req.Phase)
+	prefix := LogPrefix(vm.Cell)
+
+	if vm.Status == StatusSoaking {
+		return r.soakHeartbeat(buildInfo, vm, req, &later)
+	}
+	if !acceptsReport(vm.Status) {
+		if vm.Status == "SUCCESS" || vm.Status == StatusCancelled {
+			return &pb.StatusResponse{Command: "SHUTDOWN"}, nil
+		}
+		// VM упала, агент ждет на ней: отдаем запрос диагностики из UI/API, если он есть
+		requested, err := r.store.TakeDiagnosticsRequest(vm.ID)
+		if err != nil {
+			return nil, fmt.Errorf("service.Heartbeat: %w", err)
+		}
+		if requested && r.expectDiagnostics(vm.ID) {
+			return diagnosticsResponse(), nil
+		}
+		return &pb.StatusResponse{Command: "WAIT"}, nil
+	}
+
+	// Агент ожил после предупреждения watchdog — снимаем таймаут
+	if vm.Status == "ERROR_TIMEOUT" && r.setVMStatus(buildInfo.ID, vm.ID, vm.Status, "WAITING_AGENT", &later) {
+		_ = r.store.AppendLog(buildInfo.ID, prefix+"Agent is alive again, timeout cleared.")
+	}
+
+	switch req.Phase {
+	case PhaseStart:
+		r.log.Info("agent started", slog.Int64("build_id", buildInfo.ID), slog.String("vm_id", req.VmId),
+			slog.String("cell", vm.Cell), slog.String("identity_source", req.IdentitySource))
+		msg := "Agent started"
+		if req.Boot >= finalBoot {
+			msg = fmt.Sprintf("Agent started after reboot (boot %d)", req.Boot)
+		}
+		if req.IdentitySource != "" {
+			// Не metadata — повод проверить сервис метаданных в облаке или сеть образа
+			msg += fmt.Sprintf(" (VM ID from %s)", req.IdentitySource)
+		}
+		_ = r.store.AppendLog(buildInfo.ID, prefix+msg+", session established.")
+	case PhaseCheckDone:
+		for _, res := range req.Results {
+			_ = r.store.AppendLog(buildInfo.ID, fmt.Sprintf("%sAgent: [%s] %s%s (%dms)", prefix, res.Status, bootPrefix(req.Boot), res.Name, res.DurationMs))
+		}
+	}
+
+	return &pb.StatusResponse{Command: "WAIT"}, nil
+}
+
+// touch записывает этап агента тестовой VM и время последнего сигнала.
+func (r *Reporter) touch(buildID int64, vm *storage.TestVM, phase string) {
+	if err := r.store.RecordHeartbeat(buildID, vm.ID, phase); err != nil {
+		r.log.Error("failed to record heartbeat", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+	}
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"image-manager/internal/storage"
+	pb "image-manager/pkg/pb"
+)
+
+// Статусы тестовой VM, которых нет у сборки.
+const (
+	StatusCancelled  = "CANCELLED"  // Остановлена: упала обязательная ячейка, promote уже не будет
+	StatusTerminated = "TERMINATED" // Удалена watchdog: агент так и не прислал итоговый отчет
+)
+
+// StatusPromoting — все обязательные VM прошли, идет promote. Статус берется через CAS:
+// VM одной сборки, прошедшие одновременно, не выпустят образ дважды.
+const StatusPromoting = "PROMOTING"
+
+// CellPrefix — префикс имен проверок ячейки матрицы, чтобы результаты разных VM не смешивались.
+func CellPrefix(cell string) string {
+	if cell == "" {
+		return ""
+	}
+	return cell + "/"
+}
+
+// LogPrefix — префикс строк лога сборки про тестовую VM ячейки.
+func LogPrefix(cell string) string {
+	if cell == "" {
+		return ""
+	}
+	return "[" + cell + "] "
+}
+
+// CellArtifact — имя артефакта тестовой VM ячейки (консоль, диагностика).
+func CellArtifact(cell, name string) string {
+	if cell == "" {
+		return name
+	}
+	return cell + "." + name
+}
+
+// runningVM — тестовая VM еще может изменить исход сборки.
+func runningVM(status string) bool {
+	switch status {
+	case "PENDING", "BOOTING_VM", "WAITING_AGENT", "ERROR_TIMEOUT", StatusSoaking:
+		return true
+	}
+	return false
+}
+
+// BuildFinished сообщает, что сборка завершена окончательно: IsFinalStatus или ERROR_TIMEOUT,
+// после которого watchdog удалил обязательную VM (TERMINATED). vms — тестовые VM сборки,
+// нужны только для ERROR_TIMEOUT; без VM агенту ожить негде.
+func BuildFinished(status string, vms []storage.TestVM) bool {
+	if status != "ERROR_TIMEOUT" {
+		return IsFinalStatus(status)
+	}
+	_, failed := matrixStatus(vms)
+	return failed || len(vms) == 0
+}
+
+// matrixStatus сводит статусы тестовых VM в статус сборки. failed — обязательная VM упала
+// окончательно: promote не будет, остальные VM не нужны. "SUCCESS" — все VM завершились,
+// обязательные прошли.
+func matrixStatus(vms []storage.TestVM) (status string, failed bool) {
+	var waiting, booting, soaking, timeout bool
+	for _, vm := range vms {
+		switch vm.Status {
+		case "ERROR_TEST", "ERROR_SOAK", "ERROR_VM_BOOT":
+			if vm.Required {
+				return vm.Status, true
+			}
+		case StatusTerminated:
+			if vm.Required {
+				return "ERROR_TIMEOUT", true
+			}
+		case "ERROR_TIMEOUT":
+			// Предупреждение watchdog: агента можно запустить руками, VM еще жива
+			if vm.Required {
+				timeout = true
+			}
+			waiting = true
+		case "WAITING_AGENT":
+			waiting = true
+		case "PENDING", "BOOTING_VM":
+			booting = true
+		case StatusSoaking:
+			soaking = true
+		}
+	}
+
+	switch {
+	case timeout:
+		return "ERROR_TIMEOUT", false
+	case waiting:
+		return "WAITING_AGENT", false
+	case booting:
+		return "BOOTING_VM", false
+	case soaking:
+		return StatusSoaking, false
+	}
+	return "SUCCESS", false
+}
+
+// SetVMStatus переводит тестовую VM из статуса from в to и пересчитывает статус сборки.
+// false — VM уже в другом статусе (отчет агента обогнал, ячейку отменили), ничего не изменено.
+func (r *Reporter) SetVMStatus(buildID, testVMID int64, from, to string) bool {
+	var later followUp
+	defer later.run()
+	defer r.lockVM(testVMID)()
+	return r.setVMStatus(buildID, testVMID, from, to, &later)
+}
+
+func (r *Reporter) setVMStatus(buildID, testVMID int64, from, to string, later *followUp) bool {
+	ok, err := r.store.UpdateTestVMStatus(testVMID, from, to)
+	if err != nil {
+		r.log.Error("failed to update test vm status", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+		return false
+	}
+	if ok {
+		if from == "ERROR_TEST" || from == "ERROR_SOAK" {
+			// VM больше не ждет архив диагностики: недолитый буфер не нужен
+			r.dropUpload(testVMID)
+		}
+		r.settle(buildID, later)
+	}
+	return ok
+}
+
+// settle пересчитывает статус сборки по ее тестовым VM: выпускает образ, когда прошли все
+// обязательные, и останавливает остальные, когда обязательная упала. Статус сборки меняется
+// через CAS: settle разных VM одной сборки могут идти одновременно.
+func (r *Reporter) settle(buildID int64, later *followUp) {
+	status, _, err := r.store.GetBuildStatus(buildID)
+	if err != nil {
+		r.log.Error("settle: failed to get build status", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+		return
+	}
+	// Исход уже решен (ERROR_TIMEOUT — нет: агент может ожить)
+	if status == StatusPromoting || IsTerminalStatus(status) && status != "ERROR_TIMEOUT" {
+		return
+	}
+
+	vms, err := r.store.GetTestVMs(buildID)
+	if err != nil {
+		r.log.Error("settle: failed to get test vms", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+		return
+	}
+	next, failed := matrixStatus(vms)
+
+	if failed {
+		r.cancel(buildID, vms, later)
+		// После fail: упавшая VM к этому моменту уже ждет архив диагностики
+		later.add(func() { r.gc.ReleaseToken(buildID) })
+	}
+	if next == "SUCCESS" {
+		buildInfo, err := r.store.GetBuildInfo(buildID)
+		if err != nil {
+			r.log.Error("settle: failed to get build", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+			return
+		}
+		if ok, err := r.store.UpdateBuildStatusFrom(buildID, status, StatusPromoting); err != nil || !ok {
+			return // promote уже запустил settle другой VM
+		}
+		later.add(func() { r.promote(buildInfo, vms) })
+		return
+	}
+	if next != status {
+		if _, err := r.store.UpdateBuildStatusFrom(buildID, status, next); err != nil {
+			r.log.Error("failed to update db status", slog.String("err", err.Error()))
+		}
+	}
+}
+
+// cancel останавливает незавершенные тестовые VM сборки.
+func (r *Reporter) cancel(buildID int64, vms []storage.TestVM, later *followUp) {
+	for _, vm := range vms {
+		if !runningVM(vm.Status) {
+			continue
+		}
+		if ok, err := r.store.UpdateTestVMStatus(vm.ID, vm.Status, StatusCancelled); err != nil || !ok {
+			continue
+		}
+		if vm.VMID != "" {
+			later.add(func() { r.deleteVM(vm.VMID) })
+		}
+		_ = r.store.AppendLog(buildID, LogPrefix(vm.Cell)+"Cancelled: a required test VM failed.")
+	}
+}
+
+// pass засчитывает тестовой VM успех: ее консоль (если включено) сохраняется, VM удаляется.
+// Promote — когда пройдут все обязательные VM сборки.
+func (r *Reporter) pass(buildID int64, vm *storage.TestVM, later *followUp) *pb.StatusResponse {
+	// Консоль успешной VM (если включено) — до удаления, потом Nova ее не отдаст.
+	// Promote (его добавит settle) — после удаления
+	later.add(func() {
+		r.console.CaptureOnSuccess(buildID, vm.Cell, vm.VMID)
+		r.deleteVM(vm.VMID)
+	})
+
+	if vm.Cell != "" {
+		_ = r.store.AppendLog(buildID, LogPrefix(vm.Cell)+"Test VM passed.")
+	}
+	r.setVMStatus(buildID, vm.ID, vm.Status, "SUCCESS", later)
+
+	// Говорим агенту выключиться (он сделает самоуничтожение)
+	return &pb.StatusResponse{Command: "SHUTDOWN"}
+}
+
+// promote выпускает кандидата: все обязательные тестовые VM прошли, сборка в PROMOTING.
+func (r *Reporter) promote(buildInfo *storage.BuildInfo, vms []storage.TestVM) {
+	var optionalFailed []string
+	for _, vm := range vms {
+		if vm.Status != "SUCCESS" {
+			optionalFailed = append(optionalFailed, vm.Cell)
+		}
+	}
+	r.log.Info("Promoting image...", slog.Int64("build_id", buildInfo.ID))
+	if len(vms) > 1 {
+		msg := fmt.Sprintf("All required test VMs passed (%d of %d cells).", len(vms)-len(optionalFailed), len(vms))
+		if len(optionalFailed) > 0 {
+			msg += " Optional cells failed: " + strings.Join(optionalFailed, ", ") + "."
+		}
+		_ = r.store.AppendLog(buildInfo.ID, msg)
+	}
+
+	// Подменяем образ, расшариваем потребителям и публикуем в другие регионы
+	if err := r.promoter.Promote(buildInfo.ID); err != nil {
+		r.log.Error("CRITICAL: PROMOTION FAILED", slog.String("err", err.Error()))
+		// TODO: Возможно, стоит пометить статус как ERROR_PROMOTE?
+	} else {
+		r.log.Info("Image promoted to production", slog.String("name", buildInfo.ImageName))
+	}
+
+	// ОБНОВЛЯЕМ СТАТУС В БАЗЕ
+	if err := r.store.UpdateBuildStatus(buildInfo.ID, "SUCCESS"); err != nil {
+		r.log.Error("failed to update db status", slog.String("err", err.Error()))
+	}
+
+	// Токен одноразовый: отчеты приняты, больше он не нужен. Агентам упавших необязательных
+	// VM он нужен, пока они заливают диагностику
+	r.gc.ReleaseToken(buildInfo.ID)
+}
+
+// deleteVM удаляет тестовую VM и отмечает это в БД (с последней VM уходит и ключевая пара сборки).
+func (r *Reporter) deleteVM(vmID string) {
+	if err := r.gc.DeleteServer(vmID); err != nil {
+		r.log.Error("failed to delete vm", slog.String("vm_id", vmID), slog.String("err", err.Error()))
+		return
+	}
+	r.log.Info("VM deleted successfully", slog.String("vm_id", vmID))
+}
+
+// primaryVM — первая ячейка матрицы: по ее VM пишутся факты сборки (тренды времени загрузки).
+func (r *Reporter) primaryVM(vm *storage.TestVM) bool {
+	vms, err := r.store.GetTestVMs(vm.BuildID)
+	return err == nil && len(vms) > 0 && vms[0].ID == vm.ID
+}
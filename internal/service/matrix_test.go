@@ -0,0 +1,230 @@
+package service
+
+import (
+	"testing"
+
+	"image-manager/internal/storage"
+)
+
+func TestMatrixStatus(t *testing.T) {
+	vm := func(status string, required bool) storage.TestVM {
+		return storage.TestVM{Status: status, Required: required}
+	}
+	tests := []struct {
+		name   string
+		vms    []storage.TestVM
+		status string
+		failed bool
+	}{
+		{"single passed", []storage.TestVM{vm("SUCCESS", true)}, "SUCCESS", false},
+		{"single waiting", []storage.TestVM{vm("WAITING_AGENT", true)}, "WAITING_AGENT", false},
+		{"required failed", []storage.TestVM{vm("SUCCESS", true), vm("ERROR_TEST", true)}, "ERROR_TEST", true},
+		{"required failed while others run", []storage.TestVM{vm("WAITING_AGENT", true), vm("ERROR_VM_BOOT", true)}, "ERROR_VM_BOOT", true},
+		{"required soak failed", []storage.TestVM{vm("ERROR_SOAK", true)}, "ERROR_SOAK", true},
+		{"required terminated", []storage.TestVM{vm("TERMINATED", true)}, "ERROR_TIMEOUT", true},
+		{"optional failed", []storage.TestVM{vm("SUCCESS", true), vm("ERROR_TEST", false)}, "SUCCESS", false},
+		{"optional terminated", []storage.TestVM{vm("SUCCESS", true), vm(StatusTerminated, false)}, "SUCCESS", false},
+		{"optional still running", []storage.TestVM{vm("SUCCESS", true), vm("WAITING_AGENT", false)}, "WAITING_AGENT", false},
+		{"cancelled optional", []storage.TestVM{vm("SUCCESS", true), vm(StatusCancelled, false)}, "SUCCESS", false},
+		{"required timeout warning", []storage.TestVM{vm("ERROR_TIMEOUT", true), vm("SUCCESS", true)}, "ERROR_TIMEOUT", false},
+		{"optional timeout warning", []storage.TestVM{vm("ERROR_TIMEOUT", false), vm("SUCCESS", true)}, "WAITING_AGENT", false},
+		{"waiting before booting", []storage.TestVM{vm("PENDING", true), vm("WAITING_AGENT", true)}, "WAITING_AGENT", false},
+		{"booting", []storage.TestVM{vm("PENDING", true), vm("BOOTING_VM", false)}, "BOOTING_VM", false},
+		{"soaking", []storage.TestVM{vm(StatusSoaking, true), vm("SUCCESS", true)}, StatusSoaking, false},
+		{"booting before soaking", []storage.TestVM{vm(StatusSoaking, true), vm("PENDING", true)}, "BOOTING_VM", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, failed := matrixStatus(tt.vms)
+			if status != tt.status || failed != tt.failed {
+				t.Errorf("got %s failed=%v, want %s failed=%v", status, failed, tt.status, tt.failed)
+			}
+		})
+	}
+}
+
+// matrixBuild создает сборку с ячейками в заданных статусах. Серверов у VM нет:
+// отмена и удаление не уходят в OpenStack.
+func matrixBuild(t *testing.T, r *Reporter, status string, cells ...storage.TestVM) (int64, []int64) {
+	t.Helper()
+	buildID := newBuild(t, r.store, status)
+	var ids []int64
+	for _, cell := range cells {
+		id, err := r.store.AddTestVM(buildID, cell.Cell, cell.Required, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		if cell.Status == "PENDING" {
+			continue
+		}
+		if ok, err := r.store.UpdateTestVMStatus(id, "PENDING", cell.Status); err != nil || !ok {
+			t.Fatalf("set %s: %v", cell.Status, err)
+		}
+	}
+	return buildID, ids
+}
+
+func buildStatus(t *testing.T, r *Reporter, buildID int64) string {
+	t.Helper()
+	status, _, err := r.store.GetBuildStatus(buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func vmStatus(t *testing.T, r *Reporter, id int64) string {
+	t.Helper()
+	vm, err := r.store.GetTestVM(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm.Status
+}
+
+func TestSettleRequiredFailure(t *testing.T) {
+	r := newTestReporter(t)
+	buildID, ids := matrixBuild(t, r, "WAITING_AGENT",
+		storage.TestVM{Cell: "bios", Required: true, Status: "WAITING_AGENT"},
+		storage.TestVM{Cell: "uefi", Required: true, Status: "WAITING_AGENT"},
+		storage.TestVM{Cell: "ipv6", Required: false, Status: "PENDING"},
+	)
+
+	var later followUp
+	if !r.setVMStatus(buildID, ids[0], "WAITING_AGENT", "ERROR_TEST", &later) {
+		t.Fatal("transition rejected")
+	}
+	if got := buildStatus(t, r, buildID); got != "ERROR_TEST" {
+		t.Errorf("build status %s, want ERROR_TEST", got)
+	}
+	// Остальные VM больше не нужны: promote не будет
+	for _, id := range ids[1:] {
+		if got := vmStatus(t, r, id); got != StatusCancelled {
+			t.Errorf("vm %d: %s, want %s", id, got, StatusCancelled)
+		}
+	}
+	// Серверов у VM нет — удалять нечего, остается только отзыв токена
+	if len(later) != 1 {
+		t.Errorf("got %d follow-up calls, want 1 (token release)", len(later))
+	}
+
+	// Отчет отмененной VM, пришедший позже, ничего не меняет
+	if r.setVMStatus(buildID, ids[1], "WAITING_AGENT", "SUCCESS", &later) {
+		t.Error("cancelled vm accepted a transition")
+	}
+}
+
+func TestSettleOptionalFailure(t *testing.T) {
+	r := newTestReporter(t)
+	buildID, ids := matrixBuild(t, r, "WAITING_AGENT",
+		storage.TestVM{Cell: "bios", Required: true, Status: "WAITING_AGENT"},
+		storage.TestVM{Cell: "ipv6", Required: false, Status: "WAITING_AGENT"},
+	)
+
+	var later followUp
+	r.setVMStatus(buildID, ids[1], "WAITING_AGENT", "ERROR_TEST", &later)
+	if got := buildStatus(t, r, buildID); got != "WAITING_AGENT" {
+		t.Errorf("build status %s after optional failure, want WAITING_AGENT", got)
+	}
+	if got := vmStatus(t, r, ids[0]); got != "WAITING_AGENT" {
+		t.Errorf("required vm: %s, want WAITING_AGENT", got)
+	}
+
+	// Обязательная прошла — выпуск несмотря на упавшую необязательную
+	r.setVMStatus(buildID, ids[0], "WAITING_AGENT", "SUCCESS", &later)
+	if got := buildStatus(t, r, buildID); got != StatusPromoting {
+		t.Errorf("build status %s, want %s", got, StatusPromoting)
+	}
+	if len(later) != 1 {
+		t.Errorf("got %d follow-up calls, want 1 (promote)", len(later))
+	}
+}
+
+// VM одной сборки, прошедшие одновременно, выпускают образ один раз.
+func TestSettlePromotesOnce(t *testing.T) {
+	r := newTestReporter(t)
+	buildID, ids := matrixBuild(t, r, "WAITING_AGENT",
+		storage.TestVM{Cell: "bios", Required: true, Status: "SUCCESS"},
+		storage.TestVM{Cell: "uefi", Required: true, Status: "SUCCESS"},
+	)
+
+	var first, second followUp
+	r.settle(buildID, &first)
+	r.settle(buildID, &second)
+	if len(first) != 1 || len(second) != 0 {
+		t.Errorf("promote scheduled %d and %d times, want 1 and 0", len(first), len(second))
+	}
+	if got := buildStatus(t, r, buildID); got != StatusPromoting {
+		t.Errorf("build status %s, want %s", got, StatusPromoting)
+	}
+
+	// Итоговый статус выставляет promote: settle его больше не трогает
+	if err := r.store.UpdateBuildStatus(buildID, "SUCCESS"); err != nil {
+		t.Fatal(err)
+	}
+	var late followUp
+	r.setVMStatus(buildID, ids[0], "SUCCESS", "TERMINATED", &late)
+	if got := buildStatus(t, r, buildID); got != "SUCCESS" || len(late) != 0 {
+		t.Errorf("settle after promote: %s, %d calls", got, len(late))
+	}
+}
+
+func TestSettleProgress(t *testing.T) {
+	r := newTestReporter(t)
+	buildID, ids := matrixBuild(t, r, "BOOTING_VM",
+		storage.TestVM{Cell: "bios", Required: true, Status: "PENDING"},
+		storage.TestVM{Cell: "uefi", Required: true, Status: "PENDING"},
+	)
+
+	if !r.SetVMStatus(buildID, ids[0], "PENDING", "BOOTING_VM") {
+		t.Fatal("transition rejected")
+	}
+	if r.SetVMStatus(buildID, ids[0], "PENDING", "BOOTING_VM") {
+		t.Error("repeated transition accepted")
+	}
+	r.SetVMStatus(buildID, ids[0], "BOOTING_VM", "WAITING_AGENT")
+	if got := buildStatus(t, r, buildID); got != "WAITING_AGENT" {
+		t.Errorf("build status %s, want WAITING_AGENT", got)
+	}
+
+	// Предупреждение watchdog по обязательной VM видно в статусе сборки, но не роняет ее
+	r.SetVMStatus(buildID, ids[0], "WAITING_AGENT", "ERROR_TIMEOUT")
+	if got := buildStatus(t, r, buildID); got != "ERROR_TIMEOUT" {
+		t.Errorf("build status %s, want ERROR_TIMEOUT", got)
+	}
+	r.SetVMStatus(buildID, ids[0], "ERROR_TIMEOUT", "WAITING_AGENT")
+	if got := buildStatus(t, r, buildID); got != "WAITING_AGENT" {
+		t.Errorf("build status %s after the agent came back, want WAITING_AGENT", got)
+	}
+	if got := vmStatus(t, r, ids[1]); got != "PENDING" {
+		t.Errorf("second vm: %s, want PENDING", got)
+	}
+}
+
+func TestBuildFinished(t *testing.T) {
+	vm := func(status string) []storage.TestVM {
+		return []storage.TestVM{{Status: status, Required: true}}
+	}
+	tests := []struct {
+		status string
+		vms    []storage.TestVM
+		want   bool
+	}{
+		{"SUCCESS", nil, true},
+		{"NO_CHANGE", nil, true},
+		{"ERROR_TEST", nil, true},
+		{"ERROR_SOAK", nil, true},
+		{"WAITING_AGENT", nil, false},
+		{StatusSoaking, nil, false},
+		{StatusPromoting, nil, false},
+		{"ERROR_TIMEOUT", vm("ERROR_TIMEOUT"), false},
+		{"ERROR_TIMEOUT", vm(StatusTerminated), true},
+		{"ERROR_TIMEOUT", nil, true},
+	}
+	for _, tt := range tests {
+		if got := BuildFinished(tt.status, tt.vms); got != tt.want {
+			t.Errorf("BuildFinished(%s, %v) = %v, want %v", tt.status, tt.vms, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/config"
+	"image-manager/internal/storage"
+)
+
+// Promoter делает кандидата боевым образом: переименование, шаринг потребителям, публикация.
+type Promoter struct {
+	log       *slog.Logger
+	store     *storage.Storage
+	osClient  *openstack.Client
+	publisher *Publisher
+}
+
+func NewPromoter(log *slog.Logger, store *storage.Storage, osc *openstack.Client, publisher *Publisher) *Promoter {
+	return &Promoter{
+		log:       log,
+		store:     store,
+		osClient:  osc,
+		publisher: publisher,
+	}
+}
+
+// Promote заменяет боевой образ кандидатом сборки.
+// Шаринг и публикация — best effort: их ошибки пишутся в лог сборки, но promote не откатывают.
+func (p *Promoter) Promote(buildID int64) error {
+	const op = "service.Promoter.Promote"
+
+	info, err := p.store.GetBuildInfo(buildID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Участники со старого образа переносятся внутри PromoteImage
+	if err := p.osClient.PromoteImage(info.GlanceID, info.ImageName); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	_ = p.store.AppendLog(buildID, fmt.Sprintf("Image promoted to production: %s (%s)", info.ImageName, info.GlanceID))
+
+	// Боевой образ больше не кандидат: сборщик мусора его не трогает
+	if err := p.store.ReleaseResource(ResourceImage, info.GlanceID); err != nil {
+		p.log.Error("failed to release promoted image from gc", slog.String("err", err.Error()))
+	}
+
+	p.applySharing(info)
+
+	// Раскатываем боевой образ по остальным регионам/проектам
+	go p.publisher.PublishBuild(buildID)
+
+	return nil
+}
+
+// applySharing расшаривает образ проектам из политики дистрибутива.
+func (p *Promoter) applySharing(info *storage.BuildInfo) {
+	if info.Distro == "" {
+		return
+	}
+
+	distroCfg, err := config.LoadDistroConfig(info.Distro)
+	if err != nil {
+		p.log.Warn("sharing: failed to load distro config", slog.String("distro", info.Distro), slog.String("err", err.Error()))
+		return
+	}
+
+	for _, project := range distroCfg.Sharing.Consumers {
+		if err := p.osClient.AddMember(info.GlanceID, project); err != nil {
+			p.log.Error("sharing: failed to add member", slog.String("project", project), slog.String("err", err.Error()))
+			_ = p.store.AppendLog(info.ID, fmt.Sprintf("Failed to share image with project %s: %s", project, err.Error()))
+			continue
+		}
+		_ = p.store.AppendLog(info.ID, fmt.Sprintf("Image shared with project %s (waiting for acceptance)", project))
+	}
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/config"
+	"image-manager/internal/storage"
+)
+
+// Статусы публикации в цель.
+const (
+	PublishPending    = "PENDING"
+	PublishInProgress = "PUBLISHING"
+	PublishDone       = "PUBLISHED"
+	PublishFailed     = "FAILED"
+)
+
+// Publisher копирует боевой образ во все регионы/проекты из publish-targets.yaml.
+type Publisher struct {
+	log    *slog.Logger
+	store  *storage.Storage
+	source *openstack.Client
+	cfg    *config.PublishConfig
+
+	mu      sync.Mutex
+	clients map[string]*openstack.Client // Клиенты целей создаются лениво и кешируются
+}
+
+func NewPublisher(log *slog.Logger, store *storage.Storage, source *openstack.Client, cfg *config.PublishConfig) *Publisher {
+	return &Publisher{
+		log:     log,
+		store:   store,
+		source:  source,
+		cfg:     cfg,
+		clients: make(map[string]*openstack.Client),
+	}
+}
+
+// Enabled сообщает, настроена ли хотя бы одна цель.
+func (p *Publisher) Enabled() bool {
+	return len(p.cfg.Targets) > 0
+}
+
+// PublishBuild публикует образ сборки во все цели. Блокирующий вызов.
+func (p *Publisher) PublishBuild(buildID int64) {
+	if !p.Enabled() {
+		return
+	}
+
+	for _, t := range p.cfg.Targets {
+		_ = p.store.SetPublishTarget(buildID, storage.PublishTarget{
+			Target:    t.Name,
+			Region:    t.Region,
+			ProjectID: t.ProjectID,
+			Status:    PublishPending,
+		})
+	}
+
+	p.publish(buildID, p.cfg.Targets)
+}
+
+// RetryFailed повторяет публикацию в цели, завершившиеся ошибкой.
+// Возвращает количество целей, поставленных на повтор; сама публикация идет в фоне.
+func (p *Publisher) RetryFailed(buildID int64) (int, error) {
+	const op = "service.Publisher.RetryFailed"
+
+	states, err := p.store.GetPublishTargets(buildID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	failed := make(map[string]bool)
+	for _, st := range states {
+		if st.Status == PublishFailed {
+			failed[st.Target] = true
+		}
+	}
+
+	var targets []config.PublishTarget
+	for _, t := range p.cfg.Targets {
+		if failed[t.Name] {
+			targets = append(targets, t)
+		}
+	}
+
+	if len(targets) > 0 {
+		go p.publish(buildID, targets)
+	}
+	return len(targets), nil
+}
+
+func (p *Publisher) publish(buildID int64, targets []config.PublishTarget) {
+	info, err := p.store.GetBuildInfo(buildID)
+	if err != nil {
+		p.log.Error("publish: build not found", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+		return
+	}
+
+	props, err := p.source.GetImagePropertiesByID(info.GlanceID)
+	if err != nil {
+		p.log.Warn("publish: failed to read source image properties", slog.String("err", err.Error()))
+	}
+	// Копии в целях не принадлежат пайплайну: без меток их не тронет сборщик мусора
+	delete(props, TagOwner)
+	delete(props, TagBuildID)
+
+	for _, t := range targets {
+		st := storage.PublishTarget{Target: t.Name, Region: t.Region, ProjectID: t.ProjectID, Status: PublishInProgress}
+		_ = p.store.SetPublishTarget(buildID, st)
+		_ = p.store.AppendLog(buildID, fmt.Sprintf("Publishing to %s (region %s, project %s)...", t.Name, t.Region, t.ProjectID))
+
+		imageID, err := p.publishTo(t, info, props)
+		if err != nil {
+			p.log.Error("publish failed", slog.String("target", t.Name), slog.String("err", err.Error()))
+			st.Status = PublishFailed
+			st.Error = err.Error()
+			_ = p.store.SetPublishTarget(buildID, st)
+			_ = p.store.AppendLog(buildID, fmt.Sprintf("Publish to %s failed: %s", t.Name, err.Error()))
+			continue
+		}
+
+		st.Status = PublishDone
+		st.ImageID = imageID
+		_ = p.store.SetPublishTarget(buildID, st)
+		_ = p.store.AppendLog(buildID, fmt.Sprintf("Published to %s. Image ID: %s", t.Name, imageID))
+	}
+}
+
+// publishTo копирует образ в цель: качает из исходного Glance и стримит в целевой.
+// Старый образ с тем же именем в цели заменяется так же, как при обычном promote.
+func (p *Publisher) publishTo(t config.PublishTarget, info *storage.BuildInfo, props map[string]string) (string, error) {
+	client, err := p.client(t)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := p.source.DownloadImage(info.GlanceID)
+	if err != nil {
+		return "", err
+	}
+	defer data.Close()
+
+	tmpName := info.ImageName + "-publishing"
+	_ = client.DeleteImageByName(tmpName)
+
+	imageID, err := client.UploadImageData(data, tmpName, t.Visibility, props)
+	if err != nil {
+		return "", err
+	}
+
+	if err := client.PromoteImage(imageID, info.ImageName); err != nil {
+		return imageID, err
+	}
+	return imageID, nil
+}
+
+func (p *Publisher) client(t config.PublishTarget) (*openstack.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.clients[t.Name]; ok {
+		return c, nil
+	}
+
+	prof := p.cfg.Profiles[t.Profile]
+	c, err := openstack.NewClient(p.log, prof.AuthURL, prof.Username, prof.Password, t.ProjectID, "", prof.DomainName, t.Region, "")
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", t.Name, err)
+	}
+	p.clients[t.Name] = c
+	return c, nil
+}
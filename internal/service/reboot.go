@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"image-manager/internal/config"
+	"image-manager/internal/storage"
+	pb "image-manager/pkg/pb"
+)
+
+// CommandReboot — команда агенту оставить маркер и перезагрузить VM (проверка второй загрузки).
+const CommandReboot = "REBOOT"
+
+// Номер загрузки, после которой при reboot_test сборку можно выпускать
+const finalBoot = 2
+
+// bootPrefix — префикс имен проверок второй загрузки, чтобы они не затирали результаты первой.
+func bootPrefix(boot int32) string {
+	if boot < finalBoot {
+		return ""
+	}
+	return fmt.Sprintf("boot%d/", boot)
+}
+
+// rebootStage решает, что делать с отчетом при reboot_test в конфиге дистрибутива (nil — конфига нет).
+// true — первая загрузка прошла и агенту нужно ответить REBOOT (promote — после второй).
+// Отчет, который нельзя засчитать, помечается неуспешным.
+func (r *Reporter) rebootStage(distroCfg *config.DistroConfig, vm *storage.TestVM, req *pb.StatusRequest) bool {
+	if !req.Success || distroCfg == nil || !distroCfg.RebootTest {
+		return false
+	}
+
+	fail := func(reason string) bool {
+		req.Success = false
+		req.Details = strings.TrimPrefix(req.Details+"; "+reason, "; ")
+		return false
+	}
+
+	switch {
+	case req.Boot == 0:
+		// Агент без поддержки REBOOT: вторую загрузку проверить нечем, а выпускать без нее нельзя
+		return fail("reboot test: agent does not support reboot")
+	case req.Boot < finalBoot:
+		return true
+	}
+
+	boots, err := r.store.GetBootsPassed(vm.ID)
+	if err != nil {
+		r.log.Error("reboot test: failed to get boots", slog.Int64("build_id", vm.BuildID), slog.String("err", err.Error()))
+	}
+	if boots < 1 {
+		return fail(fmt.Sprintf("reboot test: report for boot %d without a passed first boot", req.Boot))
+	}
+	return false
+}
+
+// requestReboot фиксирует прошедшую первую загрузку и отвечает агенту REBOOT.
+// Токен не отзываем: после перезагрузки агент отчитывается им же.
+func (r *Reporter) requestReboot(buildID int64, vm *storage.TestVM, req *pb.StatusRequest) *pb.StatusResponse {
+	if err := r.store.SetBootsPassed(vm.ID, int(req.Boot)); err != nil {
+		r.log.Error("failed to save boots passed", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+	}
+	r.log.Info("first boot passed, rebooting test VM", slog.Int64("build_id", buildID), slog.String("vm_id", req.VmId))
+	_ = r.store.AppendLog(buildID, LogPrefix(vm.Cell)+"First boot passed. Rebooting VM to verify the second boot...")
+	return &pb.StatusResponse{Command: CommandReboot}
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/config"
+	"image-manager/internal/storage"
+	pb "image-manager/pkg/pb"
+	"image-manager/pkg/serialreport"
+)
+
+// ErrUnknownVM — отчет пришел от VM, которой нет ни в одной сборке.
+var ErrUnknownVM = errors.New("no build found for vm")
+
+// Reporter обрабатывает отчеты агента. Единая точка для всех каналов доставки:
+// gRPC ReportStatus, REST /api/agent/report и серийная консоль VM идут через Process.
+type Reporter struct {
+	log      *slog.Logger
+	store    *storage.Storage
+	osClient *openstack.Client
+	promoter *Promoter
+	console  *ConsoleCollector
+	gc       *GarbageCollector
+	certs    *CertIssuer // nil — mTLS выключен
+
+	vmLocks sync.Map // ID тестовой VM -> *sync.Mutex: отчет одной VM может прийти по двум каналам одновременно
+
+	mu      sync.Mutex       // Только для uploads
+	uploads map[int64][]byte // Незавершенные загрузки диагностики по тестовым VM
+}
+
+// lockVM блокирует тестовую VM на время решения по ее отчету. Другие VM и сборки не ждут:
+// их статусы меняются через CAS (UpdateTestVMStatus), а медленные вызовы Nova/Glance
+// идут после снятия блокировки (followUp).
+func (r *Reporter) lockVM(testVMID int64) (unlock func()) {
+	v, _ := r.vmLocks.LoadOrStore(testVMID, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// followUp — отложенные вызовы OpenStack (консоль, удаление VM, promote): решение о переходе
+// статуса принимается под блокировкой VM, а сами вызовы выполняются после нее.
+type followUp []func()
+
+func (f *followUp) add(fn func()) {
+	*f = append(*f, fn)
+}
+
+func (f *followUp) run() {
+	for _, fn := range *f {
+		fn()
+	}
+}
+
+func NewReporter(log *slog.Logger, store *storage.Storage, osc *openstack.Client, promoter *Promoter, console *ConsoleCollector, gc *GarbageCollector, certs *CertIssuer) *Reporter {
+	return &Reporter{
+		log:      log,
+		store:    store,
+		osClient: osc,
+		promoter: promoter,
+		console:  console,
+		gc:       gc,
+		certs:    certs,
+		uploads:  make(map[int64][]byte),
+	}
+}
+
+// acceptsReport — статусы тестовой VM, в которых отчет агента еще имеет смысл.
+// ERROR_TIMEOUT тоже: агента могли запустить руками после предупреждения watchdog.
+func acceptsReport(status string) bool {
+	return status == "BOOTING_VM" || status == "WAITING_AGENT" || status == "ERROR_TIMEOUT"
+}
+
+// Process обрабатывает отчет агента и возвращает команду для него.
+func (r *Reporter) Process(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	buildInfo, err := r.authenticate(ctx, req.VmId, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	return r.handle(buildInfo, req)
+}
+
+// handle применяет отчет агента уже проверенной сборки: токеном (gRPC, REST)
+// или подписью ключом от токена (серийная консоль).
+func (r *Reporter) handle(buildInfo *storage.BuildInfo, req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	vm, err := r.store.GetTestVMByVMID(req.VmId)
+	if err != nil {
+		return nil, fmt.Errorf("service.Process: %w", err)
+	}
+	flavor := r.serverFlavor(req)
+
+	// defer выполняются в обратном порядке: сначала снимается блокировка, потом вызовы OpenStack
+	var later followUp
+	defer later.run()
+	defer r.lockVM(vm.ID)()
+
+	// Пока ждали блокировку, отчет по второму каналу мог уже все решить
+	if vm, err = r.store.GetTestVM(vm.ID); err != nil {
+		return nil, fmt.Errorf("service.Process: %w", err)
+	}
+
+	// Повторный отчет (ретрай агента или второй канал) не должен второй раз засчитываться
+	if !acceptsReport(vm.Status) {
+		r.log.Info("report ignored: test vm already finished", slog.Int64("build_id", buildInfo.ID), slog.String("status", vm.Status))
+		// По умолчанию говорим: "Жди"
+		command := "WAIT"
+		if vm.Status == "SUCCESS" || vm.Status == StatusCancelled {
+			command = "SHUTDOWN"
+		}
+		return &pb.StatusResponse{Command: command}, nil
+	}
+
+	r.touch(buildInfo.ID, vm, PhaseTestsDone)
+	r.applyFacts(buildInfo, vm, req, flavor)
+	distroCfg := r.distroConfig(buildInfo)
+	reboot := r.rebootStage(distroCfg, vm, req)
+	r.recordResults(buildInfo.ID, vm.Cell, req)
+
+	// reboot_test: первая загрузка прошла — выпуск только после проверки второй
+	if reboot {
+		return r.requestReboot(buildInfo.ID, vm, req), nil
+	}
+
+	if !req.Success {
+		r.log.Warn("Test FAILED. Keeping VM for debug.", slog.String("cell", vm.Cell), slog.String("details", req.Details))
+		return r.fail(buildInfo.ID, vm, "ERROR_TEST", &later), nil
+	}
+
+	// soak_minutes: проверки прошли, но выпуск — только после окна наблюдения
+	if distroCfg != nil && distroCfg.SoakMinutes > 0 {
+		return r.startSoak(buildInfo.ID, vm, time.Duration(distroCfg.SoakMinutes)*time.Minute, &later), nil
+	}
+
+	r.log.Info("Test PASSED.", slog.String("id", req.VmId), slog.String("cell", vm.Cell))
+	return r.pass(buildInfo.ID, vm, &later), nil
+}
+
+// fail помечает тестовую VM упавшей (ERROR_TEST, ERROR_SOAK), оставляет ее для отладки
+// и просит агента собрать диагностику. Упавшая обязательная VM роняет сборку.
+func (r *Reporter) fail(buildID int64, vm *storage.TestVM, status string, later *followUp) *pb.StatusResponse {
+	// ОБНОВЛЯЕМ СТАТУС НА ОШИБКУ
+	if r.setVMStatus(buildID, vm.ID, vm.Status, status, later) {
+		later.add(func() { r.console.Capture(buildID, vm.Cell, vm.VMID, status) })
+	}
+
+	// Не удаляем VM, чтобы админ мог зайти и посмотреть.
+	// Токен не отзываем: агент остается на связи и по нему заливает диагностику
+	// (повторный отчет по нему уже ничего не изменит — VM завершена).
+	if !r.expectDiagnostics(vm.ID) {
+		return &pb.StatusResponse{Command: "WAIT"} // VM отменили раньше отчета
+	}
+	_ = r.store.AppendLog(buildID, LogPrefix(vm.Cell)+"Requesting diagnostics bundle from agent...")
+	return diagnosticsResponse()
+}
+
+// distroConfig — конфиг дистрибутива сборки (nil — сборка без дистрибутива или конфиг не читается).
+func (r *Reporter) distroConfig(buildInfo *storage.BuildInfo) *config.DistroConfig {
+	if buildInfo.Distro == "" {
+		return nil
+	}
+	distroCfg, err := config.LoadDistroConfig(buildInfo.Distro)
+	if err != nil {
+		r.log.Warn("failed to load distro config", slog.String("distro", buildInfo.Distro), slog.String("err", err.Error()))
+		return nil
+	}
+	return distroCfg
+}
+
+// authenticate находит сборку VM и проверяет, что запрос пришел от ее агента.
+func (r *Reporter) authenticate(ctx context.Context, vmID, token string) (*storage.BuildInfo, error) {
+	buildInfo, err := r.store.GetBuildInfoByVMID(vmID)
+	if err != nil {
+		r.log.Warn("report for unknown vm", slog.String("vm_id", vmID), slog.String("err", err.Error()))
+		return nil, fmt.Errorf("%w %s", ErrUnknownVM, vmID)
+	}
+
+	// Знать UUID VM недостаточно: запрос должен нести токен, выданный этой сборке
+	if err := r.verifyToken(buildInfo.ID, token); err != nil {
+		r.log.Warn("agent request rejected", slog.Int64("build_id", buildInfo.ID), slog.String("vm_id", vmID), slog.String("err", err.Error()))
+		_ = r.store.AppendLog(buildInfo.ID, "Agent request rejected: invalid or revoked token.")
+		return nil, err
+	}
+
+	// mTLS: сертификат выпущен для конкретной сборки, чужой VM им не отчитаться. С включенным
+	// mTLS одного токена мало: запрос без сертификата (REST-канал) не принимается
+	certBuild, ok := clientBuildID(ctx)
+	switch {
+	case ok && certBuild != buildInfo.ID:
+		r.log.Warn("agent request rejected: client certificate of another build",
+			slog.Int64("build_id", buildInfo.ID),
+			slog.Int64("cert_build_id", certBuild),
+		)
+		return nil, ErrInvalidToken
+	case !ok && r.certs != nil:
+		r.log.Warn("agent request rejected: no client certificate", slog.Int64("build_id", buildInfo.ID), slog.String("vm_id", vmID))
+		_ = r.store.AppendLog(buildInfo.ID, "Agent request rejected: mTLS is enabled, request without a client certificate.")
+		return nil, ErrInvalidToken
+	}
+
+	return buildInfo, nil
+}
+
+// processSerial обрабатывает отчет из консоли VM. Токена в нем нет: подлинность
+// уже подтверждена подписью ключом сборки.
+func (r *Reporter) processSerial(vmID string, req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	buildInfo, err := r.store.GetBuildInfoByVMID(vmID)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s", ErrUnknownVM, vmID)
+	}
+	return r.handle(buildInfo, req)
+}
+
+// WatchConsole опрашивает консоль тестовой VM в поисках подписанного отчета агента
+// (запасной канал, когда из сети VM не достучаться до gRPC). Блокирующий вызов:
+// выходит, когда сборка перестала ждать отчет, истек timeout или отчет обработан.
+func (r *Reporter) WatchConsole(buildID int64, vmID string, interval, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		vm, err := r.store.GetTestVMByVMID(vmID)
+		if err != nil || !acceptsReport(vm.Status) {
+			return
+		}
+
+		out, err := r.osClient.GetConsoleOutput(vmID, 0)
+		if err != nil {
+			if openstack.IsNotFound(err) {
+				return
+			}
+			r.log.Debug("console poll failed", slog.String("vm_id", vmID), slog.String("err", err.Error()))
+			continue
+		}
+
+		// Ключ — хеш токена сборки; токен отозван — отчеты по нему больше не принимаются
+		key, err := r.reportKey(buildID)
+		if err != nil {
+			r.log.Debug("serial report key unavailable", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+			return
+		}
+
+		if req := r.consoleReport(out, key, vmID); req != nil {
+			r.log.Info("agent report received via serial console", slog.String("vm_id", vmID), slog.Bool("success", req.Success))
+			_ = r.store.AppendLog(buildID, LogPrefix(vm.Cell)+"Agent report received via serial console (gRPC unreachable from VM).")
+
+			resp, err := r.processSerial(vmID, req)
+			if err != nil {
+				r.log.Error("failed to process serial report", slog.String("err", err.Error()))
+				return
+			}
+			// Ответа через консоль нет: агент не узнает о REBOOT или SOAK, следующий этап не пройти
+			if resp.Command == CommandReboot || resp.Command == CommandSoak {
+				// После REBOOT VM все еще ждет агента, после SOAK — в наблюдении
+				from := vm.Status
+				if resp.Command == CommandSoak {
+					from = StatusSoaking
+				}
+				if r.SetVMStatus(buildID, vm.ID, from, "ERROR_TEST") {
+					_ = r.store.AppendLog(buildID, LogPrefix(vm.Cell)+fmt.Sprintf("%s needs the agent to reach the manager: the command cannot be delivered via serial console.", resp.Command))
+					r.console.Capture(buildID, vm.Cell, vmID, "ERROR_TEST")
+				}
+			}
+			return
+		}
+	}
+}
+
+// consoleReport ищет в выводе консоли VM первый отчет с верной подписью про эту VM (nil — нет).
+func (r *Reporter) consoleReport(output string, key []byte, vmID string) *pb.StatusRequest {
+	for _, req := range serialreport.Scan(output, key) {
+		// Отчет из консоли этой VM обязан быть про эту VM
+		if req.VmId != vmID {
+			r.log.Warn("serial report with foreign vm_id ignored", slog.String("vm_id", vmID), slog.String("reported", req.VmId))
+			continue
+		}
+		return req
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pb "image-manager/pkg/pb"
+	"image-manager/pkg/serialreport"
+)
+
+// newTestReporter — Reporter без OpenStack: GC только отзывает токены и ведет БД.
+func newTestReporter(t *testing.T) *Reporter {
+	t.Helper()
+	store := newTestStore(t)
+	gc := NewGarbageCollector(discardLogger(), store, nil, "test", time.Hour)
+	return NewReporter(discardLogger(), store, nil, nil, nil, gc, nil)
+}
+
+// Агент подписывает отчет ключом от токена из user data, менеджер проверяет ключом из БД.
+func TestConsoleReport(t *testing.T) {
+	r := newTestReporter(t)
+	buildID := newBuild(t, r.store, "WAITING_AGENT")
+	token, err := r.IssueToken(buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := r.reportKey(buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(vmID string, key []byte) string {
+		t.Helper()
+		line, err := serialreport.Encode(&pb.StatusRequest{VmId: vmID, Success: true}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return line
+	}
+	agentKey := serialreport.TokenKey(token)
+
+	tests := []struct {
+		name   string
+		output []string
+		want   bool
+	}{
+		{"own report", []string{encode("vm-1", agentKey)}, true},
+		{"foreign vm_id", []string{encode("vm-2", agentKey)}, false},
+		{"foreign vm_id first", []string{encode("vm-2", agentKey), encode("vm-1", agentKey)}, true},
+		{"key of another build", []string{encode("vm-1", serialreport.TokenKey("other-token"))}, false},
+		{"no report", []string{"ubuntu login:"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := r.consoleReport(strings.Join(tt.output, "\n"), key, "vm-1")
+			if got := req != nil; got != tt.want {
+				t.Fatalf("report found = %v, want %v", got, tt.want)
+			}
+			if req != nil && req.VmId != "vm-1" {
+				t.Errorf("got report for %s", req.VmId)
+			}
+		})
+	}
+
+	// Отозванный токен — ключа больше нет, отчеты из консоли не принимаются
+	if err := r.store.RevokeAgentToken(buildID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reportKey(buildID); err == nil {
+		t.Error("expected no report key after revoke")
+	}
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"image-manager/internal/storage"
+	pb "image-manager/pkg/pb"
+)
+
+// StatusSoaking — проверки прошли, тестовая VM работает под наблюдением перед promote.
+const StatusSoaking = "SOAKING"
+
+// CommandSoak — команда агенту оставаться на связи и слать проверки здоровья.
+const CommandSoak = "SOAK"
+
+// startSoak открывает окно наблюдения за VM: она пройдет после него, если все heartbeat здоровы.
+func (r *Reporter) startSoak(buildID int64, vm *storage.TestVM, d time.Duration, later *followUp) *pb.StatusResponse {
+	if err := r.store.StartSoak(vm.ID, d); err != nil {
+		r.log.Error("failed to start soak", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+	}
+	r.log.Info("Test PASSED. Soaking before promotion", slog.Int64("build_id", buildID), slog.String("cell", vm.Cell), slog.Duration("duration", d))
+	_ = r.store.AppendLog(buildID, LogPrefix(vm.Cell)+fmt.Sprintf("Tests passed. Soaking for %s before promotion...", d))
+	r.setVMStatus(buildID, vm.ID, vm.Status, StatusSoaking, later)
+	return &pb.StatusResponse{Command: CommandSoak, SoakSeconds: int32(d.Seconds())}
+}
+
+// soakHeartbeat обрабатывает сообщение агента во время наблюдения. Первый нездоровый
+// heartbeat роняет VM, здоровый после конца окна — засчитывает ей успех.
+func (r *Reporter) soakHeartbeat(buildInfo *storage.BuildInfo, vm *storage.TestVM, req *pb.StatusRequest, later *followUp) (*pb.StatusResponse, error) {
+	soak, err := r.store.GetSoak(vm.ID)
+	if err != nil {
+		return nil, fmt.Errorf("service.soakHeartbeat: %w", err)
+	}
+	if soak == nil {
+		return nil, fmt.Errorf("service.soakHeartbeat: vm %s is soaking without a soak window", vm.VMID)
+	}
+	prefix := LogPrefix(vm.Cell)
+	remaining := time.Until(soak.EndsAt)
+
+	// START в окне наблюдения — агент запущен заново, VM перезагрузилась сама (паника, watchdog)
+	if req.Phase == PhaseStart {
+		req.Success = false
+		req.Details = "agent restarted during soak (unexpected reboot?)"
+	}
+	// Обычный heartbeat (агент еще не перешел к наблюдению) ничего не решает
+	if req.Phase != PhaseSoak && req.Phase != PhaseStart {
+		return &pb.StatusResponse{Command: CommandSoak, SoakSeconds: int32(max(remaining, 0).Seconds())}, nil
+	}
+
+	if !req.Success {
+		r.log.Warn("Soak FAILED. Keeping VM for debug.", slog.Int64("build_id", buildInfo.ID), slog.String("details", req.Details))
+		_ = r.store.AppendLog(buildInfo.ID, prefix+fmt.Sprintf("Soak failed after %d healthy heartbeats (%s into the window).",
+			soak.Heartbeats, time.Since(soak.StartedAt).Round(time.Second)))
+		r.recordResults(buildInfo.ID, vm.Cell, req)
+		return r.fail(buildInfo.ID, vm, "ERROR_SOAK", later), nil
+	}
+
+	if err := r.store.RecordSoakHeartbeat(vm.ID); err != nil {
+		r.log.Error("failed to record soak heartbeat", slog.Int64("build_id", buildInfo.ID), slog.String("err", err.Error()))
+	}
+	if remaining > 0 {
+		return &pb.StatusResponse{Command: CommandSoak, SoakSeconds: int32(remaining.Seconds())}, nil
+	}
+
+	r.log.Info("Soak PASSED.", slog.Int64("build_id", buildInfo.ID), slog.String("cell", vm.Cell), slog.Int("heartbeats", soak.Heartbeats+1))
+	_ = r.store.AppendLog(buildInfo.ID, prefix+fmt.Sprintf("Soak passed: %d healthy heartbeats in %s.",
+		soak.Heartbeats+1, soak.EndsAt.Sub(soak.StartedAt).Round(time.Second)))
+	r.recordResults(buildInfo.ID, vm.Cell, req)
+	return r.pass(buildInfo.ID, vm, later), nil
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/storage"
+)
+
+// ErrNoSSHKey — у сборки нет своего ключа: VM шли с общим OS_SSH_KEY_NAME или пара уже удалена.
+var ErrNoSSHKey = errors.New("build has no ssh key")
+
+// SSHKeyVault выпускает на каждую сборку эфемерную ключевую пару Nova для тестовых VM
+// и хранит ее приватный ключ в БД зашифрованным (AES-256-GCM).
+// Пара удаляется сборщиком мусора вместе с последней VM сборки.
+type SSHKeyVault struct {
+	log      *slog.Logger
+	store    *storage.Storage
+	osClient *openstack.Client
+	gc       *GarbageCollector
+	aead     cipher.AEAD
+	volatile bool // Ключ шифрования сгенерирован при старте: после рестарта ключи не расшифровать
+}
+
+// NewSSHKeyVault создает хранилище ключей. encryptionKey — 32 байта в base64;
+// пустой — случайный ключ на время жизни процесса.
+func NewSSHKeyVault(log *slog.Logger, store *storage.Storage, osc *openstack.Client, gc *GarbageCollector, encryptionKey string) (*SSHKeyVault, error) {
+	const op = "service.NewSSHKeyVault"
+
+	key := make([]byte, 32)
+	volatile := encryptionKey == ""
+	if volatile {
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(encryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: encryption key is not base64: %w", op, err)
+		}
+		if len(decoded) != 32 {
+			return nil, fmt.Errorf("%s: encryption key must be 32 bytes, got %d", op, len(decoded))
+		}
+		key = decoded
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &SSHKeyVault{log: log, store: store, osClient: osc, gc: gc, aead: aead, volatile: volatile}, nil
+}
+
+// Volatile сообщает, что ключ шифрования не задан и приватные ключи не переживут рестарт.
+func (v *SSHKeyVault) Volatile() bool {
+	return v.volatile
+}
+
+// KeypairName — имя ключевой пары сборки в Nova (с владельцем: проект может быть общим).
+func (v *SSHKeyVault) KeypairName(buildID int64) string {
+	return fmt.Sprintf("%s-build-%d", v.gc.owner, buildID)
+}
+
+// Issue генерирует ключ сборки, регистрирует публичную часть в Nova и сохраняет
+// зашифрованную приватную. Возвращает имя ключевой пары для CreateVM.
+func (v *SSHKeyVault) Issue(buildID int64) (string, error) {
+	const op = "service.SSHKeyVault.Issue"
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	publicKey, err := authorizedKey(key)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	name := v.KeypairName(buildID)
+	if err := v.osClient.CreateKeypair(name, publicKey); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	v.gc.Track(buildID, ResourceKeypair, name, name)
+
+	if err := v.store.SetSSHKey(buildID, name, v.seal(name, privatePEM)); err != nil {
+		// Без приватного ключа пара бесполезна, VM пойдут с общим ключом
+		_ = v.gc.delete(ResourceKeypair, name)
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	v.log.Info("ssh keypair created", slog.Int64("build_id", buildID), slog.String("name", name))
+	return name, nil
+}
+
+// PrivateKey возвращает приватный ключ сборки в PEM.
+func (v *SSHKeyVault) PrivateKey(buildID int64) ([]byte, error) {
+	const op = "service.SSHKeyVault.PrivateKey"
+
+	name, encrypted, err := v.store.GetSSHKey(buildID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if name == "" || encrypted == nil {
+		return nil, ErrNoSSHKey
+	}
+
+	nonceSize := v.aead.NonceSize()
+	if len(encrypted) < nonceSize {
+		return nil, fmt.Errorf("%s: ciphertext too short", op)
+	}
+	plain, err := v.aead.Open(nil, encrypted[:nonceSize], encrypted[nonceSize:], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("%s: decrypt failed (encryption key changed?): %w", op, err)
+	}
+	return plain, nil
+}
+
+// seal шифрует приватный ключ: nonce || ciphertext. Имя пары — associated data,
+// чтобы зашифрованный ключ нельзя было подставить другой сборке.
+func (v *SSHKeyVault) seal(name string, plain []byte) []byte {
+	nonce := make([]byte, v.aead.NonceSize())
+	_, _ = rand.Read(nonce)
+	return v.aead.Seal(nonce, nonce, plain, []byte(name))
+}
+
+// authorizedKey кодирует публичный ключ в формат authorized_keys (RFC 5656):
+// "ecdsa-sha2-nistp256 <base64>".
+func authorizedKey(key *ecdsa.PrivateKey) (string, error) {
+	pub, err := key.PublicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+
+	const keyType = "ecdsa-sha2-nistp256"
+	var wire []byte
+	for _, field := range [][]byte{[]byte(keyType), []byte("nistp256"), pub.Bytes()} {
+		wire = binary.BigEndian.AppendUint32(wire, uint32(len(field)))
+		wire = append(wire, field...)
+	}
+	return keyType + " " + base64.StdEncoding.EncodeToString(wire), nil
+}
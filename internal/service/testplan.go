@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"image-manager/internal/adapter/openstack"
+	"image-manager/internal/config"
+	"image-manager/internal/storage"
+	pb "image-manager/pkg/pb"
+)
+
+// DefaultTests — проверки для дистрибутивов без секции tests (то, что агент делал всегда).
+var DefaultTests = []config.TestCheck{
+	{Name: "root-fs", Type: config.CheckCommand, Command: "ls /"},
+	{Name: "internet", Type: config.CheckCommand, Command: "ping -c 1 8.8.8.8"},
+}
+
+// TestPlan возвращает агенту план проверок для его сборки.
+func (r *Reporter) TestPlan(ctx context.Context, req *pb.TestPlanRequest) (*pb.TestPlan, error) {
+	buildInfo, err := r.authenticate(ctx, req.VmId, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := DefaultTests
+	var builtins []string
+	if buildInfo.Distro != "" {
+		distroCfg, err := config.LoadDistroConfig(buildInfo.Distro)
+		if err != nil {
+			// Конфиг прошел валидацию при сборке; если его сломали после — не молчим
+			r.log.Error("test plan: failed to load distro config", slog.String("distro", buildInfo.Distro), slog.String("err", err.Error()))
+			return nil, fmt.Errorf("service.Reporter.TestPlan: %w", err)
+		}
+		if len(distroCfg.Tests) > 0 {
+			checks = distroCfg.Tests
+		}
+		builtins = distroCfg.BuiltinChecks
+	}
+
+	plan := BuildTestPlan(checks, builtins)
+
+	// Размер диска флейвора нужен проверке ресайза корня
+	diskGB, err := r.osClient.GetServerDiskGB(req.VmId)
+	if err != nil {
+		r.log.Warn("test plan: failed to get flavor disk", slog.String("vm_id", req.VmId), slog.String("err", err.Error()))
+	}
+	// Загрузка с тома: у флейвора диска нет, корень — на томе заданного размера
+	if vm, err := r.store.GetTestVMByVMID(req.VmId); err == nil && diskGB == 0 {
+		var opts openstack.VMOptions
+		if json.Unmarshal(vm.Options, &opts) == nil {
+			diskGB = opts.BootVolumeGB
+		}
+	}
+	plan.RootDiskGb = int32(diskGB)
+
+	_ = r.store.AppendLog(buildInfo.ID, fmt.Sprintf("Test plan sent to agent: %d checks.", len(plan.Checks)))
+	return plan, nil
+}
+
+// BuildTestPlan переводит проверки из YAML в сообщение для агента.
+// builtins — имена встроенных проверок из builtin_checks, добавляются в конец плана.
+func BuildTestPlan(checks []config.TestCheck, builtins []string) *pb.TestPlan {
+	plan := &pb.TestPlan{}
+	for _, c := range checks {
+		check := &pb.Check{
+			Name:           c.Name,
+			Type:           c.Type,
+			Command:        c.Command,
+			Path:           c.Path,
+			Contains:       c.Contains,
+			Matches:        c.Matches,
+			Service:        c.Service,
+			Port:           int32(c.Port),
+			Protocol:       c.Protocol,
+			TimeoutSeconds: int32(c.Timeout.Seconds()),
+			Builtin:        c.Builtin,
+			Target:         c.Target,
+		}
+		for _, code := range c.ExpectExitCodes {
+			check.ExpectExitCodes = append(check.ExpectExitCodes, int32(code))
+		}
+		plan.Checks = append(plan.Checks, check)
+	}
+
+	for _, name := range builtins {
+		plan.Checks = append(plan.Checks, &pb.Check{Name: name, Type: config.CheckBuiltin, Builtin: name})
+	}
+	return plan
+}
+
+// Статусы результата проверки.
+const (
+	CheckPass  = "PASS"
+	CheckFail  = "FAIL"
+	CheckError = "ERROR"
+	CheckSkip  = "SKIP" // Проверка неприменима (например, неизвестен размер диска)
+)
+
+// recordResults пишет отчет агента тестовой VM ячейки cell в лог сборки и сохраняет результаты проверок.
+func (r *Reporter) recordResults(buildID int64, cell string, req *pb.StatusRequest) {
+	verdict := "PASSED"
+	if !req.Success {
+		verdict = "FAILED"
+	}
+
+	phase := req.Phase
+	if req.Boot >= finalBoot {
+		phase = fmt.Sprintf("%s, boot %d", phase, req.Boot)
+	}
+	prefix := bootPrefix(req.Boot)
+	if req.Phase == PhaseSoak {
+		prefix = "soak/" // Последний heartbeat наблюдения — рядом с результатами загрузок
+	}
+	prefix = CellPrefix(cell) + prefix
+
+	lines := []string{fmt.Sprintf("%sAgent report (%s): %s", LogPrefix(cell), phase, verdict)}
+	if req.Details != "" {
+		lines = append(lines, "Details: "+req.Details)
+	}
+
+	results := make([]storage.TestResult, 0, len(req.Results))
+	for _, res := range req.Results {
+		line := fmt.Sprintf("  [%s] %s%s (%dms)", res.Status, prefix, res.Name, res.DurationMs)
+		if res.Status != CheckPass && res.Message != "" {
+			line += ": " + res.Message
+		}
+		lines = append(lines, line)
+
+		results = append(results, storage.TestResult{
+			Name:       prefix + res.Name,
+			Status:     res.Status,
+			DurationMs: res.DurationMs,
+			Stdout:     res.Stdout,
+			Stderr:     res.Stderr,
+			Expected:   res.Expected,
+			Actual:     res.Actual,
+			Message:    res.Message,
+		})
+	}
+	_ = r.store.AppendLog(buildID, strings.Join(lines, "\n"))
+
+	// Старый агент присылает только details — таблицу не трогаем
+	if len(results) == 0 {
+		return
+	}
+	// Отчет второй загрузки дополняет результаты первой, а не заменяет их
+	if err := r.store.ReplaceTestResults(buildID, prefix, results); err != nil {
+		r.log.Error("failed to save test results", slog.Int64("build_id", buildID), slog.String("err", err.Error()))
+	}
+}
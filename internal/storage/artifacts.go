@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Artifact — файл, приложенный к сборке (например, консоль тестовой VM).
+type Artifact struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	CreatedAt   string `json:"created_at"`
+	Content     []byte `json:"-"`
+}
+
+// SaveArtifact сохраняет артефакт сборки. Артефакт с тем же именем перезаписывается.
+func (s *Storage) SaveArtifact(buildID int64, name, contentType string, content []byte) error {
+	query := `
+    INSERT INTO artifacts (build_id, name, content_type, content) VALUES (?, ?, ?, ?)
+    ON CONFLICT(build_id, name) DO UPDATE SET
+        content_type = excluded.content_type,
+        content = excluded.content,
+        created_at = CURRENT_TIMESTAMP`
+
+	if _, err := s.db.Exec(query, buildID, name, contentType, content); err != nil {
+		return fmt.Errorf("storage.SaveArtifact: %w", err)
+	}
+	return nil
+}
+
+// GetArtifact возвращает артефакт сборки вместе с содержимым.
+func (s *Storage) GetArtifact(buildID int64, name string) (*Artifact, error) {
+	query := `SELECT name, content_type, length(content), created_at, content FROM artifacts WHERE build_id = ? AND name = ?`
+
+	var a Artifact
+	err := s.db.QueryRow(query, buildID, name).Scan(&a.Name, &a.ContentType, &a.Size, &a.CreatedAt, &a.Content)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("artifact not found")
+		}
+		return nil, fmt.Errorf("storage.GetArtifact: %w", err)
+	}
+	return &a, nil
+}
+
+// ListArtifacts возвращает артефакты сборки без содержимого.
+func (s *Storage) ListArtifacts(buildID int64) ([]Artifact, error) {
+	query := `SELECT name, content_type, length(content), created_at FROM artifacts WHERE build_id = ? ORDER BY name`
+
+	rows, err := s.db.Query(query, buildID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.ListArtifacts: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Artifact{}
+	for rows.Next() {
+		var a Artifact
+		if err := rows.Scan(&a.Name, &a.ContentType, &a.Size, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("storage.ListArtifacts: %w", err)
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,89 @@
+package storage
+
+import "fmt"
+
+// RequestDiagnostics помечает, что агентам упавших тестовых VM сборки (ERROR_TEST, ERROR_SOAK)
+// нужно собрать и прислать диагностику. Возвращает число таких VM. После отзыва токена сборки
+// агентам не отчитаться — запрос не ставится.
+func (s *Storage) RequestDiagnostics(buildID int64) (int64, error) {
+	query := `
+    UPDATE test_vms SET diagnostics_requested = 1
+    WHERE build_id = ? AND status IN ('ERROR_TEST', 'ERROR_SOAK')
+      AND EXISTS (SELECT 1 FROM builds b WHERE b.id = test_vms.build_id AND coalesce(b.agent_token_hash, '') != '')`
+	res, err := s.db.Exec(query, buildID)
+	if err != nil {
+		return 0, fmt.Errorf("storage.RequestDiagnostics: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("storage.RequestDiagnostics: %w", err)
+	}
+	return n, nil
+}
+
+// TakeDiagnosticsRequest снимает пометку запроса диагностики с тестовой VM. true — запрос был
+// (отдать команду агенту нужно ровно один раз, даже если heartbeat пришли по двум каналам).
+func (s *Storage) TakeDiagnosticsRequest(testVMID int64) (bool, error) {
+	query := `UPDATE test_vms SET diagnostics_requested = 0 WHERE id = ? AND diagnostics_requested = 1`
+	res, err := s.db.Exec(query, testVMID)
+	if err != nil {
+		return false, fmt.Errorf("storage.TakeDiagnosticsRequest: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("storage.TakeDiagnosticsRequest: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ExpectDiagnostics отмечает, что агенту упавшей тестовой VM (ERROR_TEST, ERROR_SOAK) отдана
+// команда COLLECT_DIAGNOSTICS. false — VM уже не в этих статусах, команду отдавать не нужно.
+func (s *Storage) ExpectDiagnostics(testVMID int64) (bool, error) {
+	query := `UPDATE test_vms SET diagnostics_expected = 1 WHERE id = ? AND status IN ('ERROR_TEST', 'ERROR_SOAK')`
+	res, err := s.db.Exec(query, testVMID)
+	if err != nil {
+		return false, fmt.Errorf("storage.ExpectDiagnostics: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("storage.ExpectDiagnostics: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Тестовая VM v ждет архив диагностики: команда отдана, VM все еще упавшая и ее сервер не удален
+const diagnosticsExpectedCond = `v.diagnostics_expected = 1 AND v.status IN ('ERROR_TEST', 'ERROR_SOAK')
+      AND NOT EXISTS (
+        SELECT 1 FROM resources r
+        WHERE r.kind = 'server' AND r.cloud_id = v.vm_id AND r.deleted_at IS NOT NULL
+      )`
+
+// DiagnosticsExpected сообщает, ждет ли менеджер архив диагностики от агента тестовой VM:
+// команда отдана, VM все еще упавшая и ее сервер не удален.
+func (s *Storage) DiagnosticsExpected(testVMID int64) (bool, error) {
+	query := `SELECT count(*) FROM test_vms v WHERE v.id = ? AND ` + diagnosticsExpectedCond
+	var n int
+	if err := s.db.QueryRow(query, testVMID).Scan(&n); err != nil {
+		return false, fmt.Errorf("storage.DiagnosticsExpected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// AwaitingDiagnostics сообщает, ждет ли менеджер архив диагностики хотя бы от одной VM сборки.
+func (s *Storage) AwaitingDiagnostics(buildID int64) (bool, error) {
+	query := `SELECT count(*) FROM test_vms v WHERE v.build_id = ? AND ` + diagnosticsExpectedCond
+	var n int
+	if err := s.db.QueryRow(query, buildID).Scan(&n); err != nil {
+		return false, fmt.Errorf("storage.AwaitingDiagnostics: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ClearDiagnosticsExpected снимает ожидание архива диагностики (архив получен).
+func (s *Storage) ClearDiagnosticsExpected(testVMID int64) error {
+	query := `UPDATE test_vms SET diagnostics_expected = 0 WHERE id = ?`
+	if _, err := s.db.Exec(query, testVMID); err != nil {
+		return fmt.Errorf("storage.ClearDiagnosticsExpected: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// BuildFacts — факты о тестовой VM сборки, как их увидела гостевая ОС.
+type BuildFacts struct {
+	Flavor            string          `json:"flavor"`
+	VCPUs             int             `json:"vcpus"`
+	MemoryMB          int64           `json:"memory_mb"`
+	Kernel            string          `json:"kernel"`
+	Firmware          string          `json:"firmware"`
+	BootSeconds       float64         `json:"boot_seconds"`
+	AgentStartSeconds float64         `json:"agent_start_seconds"`
+	Details           json.RawMessage `json:"details"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
+
+// BootTimePoint — точка тренда времени загрузки.
+type BootTimePoint struct {
+	BuildID           int64     `json:"build_id"`
+	ImageName         string    `json:"image_name"`
+	Status            string    `json:"status"`
+	Flavor            string    `json:"flavor"`
+	Kernel            string    `json:"kernel"`
+	BootSeconds       float64   `json:"boot_seconds"`
+	AgentStartSeconds float64   `json:"agent_start_seconds"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// SaveFacts сохраняет факты сборки (повторный отчет перезаписывает).
+func (s *Storage) SaveFacts(buildID int64, f BuildFacts) error {
+	query := `
+    INSERT OR REPLACE INTO build_facts
+        (build_id, flavor, vcpus, memory_mb, kernel, firmware, boot_seconds, agent_start_seconds, details)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := s.db.Exec(query, buildID, f.Flavor, f.VCPUs, f.MemoryMB, f.Kernel, f.Firmware,
+		f.BootSeconds, f.AgentStartSeconds, string(f.Details))
+	if err != nil {
+		return fmt.Errorf("storage.SaveFacts: %w", err)
+	}
+	return nil
+}
+
+// GetFacts возвращает факты сборки. nil — агент их не присылал.
+func (s *Storage) GetFacts(buildID int64) (*BuildFacts, error) {
+	query := `
+    SELECT flavor, vcpus, memory_mb, kernel, firmware, boot_seconds, agent_start_seconds, details, created_at
+    FROM build_facts WHERE build_id = ?`
+
+	var f BuildFacts
+	var details, created string
+	err := s.db.QueryRow(query, buildID).Scan(&f.Flavor, &f.VCPUs, &f.MemoryMB, &f.Kernel, &f.Firmware,
+		&f.BootSeconds, &f.AgentStartSeconds, &details, &created)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("storage.GetFacts: %w", err)
+	}
+	f.Details = json.RawMessage(details)
+	f.CreatedAt = parseTime(created)
+	return &f, nil
+}
+
+// BootTimes возвращает время загрузки последних limit сборок дистрибутива (по возрастанию id).
+func (s *Storage) BootTimes(distro string, limit int) ([]BootTimePoint, error) {
+	query := `
+    SELECT b.id, b.image_name, b.status, f.flavor, f.kernel, f.boot_seconds, f.agent_start_seconds, f.created_at
+    FROM build_facts f JOIN builds b ON b.id = f.build_id
+    WHERE b.distro = ?
+    ORDER BY b.id DESC LIMIT ?`
+
+	rows, err := s.db.Query(query, distro, limit)
+	if err != nil {
+		return nil, fmt.Errorf("storage.BootTimes: %w", err)
+	}
+	defer rows.Close()
+
+	points := []BootTimePoint{}
+	for rows.Next() {
+		var p BootTimePoint
+		var created string
+		if err := rows.Scan(&p.BuildID, &p.ImageName, &p.Status, &p.Flavor, &p.Kernel,
+			&p.BootSeconds, &p.AgentStartSeconds, &created); err != nil {
+			return nil, fmt.Errorf("storage.BootTimes: %w", err)
+		}
+		p.CreatedAt = parseTime(created)
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.BootTimes: %w", err)
+	}
+
+	slices.Reverse(points)
+	return points, nil
+}
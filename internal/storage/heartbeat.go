@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Heartbeat — последнее известное состояние агента сборки (или одной тестовой VM).
+type Heartbeat struct {
+	Phase  string    `json:"phase"`
+	LastAt time.Time `json:"last_at"`
+}
+
+// RecordHeartbeat фиксирует этап, о котором сообщил агент тестовой VM testVMID, и время
+// сообщения — у VM (для watchdog) и у сборки (последний сигнал любого агента).
+func (s *Storage) RecordHeartbeat(buildID, testVMID int64, phase string) error {
+	query := `UPDATE test_vms SET agent_phase = ?, last_heartbeat = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := s.db.Exec(query, phase, testVMID); err != nil {
+		return fmt.Errorf("storage.RecordHeartbeat: %w", err)
+	}
+	query = `UPDATE builds SET agent_phase = ?, last_heartbeat = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := s.db.Exec(query, phase, buildID); err != nil {
+		return fmt.Errorf("storage.RecordHeartbeat: %w", err)
+	}
+	return nil
+}
+
+// GetHeartbeat возвращает последний heartbeat агента. Нулевое LastAt — агент еще не выходил на связь.
+func (s *Storage) GetHeartbeat(buildID int64) (Heartbeat, error) {
+	query := `SELECT coalesce(agent_phase, ''), coalesce(last_heartbeat, '') FROM builds WHERE id = ?`
+
+	var hb Heartbeat
+	var last string
+	if err := s.db.QueryRow(query, buildID).Scan(&hb.Phase, &last); err != nil {
+		if err == sql.ErrNoRows {
+			return hb, fmt.Errorf("build not found")
+		}
+		return hb, fmt.Errorf("storage.GetHeartbeat: %w", err)
+	}
+	if last != "" {
+		hb.LastAt = parseTime(last)
+	}
+	return hb, nil
+}
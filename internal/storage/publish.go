@@ -0,0 +1,58 @@
+package storage
+
+import "fmt"
+
+// PublishTarget — статус публикации образа сборки в одну из целей.
+type PublishTarget struct {
+	Target    string `json:"target"`
+	Region    string `json:"region"`
+	ProjectID string `json:"project_id"`
+	Status    string `json:"status"`
+	ImageID   string `json:"image_id"`
+	Error     string `json:"error"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// SetPublishTarget создает или обновляет статус публикации сборки в цель.
+func (s *Storage) SetPublishTarget(buildID int64, t PublishTarget) error {
+	query := `
+    INSERT INTO publish_targets (build_id, target, region, project_id, status, image_id, error)
+    VALUES (?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(build_id, target) DO UPDATE SET
+        region = excluded.region,
+        project_id = excluded.project_id,
+        status = excluded.status,
+        image_id = excluded.image_id,
+        error = excluded.error,
+        updated_at = CURRENT_TIMESTAMP`
+
+	_, err := s.db.Exec(query, buildID, t.Target, t.Region, t.ProjectID, t.Status, t.ImageID, t.Error)
+	if err != nil {
+		return fmt.Errorf("storage.SetPublishTarget: %w", err)
+	}
+	return nil
+}
+
+// GetPublishTargets возвращает статусы публикации сборки по всем целям.
+func (s *Storage) GetPublishTargets(buildID int64) ([]PublishTarget, error) {
+	query := `
+    SELECT target, coalesce(region, ''), coalesce(project_id, ''), status,
+           coalesce(image_id, ''), coalesce(error, ''), updated_at
+    FROM publish_targets WHERE build_id = ? ORDER BY target`
+
+	rows, err := s.db.Query(query, buildID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.GetPublishTargets: %w", err)
+	}
+	defer rows.Close()
+
+	var result []PublishTarget
+	for rows.Next() {
+		var t PublishTarget
+		if err := rows.Scan(&t.Target, &t.Region, &t.ProjectID, &t.Status, &t.ImageID, &t.Error, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("storage.GetPublishTargets: %w", err)
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "fmt"
+
+// SetBootsPassed запоминает, сколько загрузок тестовой VM прошли проверки.
+func (s *Storage) SetBootsPassed(testVMID int64, boots int) error {
+	query := `UPDATE test_vms SET boots_passed = ? WHERE id = ?`
+	if _, err := s.db.Exec(query, boots, testVMID); err != nil {
+		return fmt.Errorf("storage.SetBootsPassed: %w", err)
+	}
+	return nil
+}
+
+// GetBootsPassed возвращает число загрузок тестовой VM, прошедших проверки.
+func (s *Storage) GetBootsPassed(testVMID int64) (int, error) {
+	var boots int
+	query := `SELECT COALESCE(boots_passed, 0) FROM test_vms WHERE id = ?`
+	if err := s.db.QueryRow(query, testVMID).Scan(&boots); err != nil {
+		return 0, fmt.Errorf("storage.GetBootsPassed: %w", err)
+	}
+	return boots, nil
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Resource — облачный ресурс, созданный менеджером в рамках сборки.
+type Resource struct {
+	ID          int64     `json:"id"`
+	BuildID     int64     `json:"build_id"`
+	Kind        string    `json:"kind"`
+	CloudID     string    `json:"cloud_id"`
+	Name        string    `json:"name"`
+	BuildStatus string    `json:"build_status"`
+	BuildUpdate time.Time `json:"build_updated_at"`
+}
+
+// BuildState — статус сборки и время его последнего изменения.
+type BuildState struct {
+	Status    string
+	UpdatedAt time.Time
+	GlanceID  string
+}
+
+// AddResource регистрирует созданный ресурс.
+func (s *Storage) AddResource(buildID int64, kind, cloudID, name string) error {
+	query := `INSERT OR IGNORE INTO resources (build_id, kind, cloud_id, name) VALUES (?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, buildID, kind, cloudID, name); err != nil {
+		return fmt.Errorf("storage.AddResource: %w", err)
+	}
+	return nil
+}
+
+// ReleaseResource исключает ресурс из сборки мусора (например, образ стал боевым).
+func (s *Storage) ReleaseResource(kind, cloudID string) error {
+	query := `UPDATE resources SET released = 1 WHERE kind = ? AND cloud_id = ?`
+	if _, err := s.db.Exec(query, kind, cloudID); err != nil {
+		return fmt.Errorf("storage.ReleaseResource: %w", err)
+	}
+	return nil
+}
+
+// MarkResourceDeleted отмечает, что ресурс удален из облака.
+func (s *Storage) MarkResourceDeleted(kind, cloudID string) error {
+	query := `UPDATE resources SET deleted_at = CURRENT_TIMESTAMP WHERE kind = ? AND cloud_id = ? AND deleted_at IS NULL`
+	if _, err := s.db.Exec(query, kind, cloudID); err != nil {
+		return fmt.Errorf("storage.MarkResourceDeleted: %w", err)
+	}
+	return nil
+}
+
+// IsResourceTracked сообщает, известен ли ресурс базе (в любом состоянии).
+func (s *Storage) IsResourceTracked(kind, cloudID string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT count(*) FROM resources WHERE kind = ? AND cloud_id = ?`, kind, cloudID).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("storage.IsResourceTracked: %w", err)
+	}
+	return n > 0, nil
+}
+
+// IsResourceLive сообщает, что ресурс записан в базу и еще не удален из облака.
+func (s *Storage) IsResourceLive(kind, cloudID string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT count(*) FROM resources WHERE kind = ? AND cloud_id = ? AND deleted_at IS NULL`, kind, cloudID).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("storage.IsResourceLive: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ListLiveResources возвращает неудаленные и не-боевые ресурсы вместе со статусом их сборки.
+func (s *Storage) ListLiveResources() ([]Resource, error) {
+	query := `
+    SELECT r.id, r.build_id, r.kind, r.cloud_id, coalesce(r.name, ''),
+           b.status, coalesce(b.updated_at, b.created_at)
+    FROM resources r JOIN builds b ON b.id = r.build_id
+    WHERE r.deleted_at IS NULL AND r.released = 0
+    ORDER BY r.id`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("storage.ListLiveResources: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Resource
+	for rows.Next() {
+		var r Resource
+		var updated string
+		if err := rows.Scan(&r.ID, &r.BuildID, &r.Kind, &r.CloudID, &r.Name, &r.BuildStatus, &updated); err != nil {
+			return nil, fmt.Errorf("storage.ListLiveResources: %w", err)
+		}
+		r.BuildUpdate = parseTime(updated)
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// GetBuildState возвращает статус сборки и время его последнего изменения.
+func (s *Storage) GetBuildState(id int64) (*BuildState, error) {
+	query := `SELECT status, coalesce(updated_at, created_at), coalesce(glance_id, '') FROM builds WHERE id = ?`
+
+	var st BuildState
+	var updated string
+	err := s.db.QueryRow(query, id).Scan(&st.Status, &updated, &st.GlanceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("build not found")
+		}
+		return nil, fmt.Errorf("storage.GetBuildState: %w", err)
+	}
+	st.UpdatedAt = parseTime(updated)
+	return &st, nil
+}
+
+// parseTime разбирает время из SQLite. CURRENT_TIMESTAMP пишет UTC в формате "2006-01-02 15:04:05",
+// а драйвер для колонок DATETIME может отдать RFC3339.
+func parseTime(v string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
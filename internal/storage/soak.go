@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Soak — окно наблюдения за тестовой VM перед promote.
+type Soak struct {
+	StartedAt        time.Time `json:"started_at"`
+	EndsAt           time.Time `json:"ends_at"`
+	Heartbeats       int       `json:"heartbeats"`        // Здоровых heartbeat в окне
+	RemainingSeconds int       `json:"remaining_seconds"` // Заполняет API статуса
+}
+
+// StartSoak открывает окно наблюдения длиной d с текущего момента.
+func (s *Storage) StartSoak(testVMID int64, d time.Duration) error {
+	now := time.Now().UTC()
+	query := `UPDATE test_vms SET soak_started_at = ?, soak_ends_at = ?, soak_heartbeats = 0 WHERE id = ?`
+	_, err := s.db.Exec(query, now.Format(time.DateTime), now.Add(d).Format(time.DateTime), testVMID)
+	if err != nil {
+		return fmt.Errorf("storage.StartSoak: %w", err)
+	}
+	return nil
+}
+
+// RecordSoakHeartbeat засчитывает здоровый heartbeat агента в окне наблюдения.
+func (s *Storage) RecordSoakHeartbeat(testVMID int64) error {
+	query := `UPDATE test_vms SET soak_heartbeats = soak_heartbeats + 1 WHERE id = ?`
+	if _, err := s.db.Exec(query, testVMID); err != nil {
+		return fmt.Errorf("storage.RecordSoakHeartbeat: %w", err)
+	}
+	return nil
+}
+
+// GetSoak возвращает окно наблюдения тестовой VM (nil — наблюдения не было).
+func (s *Storage) GetSoak(testVMID int64) (*Soak, error) {
+	query := `SELECT coalesce(soak_started_at, ''), coalesce(soak_ends_at, ''), coalesce(soak_heartbeats, 0) FROM test_vms WHERE id = ?`
+
+	var started, ends string
+	var soak Soak
+	if err := s.db.QueryRow(query, testVMID).Scan(&started, &ends, &soak.Heartbeats); err != nil {
+		return nil, fmt.Errorf("storage.GetSoak: %w", err)
+	}
+	if started == "" {
+		return nil, nil
+	}
+	soak.StartedAt, soak.EndsAt = parseTime(started), parseTime(ends)
+	return &soak, nil
+}
@@ -45,12 +45,108 @@ func (s *Storage) Init() error {
     CREATE TABLE IF NOT EXISTS builds (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         image_name TEXT NOT NULL,
+        distro TEXT,    -- Имя конфига дистрибутива (configs/distros)
         status TEXT NOT NULL,
         vm_id TEXT,  -- Добавили колонку для связки VM и Сборки
         glance_id TEXT, -- ID образа в OpenStack
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP, -- Время последней смены статуса (для GC)
+        agent_token_hash TEXT, -- SHA-256 одноразового токена агента (NULL = отозван/не выдан)
+        agent_phase TEXT,        -- Последний этап, о котором сообщил агент (START, CHECK_DONE...)
+        last_heartbeat DATETIME, -- Время последнего сообщения агента (любой тестовой VM)
+        ssh_key_name TEXT,       -- Эфемерная ключевая пара Nova тестовых VM сборки
+        ssh_private_key BLOB,    -- Ее приватный ключ, зашифрованный AES-GCM (NULL = удален вместе с парой)
         logs TEXT DEFAULT ''
     );
+
+    -- Тестовые VM сборки: ячейки матрицы (test_matrix) или одна VM без имени ячейки
+    CREATE TABLE IF NOT EXISTS test_vms (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        build_id INTEGER NOT NULL REFERENCES builds(id),
+        cell TEXT NOT NULL,          -- Имя ячейки ('' — сборка без матрицы)
+        required INTEGER DEFAULT 1,  -- Провал обязательной ячейки роняет сборку
+        options TEXT,                -- Параметры VM в JSON (флейвор, сети, config drive, том)
+        vm_id TEXT,                  -- ID сервера Nova (NULL — еще не создана)
+        status TEXT NOT NULL,        -- Статусы как у сборки + CANCELLED
+        agent_phase TEXT,            -- Последний этап агента этой VM
+        last_heartbeat DATETIME,     -- Время последнего сообщения агента этой VM (для watchdog)
+        diagnostics_requested INTEGER DEFAULT 0, -- Запрошен сбор диагностики у агента упавшей VM
+        diagnostics_expected INTEGER DEFAULT 0,  -- Агенту отдана команда COLLECT_DIAGNOSTICS, ждем архив
+        boots_passed INTEGER DEFAULT 0,          -- Сколько загрузок VM прошли проверки (reboot_test)
+        soak_started_at DATETIME,                -- Окно наблюдения перед promote (soak_minutes)
+        soak_ends_at DATETIME,
+        soak_heartbeats INTEGER DEFAULT 0,       -- Здоровых heartbeat агента в окне
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE(build_id, cell)
+    );
+    CREATE INDEX IF NOT EXISTS idx_test_vms_vm ON test_vms(vm_id);
+
+    -- Облачные ресурсы, созданные менеджером (для сборщика мусора)
+    CREATE TABLE IF NOT EXISTS resources (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        build_id INTEGER NOT NULL REFERENCES builds(id),
+        kind TEXT NOT NULL,       -- image | server | keypair
+        cloud_id TEXT NOT NULL,   -- ID в Glance / Nova (у ключевой пары — имя)
+        name TEXT,
+        released INTEGER DEFAULT 0, -- 1 = образ стал боевым, GC его не трогает
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        deleted_at DATETIME,
+        UNIQUE(kind, cloud_id)
+    );
+
+    -- Артефакты сборки (консоль VM и т.п.), хранятся отдельно от основного лога
+    CREATE TABLE IF NOT EXISTS artifacts (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        build_id INTEGER NOT NULL REFERENCES builds(id),
+        name TEXT NOT NULL,
+        content_type TEXT NOT NULL,
+        content BLOB,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE(build_id, name)
+    );
+
+    -- Результаты проверок агента (по одной строке на проверку)
+    CREATE TABLE IF NOT EXISTS test_results (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        build_id INTEGER NOT NULL REFERENCES builds(id),
+        name TEXT NOT NULL,
+        status TEXT NOT NULL,   -- PASS, FAIL, ERROR
+        duration_ms INTEGER DEFAULT 0,
+        stdout TEXT DEFAULT '',
+        stderr TEXT DEFAULT '',
+        expected TEXT DEFAULT '',
+        actual TEXT DEFAULT '',
+        message TEXT DEFAULT '',
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS idx_test_results_build ON test_results(build_id);
+
+    -- Факты о тестовой VM из отчета агента (одна строка на сборку)
+    CREATE TABLE IF NOT EXISTS build_facts (
+        build_id INTEGER PRIMARY KEY REFERENCES builds(id),
+        flavor TEXT DEFAULT '',     -- Флейвор тестовой VM
+        vcpus INTEGER DEFAULT 0,
+        memory_mb INTEGER DEFAULT 0,
+        kernel TEXT DEFAULT '',
+        firmware TEXT DEFAULT '',
+        boot_seconds REAL DEFAULT 0,        -- systemd-analyze (для трендов времени загрузки)
+        agent_start_seconds REAL DEFAULT 0,
+        details TEXT NOT NULL,      -- Полные факты в JSON (диски, сети, драйверы)
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+
+    CREATE TABLE IF NOT EXISTS publish_targets (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        build_id INTEGER NOT NULL REFERENCES builds(id),
+        target TEXT NOT NULL,   -- Имя цели из publish-targets.yaml
+        region TEXT,
+        project_id TEXT,
+        status TEXT NOT NULL,   -- PENDING, PUBLISHING, PUBLISHED, FAILED
+        image_id TEXT,          -- ID копии образа в целевом облаке
+        error TEXT DEFAULT '',
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE(build_id, target)
+    );
     `
 	_, err := s.db.Exec(query)
 	if err != nil {
@@ -59,6 +155,14 @@ func (s *Storage) Init() error {
     
     // Миграция для старых баз (игнорируем ошибку, если колонка есть)
     _, _ = s.db.Exec(`ALTER TABLE builds ADD COLUMN glance_id TEXT;`)
+    _, _ = s.db.Exec(`ALTER TABLE builds ADD COLUMN distro TEXT;`)
+    _, _ = s.db.Exec(`ALTER TABLE builds ADD COLUMN updated_at DATETIME;`)
+    _, _ = s.db.Exec(`ALTER TABLE builds ADD COLUMN agent_token_hash TEXT;`)
+    _, _ = s.db.Exec(`ALTER TABLE builds ADD COLUMN agent_phase TEXT;`)
+    _, _ = s.db.Exec(`ALTER TABLE builds ADD COLUMN last_heartbeat DATETIME;`)
+    _, _ = s.db.Exec(`ALTER TABLE builds ADD COLUMN ssh_key_name TEXT;`)
+    _, _ = s.db.Exec(`ALTER TABLE builds ADD COLUMN ssh_private_key BLOB;`)
+    _, _ = s.db.Exec(`ALTER TABLE test_vms ADD COLUMN diagnostics_expected INTEGER DEFAULT 0;`)
 
 	return nil
 }
@@ -76,14 +180,30 @@ func (s *Storage) SetGlanceID(id int64, glanceID string) error {
 type BuildInfo struct {
     ID        int64
     ImageName string
+    Distro    string
     GlanceID  string
 }
 
-// GetBuildInfoByVMID возвращает данные о сборке по ID виртуалки.
+// GetBuildInfo возвращает данные о сборке по её ID.
+func (s *Storage) GetBuildInfo(id int64) (*BuildInfo, error) {
+    query := `SELECT id, image_name, coalesce(distro, ''), coalesce(glance_id, '') FROM builds WHERE id = ?`
+    var b BuildInfo
+    err := s.db.QueryRow(query, id).Scan(&b.ID, &b.ImageName, &b.Distro, &b.GlanceID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, fmt.Errorf("build not found")
+        }
+        return nil, fmt.Errorf("storage.GetBuildInfo: %w", err)
+    }
+    return &b, nil
+}
+
+// GetBuildInfoByVMID возвращает данные о сборке по ID любой ее тестовой VM.
 func (s *Storage) GetBuildInfoByVMID(vmID string) (*BuildInfo, error) {
-    query := `SELECT id, image_name, coalesce(glance_id, '') FROM builds WHERE vm_id = ?`
+    query := `SELECT b.id, b.image_name, coalesce(b.distro, ''), coalesce(b.glance_id, '')
+    FROM test_vms t JOIN builds b ON b.id = t.build_id WHERE t.vm_id = ?`
     var b BuildInfo
-    err := s.db.QueryRow(query, vmID).Scan(&b.ID, &b.ImageName, &b.GlanceID)
+    err := s.db.QueryRow(query, vmID).Scan(&b.ID, &b.ImageName, &b.Distro, &b.GlanceID)
     if err != nil {
         return nil, err
     }
@@ -142,12 +262,12 @@ func (s *Storage) Close() error {
 }
 
 // CreateBuild создает запись о новой сборке и возвращает её ID.
-func (s *Storage) CreateBuild(imageName string) (int64, error) {
-	query := `INSERT INTO builds (image_name, status) VALUES (?, ?) RETURNING id`
+func (s *Storage) CreateBuild(imageName, distro string) (int64, error) {
+	query := `INSERT INTO builds (image_name, distro, status) VALUES (?, ?, ?) RETURNING id`
 
 	var id int64
 	// Используем QueryRow, так как мы ждем возврата ID (RETURNING id)
-	err := s.db.QueryRow(query, imageName, "PENDING").Scan(&id)
+	err := s.db.QueryRow(query, imageName, distro, "PENDING").Scan(&id)
 	if err != nil {
 		return 0, fmt.Errorf("storage.CreateBuild: %w", err)
 	}
@@ -157,7 +277,7 @@ func (s *Storage) CreateBuild(imageName string) (int64, error) {
 
 // UpdateBuildStatus обновляет статус сборки по ID.
 func (s *Storage) UpdateBuildStatus(id int64, status string) error {
-	query := `UPDATE builds SET status = ? WHERE id = ?`
+	query := `UPDATE builds SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
 
 	_, err := s.db.Exec(query, status, id)
 	if err != nil {
@@ -167,24 +287,19 @@ func (s *Storage) UpdateBuildStatus(id int64, status string) error {
 	return nil
 }
 
-// SetVMID привязывает VM к сборке.
-func (s *Storage) SetVMID(id int64, vmID string) error {
-	query := `UPDATE builds SET vm_id = ? WHERE id = ?`
-	_, err := s.db.Exec(query, vmID, id)
-	return err
-}
+// UpdateBuildStatusFrom меняет статус сборки, только если он все еще from (CAS).
+// false — статус уже изменил кто-то другой.
+func (s *Storage) UpdateBuildStatusFrom(id int64, from, to string) (bool, error) {
+	query := `UPDATE builds SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
 
-// UpdateBuildStatusByVMID обновляет статус сборки, зная ID виртуалки.
-func (s *Storage) UpdateBuildStatusByVMID(vmID string, status string) error {
-	query := `UPDATE builds SET status = ? WHERE vm_id = ?`
-	res, err := s.db.Exec(query, status, vmID)
+	res, err := s.db.Exec(query, to, id, from)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("storage.UpdateBuildStatusFrom: %w", err)
 	}
-
-	rows, _ := res.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("no build found with vm_id=%s", vmID)
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("storage.UpdateBuildStatusFrom: %w", err)
 	}
-	return nil
+	return n > 0, nil
 }
+
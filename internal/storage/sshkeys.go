@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SetSSHKey сохраняет эфемерную ключевую пару сборки: имя в Nova и зашифрованный приватный ключ.
+func (s *Storage) SetSSHKey(buildID int64, name string, encrypted []byte) error {
+	query := `UPDATE builds SET ssh_key_name = ?, ssh_private_key = ? WHERE id = ?`
+	if _, err := s.db.Exec(query, name, encrypted, buildID); err != nil {
+		return fmt.Errorf("storage.SetSSHKey: %w", err)
+	}
+	return nil
+}
+
+// GetSSHKey возвращает имя ключевой пары сборки и зашифрованный приватный ключ.
+// Пустое имя — сборка шла с общим ключом; nil-ключ — пара уже удалена.
+func (s *Storage) GetSSHKey(buildID int64) (string, []byte, error) {
+	query := `SELECT coalesce(ssh_key_name, ''), ssh_private_key FROM builds WHERE id = ?`
+
+	var name string
+	var encrypted []byte
+	if err := s.db.QueryRow(query, buildID).Scan(&name, &encrypted); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil, fmt.Errorf("build not found")
+		}
+		return "", nil, fmt.Errorf("storage.GetSSHKey: %w", err)
+	}
+	return name, encrypted, nil
+}
+
+// DropSSHPrivateKey стирает приватный ключ удаленной ключевой пары (имя остается для истории).
+func (s *Storage) DropSSHPrivateKey(name string) error {
+	query := `UPDATE builds SET ssh_private_key = NULL WHERE ssh_key_name = ?`
+	if _, err := s.db.Exec(query, name); err != nil {
+		return fmt.Errorf("storage.DropSSHPrivateKey: %w", err)
+	}
+	return nil
+}
+
+// ListUnusedKeypairs возвращает живые ключевые пары сборок, которым они больше не нужны:
+// все тестовые VM сборки созданы (или не смогли создаться) и удалены.
+func (s *Storage) ListUnusedKeypairs() ([]Resource, error) {
+	query := `
+    SELECT r.id, r.build_id, r.kind, r.cloud_id, coalesce(r.name, ''), b.status
+    FROM resources r JOIN builds b ON b.id = r.build_id
+    WHERE r.kind = 'keypair' AND r.deleted_at IS NULL
+      AND EXISTS (SELECT 1 FROM test_vms v WHERE v.build_id = r.build_id)
+      AND NOT EXISTS (SELECT 1 FROM test_vms v WHERE v.build_id = r.build_id AND v.status IN ('PENDING', 'BOOTING_VM'))
+      AND NOT EXISTS (SELECT 1 FROM resources srv WHERE srv.build_id = r.build_id AND srv.kind = 'server' AND srv.deleted_at IS NULL)
+    ORDER BY r.id`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("storage.ListUnusedKeypairs: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Resource
+	for rows.Next() {
+		var r Resource
+		if err := rows.Scan(&r.ID, &r.BuildID, &r.Kind, &r.CloudID, &r.Name, &r.BuildStatus); err != nil {
+			return nil, fmt.Errorf("storage.ListUnusedKeypairs: %w", err)
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
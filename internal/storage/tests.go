@@ -0,0 +1,77 @@
+package storage
+
+import "fmt"
+
+// TestResult — результат одной проверки агента.
+type TestResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	DurationMs int64  `json:"duration_ms"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	Expected   string `json:"expected"`
+	Actual     string `json:"actual"`
+	Message    string `json:"message"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// SaveTestResults заменяет результаты проверок сборки (повторный отчет перезаписывает прошлый).
+func (s *Storage) SaveTestResults(buildID int64, results []TestResult) error {
+	return s.ReplaceTestResults(buildID, "", results)
+}
+
+// ReplaceTestResults заменяет только результаты с префиксом имени prefix ("boot2/" — вторая
+// загрузка VM), остальные проверки сборки остаются. Пустой prefix — заменить все.
+func (s *Storage) ReplaceTestResults(buildID int64, prefix string, results []TestResult) error {
+	const op = "storage.ReplaceTestResults"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// substr вместо LIKE: в именах проверок бывают "_" и "%"
+	_, err = tx.Exec(`DELETE FROM test_results WHERE build_id = ? AND substr(name, 1, ?) = ?`, buildID, len(prefix), prefix)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+    INSERT INTO test_results (build_id, name, status, duration_ms, stdout, stderr, expected, actual, message)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, r := range results {
+		_, err := tx.Exec(query, buildID, r.Name, r.Status, r.DurationMs, r.Stdout, r.Stderr, r.Expected, r.Actual, r.Message)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// GetTestResults возвращает результаты проверок сборки в порядке выполнения.
+func (s *Storage) GetTestResults(buildID int64) ([]TestResult, error) {
+	query := `
+    SELECT name, status, duration_ms, stdout, stderr, expected, actual, message, created_at
+    FROM test_results WHERE build_id = ? ORDER BY id`
+
+	rows, err := s.db.Query(query, buildID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.GetTestResults: %w", err)
+	}
+	defer rows.Close()
+
+	results := []TestResult{}
+	for rows.Next() {
+		var r TestResult
+		if err := rows.Scan(&r.Name, &r.Status, &r.DurationMs, &r.Stdout, &r.Stderr, &r.Expected, &r.Actual, &r.Message, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("storage.GetTestResults: %w", err)
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// TestVM — тестовая VM сборки (ячейка матрицы). У сборки без матрицы одна VM с пустым Cell.
+// Статусы — те же, что у сборки: PENDING, BOOTING_VM, WAITING_AGENT, SOAKING, SUCCESS, ERROR_*,
+// плюс CANCELLED (остановлена, потому что упала обязательная ячейка).
+type TestVM struct {
+	ID        int64           `json:"-"`
+	BuildID   int64           `json:"-"`
+	Cell      string          `json:"cell"`
+	Required  bool            `json:"required"`
+	Options   json.RawMessage `json:"options"` // Параметры VM (флейвор, сети, config drive, том)
+	VMID      string          `json:"vm_id"`
+	Status    string          `json:"status"`
+	Heartbeat Heartbeat       `json:"agent"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+const testVMColumns = `id, build_id, cell, required, coalesce(options, '{}'), coalesce(vm_id, ''), status,
+    coalesce(agent_phase, ''), coalesce(last_heartbeat, ''), coalesce(updated_at, '')`
+
+func scanTestVM(row interface{ Scan(...any) error }) (*TestVM, error) {
+	var vm TestVM
+	var options, last, updated string
+	if err := row.Scan(&vm.ID, &vm.BuildID, &vm.Cell, &vm.Required, &options, &vm.VMID, &vm.Status,
+		&vm.Heartbeat.Phase, &last, &updated); err != nil {
+		return nil, err
+	}
+	vm.Options = json.RawMessage(options)
+	if last != "" {
+		vm.Heartbeat.LastAt = parseTime(last)
+	}
+	vm.UpdatedAt = parseTime(updated)
+	return &vm, nil
+}
+
+// AddTestVM регистрирует ячейку матрицы сборки в статусе PENDING (VM еще не создана).
+func (s *Storage) AddTestVM(buildID int64, cell string, required bool, options []byte) (int64, error) {
+	query := `INSERT INTO test_vms (build_id, cell, required, options, status) VALUES (?, ?, ?, ?, 'PENDING') RETURNING id`
+
+	var id int64
+	if err := s.db.QueryRow(query, buildID, cell, required, string(options)).Scan(&id); err != nil {
+		return 0, fmt.Errorf("storage.AddTestVM: %w", err)
+	}
+	return id, nil
+}
+
+// SetTestVMServer привязывает созданную VM Nova к ячейке.
+func (s *Storage) SetTestVMServer(id int64, vmID string) error {
+	query := `UPDATE test_vms SET vm_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := s.db.Exec(query, vmID, id); err != nil {
+		return fmt.Errorf("storage.SetTestVMServer: %w", err)
+	}
+	return nil
+}
+
+// UpdateTestVMStatus переводит тестовую VM из статуса from в to. false — VM уже не в from
+// (статус успел смениться по другому каналу).
+func (s *Storage) UpdateTestVMStatus(id int64, from, to string) (bool, error) {
+	query := `UPDATE test_vms SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
+	res, err := s.db.Exec(query, to, id, from)
+	if err != nil {
+		return false, fmt.Errorf("storage.UpdateTestVMStatus: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("storage.UpdateTestVMStatus: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetTestVM возвращает тестовую VM по ID записи.
+func (s *Storage) GetTestVM(id int64) (*TestVM, error) {
+	vm, err := scanTestVM(s.db.QueryRow(`SELECT `+testVMColumns+` FROM test_vms WHERE id = ?`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("test vm not found")
+		}
+		return nil, fmt.Errorf("storage.GetTestVM: %w", err)
+	}
+	return vm, nil
+}
+
+// GetTestVMByVMID возвращает тестовую VM по ID сервера Nova.
+func (s *Storage) GetTestVMByVMID(vmID string) (*TestVM, error) {
+	vm, err := scanTestVM(s.db.QueryRow(`SELECT `+testVMColumns+` FROM test_vms WHERE vm_id = ?`, vmID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("test vm not found")
+		}
+		return nil, fmt.Errorf("storage.GetTestVMByVMID: %w", err)
+	}
+	return vm, nil
+}
+
+// GetTestVMs возвращает тестовые VM сборки в порядке ячеек матрицы.
+func (s *Storage) GetTestVMs(buildID int64) ([]TestVM, error) {
+	rows, err := s.db.Query(`SELECT `+testVMColumns+` FROM test_vms WHERE build_id = ? ORDER BY id`, buildID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.GetTestVMs: %w", err)
+	}
+	defer rows.Close()
+
+	var vms []TestVM
+	for rows.Next() {
+		vm, err := scanTestVM(rows)
+		if err != nil {
+			return nil, fmt.Errorf("storage.GetTestVMs: %w", err)
+		}
+		vms = append(vms, *vm)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.GetTestVMs: %w", err)
+	}
+	return vms, nil
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SetAgentTokenHash сохраняет хеш токена агента для сборки (сам токен не храним).
+func (s *Storage) SetAgentTokenHash(buildID int64, hash string) error {
+	query := `UPDATE builds SET agent_token_hash = ? WHERE id = ?`
+	if _, err := s.db.Exec(query, hash, buildID); err != nil {
+		return fmt.Errorf("storage.SetAgentTokenHash: %w", err)
+	}
+	return nil
+}
+
+// GetAgentTokenHash возвращает хеш токена агента. Пустая строка — токен не выдан или отозван.
+func (s *Storage) GetAgentTokenHash(buildID int64) (string, error) {
+	query := `SELECT coalesce(agent_token_hash, '') FROM builds WHERE id = ?`
+
+	var hash string
+	if err := s.db.QueryRow(query, buildID).Scan(&hash); err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("build not found")
+		}
+		return "", fmt.Errorf("storage.GetAgentTokenHash: %w", err)
+	}
+	return hash, nil
+}
+
+// RevokeAgentToken отзывает токен агента: повторно им воспользоваться нельзя.
+func (s *Storage) RevokeAgentToken(buildID int64) error {
+	query := `UPDATE builds SET agent_token_hash = NULL WHERE id = ?`
+	if _, err := s.db.Exec(query, buildID); err != nil {
+		return fmt.Errorf("storage.RevokeAgentToken: %w", err)
+	}
+	return nil
+}
@@ -24,13 +24,18 @@ const (
 
 // Сообщение-запрос (от Агента к Менеджеру)
 type StatusRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	VmId          string                 `protobuf:"bytes,1,opt,name=vm_id,json=vmId,proto3" json:"vm_id,omitempty"` // ID виртуалки (чтобы Менеджер понял, кто звонит)
-	Phase         string                 `protobuf:"bytes,2,opt,name=phase,proto3" json:"phase,omitempty"`           // Этап: "START", "TESTS_DONE"
-	Success       bool                   `protobuf:"varint,3,opt,name=success,proto3" json:"success,omitempty"`      // Все ли хорошо?
-	Details       string                 `protobuf:"bytes,4,opt,name=details,proto3" json:"details,omitempty"`       // Логи или текст ошибки
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	VmId           string                 `protobuf:"bytes,1,opt,name=vm_id,json=vmId,proto3" json:"vm_id,omitempty"`                               // ID виртуалки (чтобы Менеджер понял, кто звонит)
+	Phase          string                 `protobuf:"bytes,2,opt,name=phase,proto3" json:"phase,omitempty"`                                         // Этап: START, HEARTBEAT, CHECK_DONE, TESTS_DONE, SOAK
+	Success        bool                   `protobuf:"varint,3,opt,name=success,proto3" json:"success,omitempty"`                                    // Все ли хорошо?
+	Details        string                 `protobuf:"bytes,4,opt,name=details,proto3" json:"details,omitempty"`                                     // Логи или текст ошибки
+	Token          string                 `protobuf:"bytes,5,opt,name=token,proto3" json:"token,omitempty"`                                         // Одноразовый токен сборки (приходит в VM через user data)
+	Results        []*CheckResult         `protobuf:"bytes,6,rep,name=results,proto3" json:"results,omitempty"`                                     // Результаты отдельных проверок плана
+	Facts          *HostFacts             `protobuf:"bytes,7,opt,name=facts,proto3" json:"facts,omitempty"`                                         // Факты о VM (в итоговом отчете), менеджер сверяет их с флейвором
+	IdentitySource string                 `protobuf:"bytes,8,opt,name=identity_source,json=identitySource,proto3" json:"identity_source,omitempty"` // Откуда агент узнал vm_id: metadata, config_drive, cloud_init, dmi
+	Boot           int32                  `protobuf:"varint,9,opt,name=boot,proto3" json:"boot,omitempty"`                                          // Номер загрузки: 1 — первая, 2 — после REBOOT (0 — старый агент)
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
 }
 
 func (x *StatusRequest) Reset() {
@@ -91,17 +96,380 @@ func (x *StatusRequest) GetDetails() string {
 	return ""
 }
 
+func (x *StatusRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *StatusRequest) GetResults() []*CheckResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *StatusRequest) GetFacts() *HostFacts {
+	if x != nil {
+		return x.Facts
+	}
+	return nil
+}
+
+func (x *StatusRequest) GetIdentitySource() string {
+	if x != nil {
+		return x.IdentitySource
+	}
+	return ""
+}
+
+func (x *StatusRequest) GetBoot() int32 {
+	if x != nil {
+		return x.Boot
+	}
+	return 0
+}
+
+// Факты о тестовой VM, как ее видит гостевая ОС
+type HostFacts struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Vcpus             int32                  `protobuf:"varint,1,opt,name=vcpus,proto3" json:"vcpus,omitempty"`
+	MemoryMb          int64                  `protobuf:"varint,2,opt,name=memory_mb,json=memoryMb,proto3" json:"memory_mb,omitempty"` // MemTotal из /proc/meminfo
+	Disks             []*DiskFact            `protobuf:"bytes,3,rep,name=disks,proto3" json:"disks,omitempty"`
+	Nics              []*NicFact             `protobuf:"bytes,4,rep,name=nics,proto3" json:"nics,omitempty"`
+	Kernel            string                 `protobuf:"bytes,5,opt,name=kernel,proto3" json:"kernel,omitempty"`                                                    // uname -r
+	VirtioDrivers     []string               `protobuf:"bytes,6,rep,name=virtio_drivers,json=virtioDrivers,proto3" json:"virtio_drivers,omitempty"`                 // Драйверы на шине virtio (virtio_net, virtio_blk...)
+	Firmware          string                 `protobuf:"bytes,7,opt,name=firmware,proto3" json:"firmware,omitempty"`                                                // "bios" или "uefi"
+	BootSeconds       float64                `protobuf:"fixed64,8,opt,name=boot_seconds,json=bootSeconds,proto3" json:"boot_seconds,omitempty"`                     // systemd-analyze: от старта ядра до конца загрузки (0 — неизвестно)
+	AgentStartSeconds float64                `protobuf:"fixed64,9,opt,name=agent_start_seconds,json=agentStartSeconds,proto3" json:"agent_start_seconds,omitempty"` // Uptime в момент старта агента
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *HostFacts) Reset() {
+	*x = HostFacts{}
+	mi := &file_pkg_proto_agent_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HostFacts) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HostFacts) ProtoMessage() {}
+
+func (x *HostFacts) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_agent_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HostFacts.ProtoReflect.Descriptor instead.
+func (*HostFacts) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *HostFacts) GetVcpus() int32 {
+	if x != nil {
+		return x.Vcpus
+	}
+	return 0
+}
+
+func (x *HostFacts) GetMemoryMb() int64 {
+	if x != nil {
+		return x.MemoryMb
+	}
+	return 0
+}
+
+func (x *HostFacts) GetDisks() []*DiskFact {
+	if x != nil {
+		return x.Disks
+	}
+	return nil
+}
+
+func (x *HostFacts) GetNics() []*NicFact {
+	if x != nil {
+		return x.Nics
+	}
+	return nil
+}
+
+func (x *HostFacts) GetKernel() string {
+	if x != nil {
+		return x.Kernel
+	}
+	return ""
+}
+
+func (x *HostFacts) GetVirtioDrivers() []string {
+	if x != nil {
+		return x.VirtioDrivers
+	}
+	return nil
+}
+
+func (x *HostFacts) GetFirmware() string {
+	if x != nil {
+		return x.Firmware
+	}
+	return ""
+}
+
+func (x *HostFacts) GetBootSeconds() float64 {
+	if x != nil {
+		return x.BootSeconds
+	}
+	return 0
+}
+
+func (x *HostFacts) GetAgentStartSeconds() float64 {
+	if x != nil {
+		return x.AgentStartSeconds
+	}
+	return 0
+}
+
+type DiskFact struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"` // vda, sda, nvme0n1
+	SizeBytes     int64                  `protobuf:"varint,2,opt,name=size_bytes,json=sizeBytes,proto3" json:"size_bytes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DiskFact) Reset() {
+	*x = DiskFact{}
+	mi := &file_pkg_proto_agent_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DiskFact) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DiskFact) ProtoMessage() {}
+
+func (x *DiskFact) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_agent_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DiskFact.ProtoReflect.Descriptor instead.
+func (*DiskFact) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DiskFact) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *DiskFact) GetSizeBytes() int64 {
+	if x != nil {
+		return x.SizeBytes
+	}
+	return 0
+}
+
+type NicFact struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Mtu           int32                  `protobuf:"varint,2,opt,name=mtu,proto3" json:"mtu,omitempty"`
+	Mac           string                 `protobuf:"bytes,3,opt,name=mac,proto3" json:"mac,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NicFact) Reset() {
+	*x = NicFact{}
+	mi := &file_pkg_proto_agent_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NicFact) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NicFact) ProtoMessage() {}
+
+func (x *NicFact) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_agent_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NicFact.ProtoReflect.Descriptor instead.
+func (*NicFact) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *NicFact) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *NicFact) GetMtu() int32 {
+	if x != nil {
+		return x.Mtu
+	}
+	return 0
+}
+
+func (x *NicFact) GetMac() string {
+	if x != nil {
+		return x.Mac
+	}
+	return ""
+}
+
+// Результат одной проверки
+type CheckResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"` // PASS | FAIL | ERROR (проверку не удалось выполнить) | SKIP
+	DurationMs    int64                  `protobuf:"varint,3,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	Stdout        string                 `protobuf:"bytes,4,opt,name=stdout,proto3" json:"stdout,omitempty"` // Фрагмент вывода (обрезается агентом)
+	Stderr        string                 `protobuf:"bytes,5,opt,name=stderr,proto3" json:"stderr,omitempty"`
+	Expected      string                 `protobuf:"bytes,6,opt,name=expected,proto3" json:"expected,omitempty"` // Что ожидали (код выхода, строку, состояние сервиса...)
+	Actual        string                 `protobuf:"bytes,7,opt,name=actual,proto3" json:"actual,omitempty"`     // Что получили
+	Message       string                 `protobuf:"bytes,8,opt,name=message,proto3" json:"message,omitempty"`   // Причина провала в свободной форме
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckResult) Reset() {
+	*x = CheckResult{}
+	mi := &file_pkg_proto_agent_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckResult) ProtoMessage() {}
+
+func (x *CheckResult) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_agent_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckResult.ProtoReflect.Descriptor instead.
+func (*CheckResult) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CheckResult) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CheckResult) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *CheckResult) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *CheckResult) GetStdout() string {
+	if x != nil {
+		return x.Stdout
+	}
+	return ""
+}
+
+func (x *CheckResult) GetStderr() string {
+	if x != nil {
+		return x.Stderr
+	}
+	return ""
+}
+
+func (x *CheckResult) GetExpected() string {
+	if x != nil {
+		return x.Expected
+	}
+	return ""
+}
+
+func (x *CheckResult) GetActual() string {
+	if x != nil {
+		return x.Actual
+	}
+	return ""
+}
+
+func (x *CheckResult) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
 // Сообщение-ответ (от Менеджера Агенту)
 type StatusResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Command       string                 `protobuf:"bytes,1,opt,name=command,proto3" json:"command,omitempty"` // Менеджер может сказать: "ОК, удаляйся" или "Жди"
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Command string                 `protobuf:"bytes,1,opt,name=command,proto3" json:"command,omitempty"` // Менеджер может сказать: "ОК, удаляйся" или "Жди"
+	// Для COLLECT_DIAGNOSTICS: какие сборщики запустить (journal, dmesg, cloud_init, network,
+	// df, failed_units, packages). Агент выполняет только известные ему, неизвестные пропускает.
+	Collect []string `protobuf:"bytes,2,rep,name=collect,proto3" json:"collect,omitempty"`
+	// SOAK (soak_minutes в конфиге дистрибутива): проверки прошли, VM работает под наблюдением.
+	// Агент шлет SOAK с результатами проверок здоровья, пока менеджер не ответит другой командой.
+	SoakSeconds   int32 `protobuf:"varint,3,opt,name=soak_seconds,json=soakSeconds,proto3" json:"soak_seconds,omitempty"` // Сколько еще длится окно наблюдения
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *StatusResponse) Reset() {
 	*x = StatusResponse{}
-	mi := &file_pkg_proto_agent_proto_msgTypes[1]
+	mi := &file_pkg_proto_agent_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -113,7 +481,7 @@ func (x *StatusResponse) String() string {
 func (*StatusResponse) ProtoMessage() {}
 
 func (x *StatusResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_pkg_proto_agent_proto_msgTypes[1]
+	mi := &file_pkg_proto_agent_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -126,7 +494,7 @@ func (x *StatusResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StatusResponse.ProtoReflect.Descriptor instead.
 func (*StatusResponse) Descriptor() ([]byte, []int) {
-	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{1}
+	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *StatusResponse) GetCommand() string {
@@ -136,20 +504,479 @@ func (x *StatusResponse) GetCommand() string {
 	return ""
 }
 
+func (x *StatusResponse) GetCollect() []string {
+	if x != nil {
+		return x.Collect
+	}
+	return nil
+}
+
+func (x *StatusResponse) GetSoakSeconds() int32 {
+	if x != nil {
+		return x.SoakSeconds
+	}
+	return 0
+}
+
+// Кусок архива диагностики (tar.gz). Каждый кусок несет vm_id и токен, как отчет.
+type DiagnosticsChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VmId          string                 `protobuf:"bytes,1,opt,name=vm_id,json=vmId,proto3" json:"vm_id,omitempty"`
+	Token         string                 `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	Offset        int64                  `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"` // Смещение куска в архиве; 0 — начать загрузку заново
+	Data          []byte                 `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	Last          bool                   `protobuf:"varint,5,opt,name=last,proto3" json:"last,omitempty"` // Последний кусок: менеджер сохраняет архив артефактом сборки
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DiagnosticsChunk) Reset() {
+	*x = DiagnosticsChunk{}
+	mi := &file_pkg_proto_agent_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DiagnosticsChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DiagnosticsChunk) ProtoMessage() {}
+
+func (x *DiagnosticsChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_agent_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DiagnosticsChunk.ProtoReflect.Descriptor instead.
+func (*DiagnosticsChunk) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DiagnosticsChunk) GetVmId() string {
+	if x != nil {
+		return x.VmId
+	}
+	return ""
+}
+
+func (x *DiagnosticsChunk) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *DiagnosticsChunk) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *DiagnosticsChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *DiagnosticsChunk) GetLast() bool {
+	if x != nil {
+		return x.Last
+	}
+	return false
+}
+
+type DiagnosticsAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Received      int64                  `protobuf:"varint,1,opt,name=received,proto3" json:"received,omitempty"` // Сколько байт архива менеджер уже принял
+	Complete      bool                   `protobuf:"varint,2,opt,name=complete,proto3" json:"complete,omitempty"` // Архив сохранен
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DiagnosticsAck) Reset() {
+	*x = DiagnosticsAck{}
+	mi := &file_pkg_proto_agent_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DiagnosticsAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DiagnosticsAck) ProtoMessage() {}
+
+func (x *DiagnosticsAck) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_agent_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DiagnosticsAck.ProtoReflect.Descriptor instead.
+func (*DiagnosticsAck) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DiagnosticsAck) GetReceived() int64 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
+func (x *DiagnosticsAck) GetComplete() bool {
+	if x != nil {
+		return x.Complete
+	}
+	return false
+}
+
+// Запрос плана проверок (те же vm_id и токен, что и в отчете)
+type TestPlanRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VmId          string                 `protobuf:"bytes,1,opt,name=vm_id,json=vmId,proto3" json:"vm_id,omitempty"`
+	Token         string                 `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TestPlanRequest) Reset() {
+	*x = TestPlanRequest{}
+	mi := &file_pkg_proto_agent_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TestPlanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TestPlanRequest) ProtoMessage() {}
+
+func (x *TestPlanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_agent_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TestPlanRequest.ProtoReflect.Descriptor instead.
+func (*TestPlanRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *TestPlanRequest) GetVmId() string {
+	if x != nil {
+		return x.VmId
+	}
+	return ""
+}
+
+func (x *TestPlanRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+// План проверок для сборки
+type TestPlan struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Checks        []*Check               `protobuf:"bytes,1,rep,name=checks,proto3" json:"checks,omitempty"`
+	RootDiskGb    int32                  `protobuf:"varint,2,opt,name=root_disk_gb,json=rootDiskGb,proto3" json:"root_disk_gb,omitempty"` // Диск флейвора тестовой VM (для проверки ресайза корня; 0 = неизвестно)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TestPlan) Reset() {
+	*x = TestPlan{}
+	mi := &file_pkg_proto_agent_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TestPlan) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TestPlan) ProtoMessage() {}
+
+func (x *TestPlan) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_agent_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TestPlan.ProtoReflect.Descriptor instead.
+func (*TestPlan) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *TestPlan) GetChecks() []*Check {
+	if x != nil {
+		return x.Checks
+	}
+	return nil
+}
+
+func (x *TestPlan) GetRootDiskGb() int32 {
+	if x != nil {
+		return x.RootDiskGb
+	}
+	return 0
+}
+
+// Одна проверка. Какие поля используются, зависит от type.
+type Check struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Name            string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Type            string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`                                                        // command | file | service | port | builtin
+	Command         string                 `protobuf:"bytes,3,opt,name=command,proto3" json:"command,omitempty"`                                                  // command: выполняется через sh -c
+	ExpectExitCodes []int32                `protobuf:"varint,4,rep,packed,name=expect_exit_codes,json=expectExitCodes,proto3" json:"expect_exit_codes,omitempty"` // command: допустимые коды выхода (пусто = только 0)
+	Path            string                 `protobuf:"bytes,5,opt,name=path,proto3" json:"path,omitempty"`                                                        // file: путь к файлу
+	Contains        string                 `protobuf:"bytes,6,opt,name=contains,proto3" json:"contains,omitempty"`                                                // command/file: вывод или содержимое должны содержать строку
+	Matches         string                 `protobuf:"bytes,7,opt,name=matches,proto3" json:"matches,omitempty"`                                                  // command/file: ... или совпадать с регулярным выражением
+	Service         string                 `protobuf:"bytes,8,opt,name=service,proto3" json:"service,omitempty"`                                                  // service: имя systemd-юнита (должен быть active)
+	Port            int32                  `protobuf:"varint,9,opt,name=port,proto3" json:"port,omitempty"`                                                       // port: порт, который должен слушаться
+	Protocol        string                 `protobuf:"bytes,10,opt,name=protocol,proto3" json:"protocol,omitempty"`                                               // port: tcp (по умолчанию) или udp
+	TimeoutSeconds  int32                  `protobuf:"varint,11,opt,name=timeout_seconds,json=timeoutSeconds,proto3" json:"timeout_seconds,omitempty"`            // Таймаут проверки (0 = по умолчанию агента)
+	Builtin         string                 `protobuf:"bytes,12,opt,name=builtin,proto3" json:"builtin,omitempty"`                                                 // builtin: имя встроенной проверки агента (root_fs_size, cloud_init...)
+	Target          string                 `protobuf:"bytes,13,opt,name=target,proto3" json:"target,omitempty"`                                                   // builtin: параметр (хост для dns, URL для metadata)
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Check) Reset() {
+	*x = Check{}
+	mi := &file_pkg_proto_agent_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Check) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Check) ProtoMessage() {}
+
+func (x *Check) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_agent_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Check.ProtoReflect.Descriptor instead.
+func (*Check) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_agent_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Check) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Check) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Check) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *Check) GetExpectExitCodes() []int32 {
+	if x != nil {
+		return x.ExpectExitCodes
+	}
+	return nil
+}
+
+func (x *Check) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Check) GetContains() string {
+	if x != nil {
+		return x.Contains
+	}
+	return ""
+}
+
+func (x *Check) GetMatches() string {
+	if x != nil {
+		return x.Matches
+	}
+	return ""
+}
+
+func (x *Check) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *Check) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *Check) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *Check) GetTimeoutSeconds() int32 {
+	if x != nil {
+		return x.TimeoutSeconds
+	}
+	return 0
+}
+
+func (x *Check) GetBuiltin() string {
+	if x != nil {
+		return x.Builtin
+	}
+	return ""
+}
+
+func (x *Check) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
 var File_pkg_proto_agent_proto protoreflect.FileDescriptor
 
 const file_pkg_proto_agent_proto_rawDesc = "" +
 	"\n" +
-	"\x15pkg/proto/agent.proto\x12\x05agent\"n\n" +
+	"\x15pkg/proto/agent.proto\x12\x05agent\"\x97\x02\n" +
 	"\rStatusRequest\x12\x13\n" +
 	"\x05vm_id\x18\x01 \x01(\tR\x04vmId\x12\x14\n" +
 	"\x05phase\x18\x02 \x01(\tR\x05phase\x12\x18\n" +
 	"\asuccess\x18\x03 \x01(\bR\asuccess\x12\x18\n" +
-	"\adetails\x18\x04 \x01(\tR\adetails\"*\n" +
+	"\adetails\x18\x04 \x01(\tR\adetails\x12\x14\n" +
+	"\x05token\x18\x05 \x01(\tR\x05token\x12,\n" +
+	"\aresults\x18\x06 \x03(\v2\x12.agent.CheckResultR\aresults\x12&\n" +
+	"\x05facts\x18\a \x01(\v2\x10.agent.HostFactsR\x05facts\x12'\n" +
+	"\x0fidentity_source\x18\b \x01(\tR\x0eidentitySource\x12\x12\n" +
+	"\x04boot\x18\t \x01(\x05R\x04boot\"\xb7\x02\n" +
+	"\tHostFacts\x12\x14\n" +
+	"\x05vcpus\x18\x01 \x01(\x05R\x05vcpus\x12\x1b\n" +
+	"\tmemory_mb\x18\x02 \x01(\x03R\bmemoryMb\x12%\n" +
+	"\x05disks\x18\x03 \x03(\v2\x0f.agent.DiskFactR\x05disks\x12\"\n" +
+	"\x04nics\x18\x04 \x03(\v2\x0e.agent.NicFactR\x04nics\x12\x16\n" +
+	"\x06kernel\x18\x05 \x01(\tR\x06kernel\x12%\n" +
+	"\x0evirtio_drivers\x18\x06 \x03(\tR\rvirtioDrivers\x12\x1a\n" +
+	"\bfirmware\x18\a \x01(\tR\bfirmware\x12!\n" +
+	"\fboot_seconds\x18\b \x01(\x01R\vbootSeconds\x12.\n" +
+	"\x13agent_start_seconds\x18\t \x01(\x01R\x11agentStartSeconds\"=\n" +
+	"\bDiskFact\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1d\n" +
+	"\n" +
+	"size_bytes\x18\x02 \x01(\x03R\tsizeBytes\"A\n" +
+	"\aNicFact\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x10\n" +
+	"\x03mtu\x18\x02 \x01(\x05R\x03mtu\x12\x10\n" +
+	"\x03mac\x18\x03 \x01(\tR\x03mac\"\xd8\x01\n" +
+	"\vCheckResult\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x1f\n" +
+	"\vduration_ms\x18\x03 \x01(\x03R\n" +
+	"durationMs\x12\x16\n" +
+	"\x06stdout\x18\x04 \x01(\tR\x06stdout\x12\x16\n" +
+	"\x06stderr\x18\x05 \x01(\tR\x06stderr\x12\x1a\n" +
+	"\bexpected\x18\x06 \x01(\tR\bexpected\x12\x16\n" +
+	"\x06actual\x18\a \x01(\tR\x06actual\x12\x18\n" +
+	"\amessage\x18\b \x01(\tR\amessage\"g\n" +
 	"\x0eStatusResponse\x12\x18\n" +
-	"\acommand\x18\x01 \x01(\tR\acommand2K\n" +
+	"\acommand\x18\x01 \x01(\tR\acommand\x12\x18\n" +
+	"\acollect\x18\x02 \x03(\tR\acollect\x12!\n" +
+	"\fsoak_seconds\x18\x03 \x01(\x05R\vsoakSeconds\"}\n" +
+	"\x10DiagnosticsChunk\x12\x13\n" +
+	"\x05vm_id\x18\x01 \x01(\tR\x04vmId\x12\x14\n" +
+	"\x05token\x18\x02 \x01(\tR\x05token\x12\x16\n" +
+	"\x06offset\x18\x03 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04data\x18\x04 \x01(\fR\x04data\x12\x12\n" +
+	"\x04last\x18\x05 \x01(\bR\x04last\"H\n" +
+	"\x0eDiagnosticsAck\x12\x1a\n" +
+	"\breceived\x18\x01 \x01(\x03R\breceived\x12\x1a\n" +
+	"\bcomplete\x18\x02 \x01(\bR\bcomplete\"<\n" +
+	"\x0fTestPlanRequest\x12\x13\n" +
+	"\x05vm_id\x18\x01 \x01(\tR\x04vmId\x12\x14\n" +
+	"\x05token\x18\x02 \x01(\tR\x05token\"R\n" +
+	"\bTestPlan\x12$\n" +
+	"\x06checks\x18\x01 \x03(\v2\f.agent.CheckR\x06checks\x12 \n" +
+	"\froot_disk_gb\x18\x02 \x01(\x05R\n" +
+	"rootDiskGb\"\xe4\x02\n" +
+	"\x05Check\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x18\n" +
+	"\acommand\x18\x03 \x01(\tR\acommand\x12*\n" +
+	"\x11expect_exit_codes\x18\x04 \x03(\x05R\x0fexpectExitCodes\x12\x12\n" +
+	"\x04path\x18\x05 \x01(\tR\x04path\x12\x1a\n" +
+	"\bcontains\x18\x06 \x01(\tR\bcontains\x12\x18\n" +
+	"\amatches\x18\a \x01(\tR\amatches\x12\x18\n" +
+	"\aservice\x18\b \x01(\tR\aservice\x12\x12\n" +
+	"\x04port\x18\t \x01(\x05R\x04port\x12\x1a\n" +
+	"\bprotocol\x18\n" +
+	" \x01(\tR\bprotocol\x12'\n" +
+	"\x0ftimeout_seconds\x18\v \x01(\x05R\x0etimeoutSeconds\x12\x18\n" +
+	"\abuiltin\x18\f \x01(\tR\abuiltin\x12\x16\n" +
+	"\x06target\x18\r \x01(\tR\x06target2\x86\x02\n" +
 	"\fAgentService\x12;\n" +
-	"\fReportStatus\x12\x14.agent.StatusRequest\x1a\x15.agent.StatusResponseB\x16Z\x14image-manager/pkg/pbb\x06proto3"
+	"\fReportStatus\x12\x14.agent.StatusRequest\x1a\x15.agent.StatusResponse\x126\n" +
+	"\vGetTestPlan\x12\x16.agent.TestPlanRequest\x1a\x0f.agent.TestPlan\x12:\n" +
+	"\aSession\x12\x14.agent.StatusRequest\x1a\x15.agent.StatusResponse(\x010\x01\x12E\n" +
+	"\x11UploadDiagnostics\x12\x17.agent.DiagnosticsChunk\x1a\x15.agent.DiagnosticsAck(\x01B\x16Z\x14image-manager/pkg/pbb\x06proto3"
 
 var (
 	file_pkg_proto_agent_proto_rawDescOnce sync.Once
@@ -163,19 +990,39 @@ func file_pkg_proto_agent_proto_rawDescGZIP() []byte {
 	return file_pkg_proto_agent_proto_rawDescData
 }
 
-var file_pkg_proto_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_pkg_proto_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_pkg_proto_agent_proto_goTypes = []any{
-	(*StatusRequest)(nil),  // 0: agent.StatusRequest
-	(*StatusResponse)(nil), // 1: agent.StatusResponse
+	(*StatusRequest)(nil),    // 0: agent.StatusRequest
+	(*HostFacts)(nil),        // 1: agent.HostFacts
+	(*DiskFact)(nil),         // 2: agent.DiskFact
+	(*NicFact)(nil),          // 3: agent.NicFact
+	(*CheckResult)(nil),      // 4: agent.CheckResult
+	(*StatusResponse)(nil),   // 5: agent.StatusResponse
+	(*DiagnosticsChunk)(nil), // 6: agent.DiagnosticsChunk
+	(*DiagnosticsAck)(nil),   // 7: agent.DiagnosticsAck
+	(*TestPlanRequest)(nil),  // 8: agent.TestPlanRequest
+	(*TestPlan)(nil),         // 9: agent.TestPlan
+	(*Check)(nil),            // 10: agent.Check
 }
 var file_pkg_proto_agent_proto_depIdxs = []int32{
-	0, // 0: agent.AgentService.ReportStatus:input_type -> agent.StatusRequest
-	1, // 1: agent.AgentService.ReportStatus:output_type -> agent.StatusResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	4,  // 0: agent.StatusRequest.results:type_name -> agent.CheckResult
+	1,  // 1: agent.StatusRequest.facts:type_name -> agent.HostFacts
+	2,  // 2: agent.HostFacts.disks:type_name -> agent.DiskFact
+	3,  // 3: agent.HostFacts.nics:type_name -> agent.NicFact
+	10, // 4: agent.TestPlan.checks:type_name -> agent.Check
+	0,  // 5: agent.AgentService.ReportStatus:input_type -> agent.StatusRequest
+	8,  // 6: agent.AgentService.GetTestPlan:input_type -> agent.TestPlanRequest
+	0,  // 7: agent.AgentService.Session:input_type -> agent.StatusRequest
+	6,  // 8: agent.AgentService.UploadDiagnostics:input_type -> agent.DiagnosticsChunk
+	5,  // 9: agent.AgentService.ReportStatus:output_type -> agent.StatusResponse
+	9,  // 10: agent.AgentService.GetTestPlan:output_type -> agent.TestPlan
+	5,  // 11: agent.AgentService.Session:output_type -> agent.StatusResponse
+	7,  // 12: agent.AgentService.UploadDiagnostics:output_type -> agent.DiagnosticsAck
+	9,  // [9:13] is the sub-list for method output_type
+	5,  // [5:9] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_pkg_proto_agent_proto_init() }
@@ -189,7 +1036,7 @@ func file_pkg_proto_agent_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pkg_proto_agent_proto_rawDesc), len(file_pkg_proto_agent_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   11,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,7 +19,10 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	AgentService_ReportStatus_FullMethodName = "/agent.AgentService/ReportStatus"
+	AgentService_ReportStatus_FullMethodName      = "/agent.AgentService/ReportStatus"
+	AgentService_GetTestPlan_FullMethodName       = "/agent.AgentService/GetTestPlan"
+	AgentService_Session_FullMethodName           = "/agent.AgentService/Session"
+	AgentService_UploadDiagnostics_FullMethodName = "/agent.AgentService/UploadDiagnostics"
 )
 
 // AgentServiceClient is the client API for AgentService service.
@@ -31,6 +34,16 @@ type AgentServiceClient interface {
 	// Метод ReportStatus.
 	// Агент вызывает его, чтобы сказать: "Я запустился, вот мои проверки".
 	ReportStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// Метод GetTestPlan.
+	// Агент спрашивает: "Что мне проверить?" Проверки описаны в YAML дистрибутива.
+	GetTestPlan(ctx context.Context, in *TestPlanRequest, opts ...grpc.CallOption) (*TestPlan, error)
+	// Метод Session.
+	// Двусторонний стрим на все время тестов: START сразу после загрузки, HEARTBEAT по таймеру,
+	// CHECK_DONE после каждой проверки и TESTS_DONE с итогом. На каждое сообщение — ответ с командой.
+	Session(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[StatusRequest, StatusResponse], error)
+	// Метод UploadDiagnostics.
+	// Агент заливает архив диагностики упавшей VM кусками (по команде COLLECT_DIAGNOSTICS).
+	UploadDiagnostics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[DiagnosticsChunk, DiagnosticsAck], error)
 }
 
 type agentServiceClient struct {
@@ -51,6 +64,42 @@ func (c *agentServiceClient) ReportStatus(ctx context.Context, in *StatusRequest
 	return out, nil
 }
 
+func (c *agentServiceClient) GetTestPlan(ctx context.Context, in *TestPlanRequest, opts ...grpc.CallOption) (*TestPlan, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TestPlan)
+	err := c.cc.Invoke(ctx, AgentService_GetTestPlan_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) Session(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[StatusRequest, StatusResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[0], AgentService_Session_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StatusRequest, StatusResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AgentService_SessionClient = grpc.BidiStreamingClient[StatusRequest, StatusResponse]
+
+func (c *agentServiceClient) UploadDiagnostics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[DiagnosticsChunk, DiagnosticsAck], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[1], AgentService_UploadDiagnostics_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[DiagnosticsChunk, DiagnosticsAck]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AgentService_UploadDiagnosticsClient = grpc.ClientStreamingClient[DiagnosticsChunk, DiagnosticsAck]
+
 // AgentServiceServer is the server API for AgentService service.
 // All implementations must embed UnimplementedAgentServiceServer
 // for forward compatibility.
@@ -60,6 +109,16 @@ type AgentServiceServer interface {
 	// Метод ReportStatus.
 	// Агент вызывает его, чтобы сказать: "Я запустился, вот мои проверки".
 	ReportStatus(context.Context, *StatusRequest) (*StatusResponse, error)
+	// Метод GetTestPlan.
+	// Агент спрашивает: "Что мне проверить?" Проверки описаны в YAML дистрибутива.
+	GetTestPlan(context.Context, *TestPlanRequest) (*TestPlan, error)
+	// Метод Session.
+	// Двусторонний стрим на все время тестов: START сразу после загрузки, HEARTBEAT по таймеру,
+	// CHECK_DONE после каждой проверки и TESTS_DONE с итогом. На каждое сообщение — ответ с командой.
+	Session(grpc.BidiStreamingServer[StatusRequest, StatusResponse]) error
+	// Метод UploadDiagnostics.
+	// Агент заливает архив диагностики упавшей VM кусками (по команде COLLECT_DIAGNOSTICS).
+	UploadDiagnostics(grpc.ClientStreamingServer[DiagnosticsChunk, DiagnosticsAck]) error
 	mustEmbedUnimplementedAgentServiceServer()
 }
 
@@ -73,6 +132,15 @@ type UnimplementedAgentServiceServer struct{}
 func (UnimplementedAgentServiceServer) ReportStatus(context.Context, *StatusRequest) (*StatusResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method ReportStatus not implemented")
 }
+func (UnimplementedAgentServiceServer) GetTestPlan(context.Context, *TestPlanRequest) (*TestPlan, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetTestPlan not implemented")
+}
+func (UnimplementedAgentServiceServer) Session(grpc.BidiStreamingServer[StatusRequest, StatusResponse]) error {
+	return status.Error(codes.Unimplemented, "method Session not implemented")
+}
+func (UnimplementedAgentServiceServer) UploadDiagnostics(grpc.ClientStreamingServer[DiagnosticsChunk, DiagnosticsAck]) error {
+	return status.Error(codes.Unimplemented, "method UploadDiagnostics not implemented")
+}
 func (UnimplementedAgentServiceServer) mustEmbedUnimplementedAgentServiceServer() {}
 func (UnimplementedAgentServiceServer) testEmbeddedByValue()                      {}
 
@@ -112,6 +180,38 @@ func _AgentService_ReportStatus_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AgentService_GetTestPlan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TestPlanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).GetTestPlan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_GetTestPlan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).GetTestPlan(ctx, req.(*TestPlanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_Session_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServiceServer).Session(&grpc.GenericServerStream[StatusRequest, StatusResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AgentService_SessionServer = grpc.BidiStreamingServer[StatusRequest, StatusResponse]
+
+func _AgentService_UploadDiagnostics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServiceServer).UploadDiagnostics(&grpc.GenericServerStream[DiagnosticsChunk, DiagnosticsAck]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AgentService_UploadDiagnosticsServer = grpc.ClientStreamingServer[DiagnosticsChunk, DiagnosticsAck]
+
 // AgentService_ServiceDesc is the grpc.ServiceDesc for AgentService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -123,7 +223,23 @@ var AgentService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ReportStatus",
 			Handler:    _AgentService_ReportStatus_Handler,
 		},
+		{
+			MethodName: "GetTestPlan",
+			Handler:    _AgentService_GetTestPlan_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Session",
+			Handler:       _AgentService_Session_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "UploadDiagnostics",
+			Handler:       _AgentService_UploadDiagnostics_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "pkg/proto/agent.proto",
 }
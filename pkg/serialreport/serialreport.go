@@ -0,0 +1,115 @@
+// Package serialreport — запасной канал отчетов агента через серийную консоль VM.
+//
+// Если из сети тенанта не видно GRPC_PUBLIC_ADDRESS, агент пишет в консоль строку
+//
+//	IMGMGR-REPORT {"v":1,"payload":"<base64(protojson StatusRequest)>","sig":"<hex HMAC-SHA256(payload)>"}
+//
+// а менеджер находит ее в Nova console output и обрабатывает как обычный gRPC-отчет.
+// Подпись не дает подделать отчет тому, кто может писать в консоль чужой VM.
+//
+// Ключ подписи свой у каждой сборки — TokenKey от токена из user data. Сам токен
+// в консоль не пишется: console output видят все, у кого есть доступ к проекту.
+package serialreport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pb "image-manager/pkg/pb"
+)
+
+// Prefix — метка строки с отчетом в выводе консоли.
+const Prefix = "IMGMGR-REPORT "
+
+const version = 1
+
+type envelope struct {
+	V       int    `json:"v"`
+	Payload string `json:"payload"`
+	Sig     string `json:"sig"`
+}
+
+// TokenKey — ключ подписи отчетов сборки: SHA-256 токена агента.
+// Менеджер хранит в БД именно этот хеш, сам токен есть только у VM.
+func TokenKey(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// Encode сериализует и подписывает отчет. Возвращает строку без перевода строки.
+func Encode(req *pb.StatusRequest, key []byte) (string, error) {
+	if len(key) == 0 {
+		return "", errors.New("serialreport: empty signing key")
+	}
+
+	data, err := protojson.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("serialreport: marshal: %w", err)
+	}
+
+	payload := base64.StdEncoding.EncodeToString(data)
+	line, err := json.Marshal(envelope{V: version, Payload: payload, Sig: sign(payload, key)})
+	if err != nil {
+		return "", fmt.Errorf("serialreport: marshal envelope: %w", err)
+	}
+	return Prefix + string(line), nil
+}
+
+// Decode проверяет подпись одной строки с отчетом и возвращает отчет.
+func Decode(line string, key []byte) (*pb.StatusRequest, error) {
+	idx := strings.Index(line, Prefix)
+	if idx < 0 {
+		return nil, errors.New("serialreport: no marker")
+	}
+
+	var env envelope
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line[idx+len(Prefix):])), &env); err != nil {
+		return nil, fmt.Errorf("serialreport: bad envelope: %w", err)
+	}
+	if env.V != version {
+		return nil, fmt.Errorf("serialreport: unsupported version %d", env.V)
+	}
+	if !hmac.Equal([]byte(env.Sig), []byte(sign(env.Payload, key))) {
+		return nil, errors.New("serialreport: bad signature")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("serialreport: bad payload: %w", err)
+	}
+
+	var req pb.StatusRequest
+	if err := protojson.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("serialreport: unmarshal: %w", err)
+	}
+	return &req, nil
+}
+
+// Scan ищет в выводе консоли все отчеты с верной подписью (в порядке появления).
+// Строки с неверной подписью пропускаются.
+func Scan(output string, key []byte) []*pb.StatusRequest {
+	var result []*pb.StatusRequest
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, Prefix) {
+			continue
+		}
+		if req, err := Decode(line, key); err == nil {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+func sign(payload string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
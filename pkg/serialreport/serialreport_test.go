@@ -0,0 +1,129 @@
+package serialreport
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "image-manager/pkg/pb"
+)
+
+func testReport(vmID string) *pb.StatusRequest {
+	return &pb.StatusRequest{
+		VmId:    vmID,
+		Phase:   "TESTS_DONE",
+		Success: true,
+		Details: "all checks passed",
+		Results: []*pb.CheckResult{{Name: "cloud_init", Status: "PASS"}},
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	key := TokenKey("build-token")
+	req := testReport("vm-1")
+
+	line, err := Encode(req, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, Prefix) || strings.Contains(line, "\n") {
+		t.Fatalf("bad line: %q", line)
+	}
+
+	// Консоль добавляет к строке свой мусор: метку времени ядра, \r
+	got, err := Decode("[   42.123] "+line+"\r", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, req) {
+		t.Errorf("got %v, want %v", got, req)
+	}
+}
+
+func TestEncodeEmptyKey(t *testing.T) {
+	if _, err := Encode(testReport("vm-1"), nil); err == nil {
+		t.Error("expected an error for an empty key")
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	key := TokenKey("build-token")
+	line, err := Encode(testReport("vm-1"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := Encode(testReport("vm-1"), TokenKey("other-build-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"no marker", "cloud-init finished", "no marker"},
+		{"bad envelope", Prefix + "{not json", "bad envelope"},
+		{"unsupported version", Prefix + `{"v":2,"payload":"","sig":""}`, "unsupported version"},
+		{"key of another build", forged, "bad signature"},
+		{"tampered payload", strings.Replace(line, `"payload":"`, `"payload":"AA`, 1), "bad signature"},
+		{"tampered signature", strings.Replace(line, `"sig":"`, `"sig":"00`, 1), "bad signature"},
+		{"truncated", line[:len(line)-10], "bad envelope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.line, key)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	key := TokenKey("build-token")
+	mine, err := Encode(testReport("vm-1"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// vm_id чужой VM под верной подписью: Scan его вернет, отбросить — дело менеджера
+	foreign, err := Encode(testReport("vm-2"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := Encode(testReport("vm-1"), TokenKey("other-build-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := strings.Join([]string{
+		"[    1.000] Linux version 6.8.0",
+		forged,
+		"ubuntu login: " + mine, // Отчет посреди чужого вывода
+		Prefix + "{broken",
+		foreign + "\r",
+		"",
+	}, "\n")
+
+	reports := Scan(output, key)
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want 2", len(reports))
+	}
+	if reports[0].VmId != "vm-1" || reports[1].VmId != "vm-2" {
+		t.Errorf("got vm_ids %s, %s; want vm-1, vm-2 in order", reports[0].VmId, reports[1].VmId)
+	}
+
+	if got := Scan(output, TokenKey("unknown")); len(got) != 0 {
+		t.Errorf("got %d reports with a wrong key, want 0", len(got))
+	}
+}
+
+func TestTokenKey(t *testing.T) {
+	if string(TokenKey("a")) == string(TokenKey("b")) {
+		t.Error("different tokens give the same key")
+	}
+	if len(TokenKey("a")) != 32 {
+		t.Errorf("key length %d, want 32", len(TokenKey("a")))
+	}
+}